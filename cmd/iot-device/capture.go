@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"iot-device-simulator/internal/api"
+	"iot-device-simulator/internal/capture"
+	"iot-device-simulator/internal/config"
+)
+
+// captureOptions holds the parsed arguments of the record and play subcommands.
+type captureOptions struct {
+	configFile string
+	overrides  config.Overrides
+
+	file     string        // capture log to write or read
+	duration time.Duration // record only: stop after this long (0 runs until interrupted)
+	speed    float64       // play only: playback speed
+	requests bool          // play only: also republish requests to the device
+}
+
+// addCaptureFlags registers the flags shared by record and play. The config file is
+// optional for both: the device ID and NATS URL may come from flags or IOT_* variables.
+func addCaptureFlags(fs *flag.FlagSet, opts *captureOptions) {
+	fs.StringVar(&opts.configFile, "config", os.Getenv(envConfigFile), "path to the YAML configuration file (env "+envConfigFile+")")
+	fs.StringVar(&opts.overrides.DeviceID, "device-id", "", "device ID (env "+config.EnvDeviceID+")")
+	fs.StringVar(&opts.overrides.NATSURL, "nats-url", "", "NATS server URL (env "+config.EnvNATSURL+")")
+}
+
+// parseRecordFlags parses the arguments of the record subcommand.
+func parseRecordFlags(programName string, args []string) (captureOptions, error) {
+	var opts captureOptions
+
+	fs := flag.NewFlagSet(programName+" record", flag.ContinueOnError)
+	addCaptureFlags(fs, &opts)
+	fs.StringVar(&opts.file, "o", "", "capture log to write (default <device-id>-<time>.ndjson.gz)")
+	fs.DurationVar(&opts.duration, "duration", 0, "stop recording after this long (default until interrupted)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s record [flags] [config.yml]\n\n", programName)
+		fmt.Fprintf(fs.Output(), "Writes every message on iot.<device-id>.> to a gzip-compressed NDJSON log.\n\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if fs.NArg() > 0 {
+		opts.configFile = fs.Arg(0)
+	}
+	if opts.duration < 0 {
+		return opts, fmt.Errorf("-duration must not be negative")
+	}
+	return opts, nil
+}
+
+// parsePlayFlags parses the arguments of the play subcommand.
+func parsePlayFlags(programName string, args []string) (captureOptions, error) {
+	opts := captureOptions{speed: 1}
+
+	fs := flag.NewFlagSet(programName+" play", flag.ContinueOnError)
+	addCaptureFlags(fs, &opts)
+	fs.Float64Var(&opts.speed, "speed", opts.speed, "playback speed: 1 keeps the original timing, 10 plays ten times faster, 0 as fast as possible")
+	fs.BoolVar(&opts.requests, "requests", false, "also republish requests to the device, such as config.update and alarms.ack")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s play [flags] capture.ndjson.gz\n\n", programName)
+		fmt.Fprintf(fs.Output(), "Republishes the events of a log written by record to NATS.\n\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return opts, fmt.Errorf("one capture log is required")
+	}
+	opts.file = fs.Arg(0)
+	if opts.speed < 0 {
+		return opts, fmt.Errorf("-speed must not be negative")
+	}
+	return opts, nil
+}
+
+// config resolves the device ID and NATS URL: from the config file if one is given,
+// otherwise from the defaults, with environment then flag overrides applied.
+func (o captureOptions) config() (*config.Config, error) {
+	if o.configFile != "" {
+		cfg, _, err := loadConfig(o.configFile, o.overrides)
+		return cfg, err
+	}
+
+	cfg := &config.Config{LogLevel: "info"}
+	cfg.NATS.URL = nats.DefaultURL
+	config.EnvOverrides(os.LookupEnv).Apply(cfg)
+	o.overrides.Apply(cfg)
+	return cfg, nil
+}
+
+// signalContext returns a context canceled on SIGINT or SIGTERM.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// runRecord implements the record subcommand: it subscribes to every subject of the
+// device and writes the received messages to a capture log until interrupted.
+func runRecord(program string, args []string) (err error) {
+	opts, err := parseRecordFlags(filepath.Base(program), args)
+	if err != nil {
+		return err
+	}
+	cfg, err := opts.config()
+	if err != nil {
+		return err
+	}
+	setupLogging(cfg.LogLevel)
+	if cfg.DeviceID == "" {
+		return fmt.Errorf("a device ID is required (-device-id, %s or a config file)", config.EnvDeviceID)
+	}
+	if opts.file == "" {
+		opts.file = fmt.Sprintf("%s-%s.ndjson.gz", cfg.DeviceID, time.Now().UTC().Format("20060102T150405Z"))
+	}
+
+	nc, err := nats.Connect(cfg.NATS.URL)
+	if err != nil {
+		return fmt.Errorf("connecting to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	f, err := os.Create(opts.file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	w := capture.NewWriter(f)
+
+	subject := fmt.Sprintf("iot.%s.>", cfg.DeviceID)
+	msgs := make(chan *nats.Msg, 4096)
+	sub, err := nc.ChanSubscribe(subject, msgs)
+	if err != nil {
+		return fmt.Errorf("subscribing to %s: %w", subject, err)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	if opts.duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opts.duration)
+		defer cancel()
+	}
+
+	log.Printf("Recording %s to %s", subject, opts.file)
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+
+	n := 0
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-flush.C:
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		case msg := <-msgs:
+			if err := w.Write(msg, time.Now()); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+
+	// Keep the messages already delivered to the channel.
+	if err := sub.Unsubscribe(); err != nil {
+		slog.Error(fmt.Sprintf("Error unsubscribing from %s: %v", subject, err))
+	}
+	for len(msgs) > 0 {
+		if err := w.Write(<-msgs, time.Now()); err != nil {
+			return err
+		}
+		n++
+	}
+	if dropped, err := sub.Dropped(); err == nil && dropped > 0 {
+		slog.Warn(fmt.Sprintf("Dropped %d messages the recorder could not keep up with", dropped))
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("Recorded %d messages to %s", n, opts.file)
+	return nil
+}
+
+// runPlay implements the play subcommand: it republishes a capture log to NATS with
+// its original timing, scaled by the speed.
+func runPlay(program string, args []string) error {
+	opts, err := parsePlayFlags(filepath.Base(program), args)
+	if err != nil {
+		return err
+	}
+	cfg, err := opts.config()
+	if err != nil {
+		return err
+	}
+	setupLogging(cfg.LogLevel)
+
+	f, err := os.Open(opts.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := capture.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	nc, err := nats.Connect(cfg.NATS.URL)
+	if err != nil {
+		return fmt.Errorf("connecting to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	playOpts := capture.PlayOptions{Speed: opts.speed}
+	if !opts.requests {
+		// Replaying requests would change the live device, e.g. its configuration.
+		playOpts.Skip = isRequest
+	}
+
+	log.Printf("Playing %s to %s at %gx speed", opts.file, cfg.NATS.URL, opts.speed)
+	n, skipped, err := capture.Play(ctx, r, nc, playOpts)
+	if flushErr := nc.Flush(); err == nil {
+		err = flushErr
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Printf("Playback interrupted after %d messages", n)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if skipped > 0 {
+		log.Printf("Played %d messages from %s, skipped %d requests", n, opts.file, skipped)
+	} else {
+		log.Printf("Played %d messages from %s", n, opts.file)
+	}
+	return nil
+}
+
+// isRequest reports whether a recorded message was a request to the device: sent with a
+// reply subject, or on one of the subjects the device answers requests on.
+func isRequest(m capture.Message) bool {
+	return m.Reply != "" || api.IsRequestSubject(m.Subject)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/export"
+	"iot-device-simulator/internal/sensor"
+	"iot-device-simulator/internal/storage"
+)
+
+// exportOptions holds the parsed arguments of the export subcommand.
+type exportOptions struct {
+	configFile string
+	overrides  config.Overrides
+
+	sensors        string
+	from, to       string
+	format         string
+	output         string
+	includeErrors  bool
+	faults         bool
+	deviceMetadata bool
+}
+
+// parseExportFlags parses the arguments of the export subcommand.
+func parseExportFlags(programName string, args []string) (exportOptions, error) {
+	opts := exportOptions{output: "-", includeErrors: true}
+
+	fs := flag.NewFlagSet(programName+" export", flag.ContinueOnError)
+	fs.StringVar(&opts.configFile, "config", "", "path to the YAML configuration file (env "+envConfigFile+")")
+	addOverrideFlags(fs, &opts.overrides)
+	fs.StringVar(&opts.sensors, "sensors", "", "comma-separated sensor IDs to export (default all)")
+	fs.StringVar(&opts.from, "from", "", "export readings at or after this RFC 3339 time")
+	fs.StringVar(&opts.to, "to", "", "export readings before this RFC 3339 time")
+	fs.StringVar(&opts.format, "format", "", "output format: csv, ndjson or parquet (default from the -o extension, else csv)")
+	fs.StringVar(&opts.output, "o", opts.output, "output file, - for standard output")
+	fs.BoolVar(&opts.includeErrors, "errors", opts.includeErrors, "include readings that failed with a communication error")
+	fs.BoolVar(&opts.faults, "faults", false, "add fault label columns (fault, error)")
+	fs.BoolVar(&opts.deviceMetadata, "device-metadata", false, "add device metadata columns (device_id, tag_<key>)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export [flags] [config.yml]\n\n", programName)
+		fmt.Fprintf(fs.Output(), "Streams the stored readings of the configured device into a file.\n\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if err := resolveConfigFile(fs, &opts.configFile); err != nil {
+		return opts, err
+	}
+
+	if opts.format == "" {
+		opts.format = export.FormatFromPath(opts.output)
+	}
+	switch opts.format {
+	case "":
+		opts.format = export.FormatCSV
+	case export.FormatCSV, export.FormatNDJSON, export.FormatParquet:
+	default:
+		return opts, fmt.Errorf("-format: unknown format %q (want csv, ndjson or parquet)", opts.format)
+	}
+	return opts, nil
+}
+
+// query builds the storage query of the export for a device.
+func (o exportOptions) query(deviceID string) (storage.ReadingQuery, error) {
+	q := storage.ReadingQuery{DeviceID: deviceID, IncludeErrors: o.includeErrors}
+	for _, id := range strings.Split(o.sensors, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			q.SensorIDs = append(q.SensorIDs, id)
+		}
+	}
+
+	var err error
+	if o.from != "" {
+		if q.From, err = time.Parse(time.RFC3339, o.from); err != nil {
+			return q, fmt.Errorf("-from: %w", err)
+		}
+	}
+	if o.to != "" {
+		if q.To, err = time.Parse(time.RFC3339, o.to); err != nil {
+			return q, fmt.Errorf("-to: %w", err)
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, fmt.Errorf("-from must be before -to")
+	}
+	return q, nil
+}
+
+// valueKeys returns the sorted names of the values of the multi-value sensors, plus
+// "delta" for counters publishing it, limited to sensorIDs if any are given.
+func valueKeys(sensors []config.SensorConfig, sensorIDs []string) []string {
+	keys := make(map[string]bool)
+	for _, sc := range selectedSensors(sensors, sensorIDs) {
+		for _, name := range sensor.Fields(sc) {
+			keys[name] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(keys))
+}
+
+// multiValueSensors returns the IDs of the multi-value sensors, whose scalar value is
+// unused, limited to sensorIDs if any are given.
+func multiValueSensors(sensors []config.SensorConfig, sensorIDs []string) []string {
+	var ids []string
+	for _, sc := range selectedSensors(sensors, sensorIDs) {
+		if sensor.MultiValue(sc) {
+			ids = append(ids, sc.ID)
+		}
+	}
+	return ids
+}
+
+// hasDiscrete reports whether any discrete sensor is exported, limited to sensorIDs if any are given.
+func hasDiscrete(sensors []config.SensorConfig, sensorIDs []string) bool {
+	return slices.ContainsFunc(selectedSensors(sensors, sensorIDs), func(sc config.SensorConfig) bool {
+		return sc.Discrete != nil
+	})
+}
+
+// selectedSensors returns the sensors whose IDs are in sensorIDs, or all of them if it is empty.
+func selectedSensors(sensors []config.SensorConfig, sensorIDs []string) []config.SensorConfig {
+	if len(sensorIDs) == 0 {
+		return sensors
+	}
+	var selected []config.SensorConfig
+	for _, sc := range sensors {
+		if slices.Contains(sensorIDs, sc.ID) {
+			selected = append(selected, sc)
+		}
+	}
+	return selected
+}
+
+// runExport implements the export subcommand: it reads the readings of the configured
+// device from its storage backend and writes them as CSV, NDJSON or Parquet.
+func runExport(program string, args []string) (err error) {
+	opts, err := parseExportFlags(filepath.Base(program), args)
+	if err != nil {
+		return err
+	}
+
+	cfg, _, err := loadConfig(opts.configFile, opts.overrides)
+	if err != nil {
+		return err
+	}
+	setupLogging(cfg.LogLevel)
+
+	q, err := opts.query(cfg.DeviceID)
+	if err != nil {
+		return err
+	}
+	cols := export.Columns{
+		ValueKeys:  valueKeys(cfg.Sensors, q.SensorIDs),
+		MultiValue: multiValueSensors(cfg.Sensors, q.SensorIDs),
+		State:      hasDiscrete(cfg.Sensors, q.SensorIDs),
+		Faults:     opts.faults,
+		Device:     opts.deviceMetadata,
+	}
+	if opts.deviceMetadata {
+		cols.TagKeys = slices.Sorted(maps.Keys(cfg.Tags))
+	}
+
+	switch cfg.Storage.Backend {
+	case storage.BackendMemory, storage.BackendNone:
+		return fmt.Errorf("the %s storage backend keeps no readings to export", cfg.Storage.Backend)
+	}
+
+	// Read straight from the backend: no write batching and no cache.
+	cfg.Storage.Batch.Enabled = false
+	noCache := false
+	cfg.Storage.Cache = &noCache
+	store, err := storage.Open(cfg.Storage, cfg.DeviceID)
+	if err != nil {
+		return fmt.Errorf("opening %s storage: %w", cfg.Storage.Backend, err)
+	}
+	defer store.Close()
+
+	var out io.Writer = os.Stdout
+	if opts.output != "-" {
+		f, err := os.Create(opts.output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		out = f
+	}
+
+	w, err := export.NewWriter(opts.format, out, cols)
+	if err != nil {
+		return err
+	}
+	n, err := export.Export(context.Background(), store, q, w)
+	if err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("Exported %d readings of device %s as %s to %s", n, cfg.DeviceID, opts.format, opts.output)
+	return nil
+}
@@ -2,70 +2,236 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"syscall"
+	"time"
 
 	"github.com/nats-io/nats.go"
 
+	"iot-device-simulator/internal/api"
 	"iot-device-simulator/internal/config"
 	"iot-device-simulator/internal/device"
 	"iot-device-simulator/internal/storage"
+	"iot-device-simulator/internal/telemetry"
 )
 
+// envConfigFile names the environment variable holding the config file path.
+const envConfigFile = "IOT_CONFIG"
+
+// options holds the parsed command-line arguments.
+type options struct {
+	configFile string
+	overrides  config.Overrides
+}
+
+// parseFlags parses the command-line arguments. The config file may be given with
+// -config, as the first positional argument, or through IOT_CONFIG.
+func parseFlags(args []string) (options, error) {
+	var opts options
+
+	programName := filepath.Base(args[0])
+	fs := flag.NewFlagSet(programName, flag.ContinueOnError)
+	fs.StringVar(&opts.configFile, "config", "", "path to the YAML configuration file (env "+envConfigFile+")")
+	addOverrideFlags(fs, &opts.overrides)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] [config.yml]\n", programName)
+		fmt.Fprintf(fs.Output(), "       %s export [flags] [config.yml]\n", programName)
+		fmt.Fprintf(fs.Output(), "       %s record [flags] [config.yml]\n", programName)
+		fmt.Fprintf(fs.Output(), "       %s play [flags] capture.ndjson.gz\n", programName)
+		fmt.Fprintf(fs.Output(), "       %s migrate [flags] [config.yml]\n\n", programName)
+		fmt.Fprintf(fs.Output(), "Settings are resolved in this order, highest first:\n")
+		fmt.Fprintf(fs.Output(), "  flags > IOT_* environment variables > config file (with ${VAR} interpolation) > defaults\n\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return opts, err
+	}
+
+	if err := resolveConfigFile(fs, &opts.configFile); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// addOverrideFlags registers the flags that override config file settings.
+func addOverrideFlags(fs *flag.FlagSet, o *config.Overrides) {
+	fs.StringVar(&o.DeviceID, "device-id", "", "device ID (env "+config.EnvDeviceID+")")
+	fs.StringVar(&o.NATSURL, "nats-url", "", "NATS server URL (env "+config.EnvNATSURL+")")
+	fs.StringVar(&o.Storage, "storage", "", "storage backend: mongodb, memory, sqlite or none (env "+config.EnvStorage+")")
+	fs.StringVar(&o.MongoURI, "mongo-uri", "", "MongoDB connection URI (env "+config.EnvMongoURI+")")
+	fs.StringVar(&o.MongoDatabase, "mongo-db", "", "MongoDB database name (env "+config.EnvMongoDatabase+")")
+	fs.StringVar(&o.SQLitePath, "sqlite-path", "", "SQLite database file (env "+config.EnvSQLitePath+")")
+	fs.StringVar(&o.LogLevel, "log-level", "", "log level: debug, info, warn or error (env "+config.EnvLogLevel+")")
+}
+
+// resolveConfigFile falls back to the first positional argument of the parsed fs,
+// then to IOT_CONFIG, when no config file was given with -config.
+func resolveConfigFile(fs *flag.FlagSet, configFile *string) error {
+	if *configFile == "" {
+		*configFile = fs.Arg(0)
+	}
+	if *configFile == "" {
+		*configFile = os.Getenv(envConfigFile)
+	}
+	if *configFile == "" {
+		fs.Usage()
+		return fmt.Errorf("configuration file is required")
+	}
+	return nil
+}
+
+// loadConfig loads the config file, applying environment then flag overrides.
+// It returns the overrides too, so reloads resolve settings the same way.
+func loadConfig(configFile string, flagOverrides config.Overrides) (*config.Config, []config.Overrides, error) {
+	overrides := []config.Overrides{config.EnvOverrides(os.LookupEnv), flagOverrides}
+	cfg, err := config.Load(configFile, overrides...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading config from %s: %w", configFile, err)
+	}
+	return cfg, overrides, nil
+}
+
+// setupLogging routes the standard logger through slog with the given minimum level.
+// Plain log.Printf calls are logged at info level; failures call slog.Error or slog.Warn.
+func setupLogging(level string) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: lvl})))
+}
+
+// fatalf logs at error level, so the message survives any configured log level, and exits.
+func fatalf(format string, args ...any) {
+	slog.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
 func main() {
-	// Load configuration - mandatory
-	configFile := ""
+	// Subcommands
 	if len(os.Args) > 1 {
-		configFile = os.Args[1]
-	} else {
-		programName := filepath.Base(os.Args[0])
-		log.Fatalf("Configuration file is required. Usage: %s <config.yml>", programName)
+		switch os.Args[1] {
+		case "export":
+			if err := runExport(os.Args[0], os.Args[2:]); err != nil {
+				if errors.Is(err, flag.ErrHelp) {
+					os.Exit(0)
+				}
+				fatalf("Export failed: %v", err)
+			}
+			return
+		case "record":
+			if err := runRecord(os.Args[0], os.Args[2:]); err != nil {
+				if errors.Is(err, flag.ErrHelp) {
+					os.Exit(0)
+				}
+				fatalf("Record failed: %v", err)
+			}
+			return
+		case "migrate":
+			if err := runMigrate(os.Args[0], os.Args[2:]); err != nil {
+				if errors.Is(err, flag.ErrHelp) {
+					os.Exit(0)
+				}
+				fatalf("Migration failed: %v", err)
+			}
+			return
+		case "play":
+			if err := runPlay(os.Args[0], os.Args[2:]); err != nil {
+				if errors.Is(err, flag.ErrHelp) {
+					os.Exit(0)
+				}
+				fatalf("Play failed: %v", err)
+			}
+			return
+		}
 	}
 
-	cfg, err := config.Load(configFile)
+	// Parse flags - the configuration file is mandatory
+	opts, err := parseFlags(os.Args)
 	if err != nil {
-		log.Fatalf("Error loading config from %s: %v", configFile, err)
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fatalf("%v", err)
 	}
 
+	// Load configuration, applying environment then flag overrides
+	cfg, overrides, err := loadConfig(opts.configFile, opts.overrides)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	setupLogging(cfg.LogLevel)
+
 	// Log view sensors active
 	log.Printf("Loaded %d sensors from config file", len(cfg.Sensors))
 	for _, sensor := range cfg.Sensors {
 		log.Printf("  - %s (%s): %v enabled=%v", sensor.ID, sensor.Type, sensor.Frequency, sensor.Enabled)
 	}
 
-	// Connect to MongoDB
-	mongodb, err := storage.NewMongoDB("mongodb://localhost:27017", "iot_simulator")
+	// Set up tracing
+	shutdownTracing, err := telemetry.Setup(context.Background(), cfg.DeviceID, cfg.Telemetry)
 	if err != nil {
-		log.Printf("Warning: MongoDB not available, running without persistence: %v", err)
-		mongodb = nil
-	} else {
-		log.Println("Connected to MongoDB")
-		defer mongodb.Close()
+		fatalf("Error setting up telemetry: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error(fmt.Sprintf("Error shutting down telemetry: %v", err))
+		}
+	}()
+
+	// Open the storage backend
+	store, err := storage.Open(cfg.Storage, cfg.DeviceID)
+	switch {
+	case errors.Is(err, storage.ErrMigrationRequired):
+		fatalf("%v; stop every simulator writing to it and run %s migrate", err, filepath.Base(os.Args[0]))
+	case err != nil:
+		slog.Warn("Storage not available, keeping readings in memory only", "backend", cfg.Storage.Backend, "error", err)
+		store = storage.NewMemory(cfg.Storage.Memory.Capacity)
+	case store == nil:
+		log.Println("Storage disabled, running without persistence")
+	default:
+		log.Printf("Connected to %s storage", cfg.Storage.Backend)
 	}
 
 	// Connect to NATS
 	nc, err := nats.Connect(cfg.NATS.URL)
 	if err != nil {
-		log.Fatal("Error connecting to NATS:", err)
+		fatalf("Error connecting to NATS: %v", err)
 	}
 	defer nc.Close()
 
 	// Create and start the device
-	dev := device.NewDevice(cfg, nc, mongodb)
+	dev := device.NewDevice(cfg, nc, store)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	dev.StartDevice(ctx)
 
+	// Watch the config file for changes
+	if cfg.ReloadInterval > 0 {
+		go config.Watch(ctx, opts.configFile, cfg.ReloadInterval, func(newCfg *config.Config) {
+			log.Printf("Config file %s changed, reloading", opts.configFile)
+			dev.Reload(newCfg)
+		}, overrides...)
+	}
+
 	log.Printf("NATS subjects:")
-	log.Printf("  - iot.%s.config (get sensor configs)", dev.GetID())
-	log.Printf("  - iot.%s.config.update (update sensor configs)", dev.GetID())
-	log.Printf("  - iot.%s.status (get device status)", dev.GetID())
-	log.Printf("  - iot.%s.readings.* (sensor readings)", dev.GetID())
+	for _, subject := range slices.Concat(api.RequestSubjects, api.EventSubjects) {
+		log.Printf("  - iot.%s.%s (%s)", dev.GetID(), subject.Name, subject.Description)
+	}
 
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
@@ -74,4 +240,14 @@ func main() {
 
 	log.Println("Shutting down...")
 	cancel()
+
+	// Flush queued readings and release the storage backend
+	if store != nil {
+		if stats, ok := storage.FindBatchStats(store); ok {
+			log.Printf("Flushing %d queued readings", stats.QueueDepth)
+		}
+		if err := store.Close(); err != nil {
+			slog.Error(fmt.Sprintf("Error closing storage: %v", err))
+		}
+	}
 }
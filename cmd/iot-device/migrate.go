@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/storage"
+)
+
+// migrateOptions holds the parsed arguments of the migrate subcommand.
+type migrateOptions struct {
+	configFile string
+	overrides  config.Overrides
+}
+
+// parseMigrateFlags parses the arguments of the migrate subcommand.
+func parseMigrateFlags(programName string, args []string) (migrateOptions, error) {
+	var opts migrateOptions
+
+	fs := flag.NewFlagSet(programName+" migrate", flag.ContinueOnError)
+	fs.StringVar(&opts.configFile, "config", "", "path to the YAML configuration file (env "+envConfigFile+")")
+	addOverrideFlags(fs, &opts.overrides)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s migrate [flags] [config.yml]\n\n", programName)
+		fmt.Fprintf(fs.Output(), "Migrates a MongoDB time-series readings collection of an older version.\n")
+		fmt.Fprintf(fs.Output(), "Stop every simulator writing to it first.\n\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if err := resolveConfigFile(fs, &opts.configFile); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// runMigrate implements the migrate subcommand: it migrates the MongoDB 'readings'
+// collection of the configured database, then prepares it like a simulator start.
+func runMigrate(program string, args []string) error {
+	opts, err := parseMigrateFlags(filepath.Base(program), args)
+	if err != nil {
+		return err
+	}
+
+	cfg, _, err := loadConfig(opts.configFile, opts.overrides)
+	if err != nil {
+		return err
+	}
+	setupLogging(cfg.LogLevel)
+
+	switch cfg.Storage.Backend {
+	case "", storage.BackendMongoDB:
+	default:
+		return fmt.Errorf("only the %s storage backend needs migrating", storage.BackendMongoDB)
+	}
+
+	mongodb, err := storage.NewMongoDB(cfg.Storage.MongoDB.URI, cfg.Storage.MongoDB.Database)
+	if err != nil {
+		return err
+	}
+	defer mongodb.Close()
+
+	ctx := context.Background()
+	readingsOpts := storage.ReadingsOptions{
+		Retention:   cfg.Storage.MongoDB.Retention,
+		Granularity: cfg.Storage.MongoDB.Granularity,
+		DeviceID:    cfg.DeviceID,
+	}
+	if err := mongodb.MigrateReadings(ctx, readingsOpts); err != nil {
+		return err
+	}
+	return mongodb.EnsureSchema(ctx, readingsOpts)
+}
@@ -0,0 +1,382 @@
+// Package alarm evaluates the threshold alarms of a sensor. Each alarm is a small state
+// machine driven by the sensor's readings: it is raised when its condition holds for its
+// on delay and cleared when the value has left the condition by the deadband for its
+// off delay, so a value hovering around a limit does not make the alarm chatter.
+//
+// Operators acknowledge alarms and shelve nuisance alarms, following the alarm states of
+// ISA-18.2: a raised alarm is active and unacknowledged until acknowledged, and stays
+// listed as cleared but unacknowledged if it clears first. Shelved alarms keep being
+// evaluated, but their events are suppressed until they are unshelved.
+package alarm
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"iot-device-simulator/internal/config"
+)
+
+// Event states.
+const (
+	StateRaised  = "raised"
+	StateCleared = "cleared"
+)
+
+// Event is published when an alarm is raised or cleared.
+type Event struct {
+	DeviceID string `json:"device_id"`
+	SensorID string `json:"sensor_id"`
+	// Alarm is the name of the alarm, unique within the sensor (see config.AlarmConfig.Name).
+	Alarm string `json:"alarm"`
+	Type  string `json:"type"`
+	Field string `json:"field,omitempty"`
+	State string `json:"state"`
+	// Value is the value that raised or cleared the alarm; for rate-of-change alarms,
+	// the rate of change in units per second.
+	Value     float64           `json:"value"`
+	Limit     float64           `json:"limit"`
+	Timestamp time.Time         `json:"timestamp"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// Alarm states reported in Alarm.State. Normal alarms, cleared and acknowledged, are not listed.
+const (
+	StateNormal         = "normal"
+	StateActiveUnacked  = "active_unacked"
+	StateActiveAcked    = "active_acked"
+	StateClearedUnacked = "cleared_unacked"
+	StateShelved        = "shelved"
+)
+
+// Errors returned by Ack, Shelve and Unshelve.
+var (
+	ErrUnknown    = errors.New("unknown alarm")
+	ErrNotUnacked = errors.New("alarm is not unacknowledged")
+	ErrNotShelved = errors.New("alarm is not shelved")
+)
+
+// Audit actions recorded in AuditEntry.Action.
+const (
+	ActionAck      = "ack"
+	ActionShelve   = "shelve"
+	ActionUnshelve = "unshelve"
+	// ActionExpire records that a shelving ended on its own.
+	ActionExpire = "expire"
+)
+
+// AuditEntry records an operator action on an alarm, or the expiry of a shelving.
+type AuditEntry struct {
+	DeviceID string `json:"device_id" bson:"device_id"`
+	SensorID string `json:"sensor_id" bson:"sensor_id"`
+	Alarm    string `json:"alarm" bson:"alarm"`
+	Action   string `json:"action" bson:"action"`
+	User     string `json:"user,omitempty" bson:"user,omitempty"`
+	Comment  string `json:"comment,omitempty" bson:"comment,omitempty"`
+	// State is the state of the alarm after the action.
+	State        string     `json:"state" bson:"state"`
+	ShelvedUntil *time.Time `json:"shelved_until,omitempty" bson:"shelved_until,omitempty"`
+	Timestamp    time.Time  `json:"timestamp" bson:"timestamp"`
+}
+
+// Subject returns the subject of the alarm events of a sensor.
+func Subject(deviceID, sensorID string) string {
+	return fmt.Sprintf("iot.%s.alarms.%s", deviceID, sensorID)
+}
+
+// Alarm is the state of an alarm that is not normal.
+type Alarm struct {
+	SensorID string  `json:"sensor_id"`
+	Alarm    string  `json:"alarm"`
+	Type     string  `json:"type"`
+	Field    string  `json:"field,omitempty"`
+	State    string  `json:"state"`
+	Active   bool    `json:"active"`
+	Value    float64 `json:"value"`
+	Limit    float64 `json:"limit"`
+	// Since is when the alarm was last raised or cleared, and Value the value then.
+	Since time.Time `json:"since"`
+	// ShelvedUntil is when a shelved alarm is unshelved automatically.
+	ShelvedUntil *time.Time `json:"shelved_until,omitempty"`
+}
+
+// Evaluator evaluates the alarms of one sensor. It is safe for concurrent use.
+type Evaluator struct {
+	mu       sync.Mutex
+	sensorID string
+	rules    []*rule
+}
+
+// rule is the state of one alarm.
+type rule struct {
+	cfg    config.AlarmConfig
+	active bool
+	// acked is false from the time the alarm is raised until it is acknowledged.
+	acked bool
+	// shelvedUntil is when a shelved alarm is unshelved, or zero.
+	shelvedUntil time.Time
+	// pending is when the condition started to differ from active, or zero.
+	pending time.Time
+	// value and since are the value and time of the last change.
+	value float64
+	since time.Time
+
+	// last is the previous value and time, for rate-of-change alarms.
+	last    float64
+	lastAt  time.Time
+	hasLast bool
+}
+
+// NewEvaluator returns an evaluator of the alarms of the sensor with the given ID,
+// with every alarm cleared.
+func NewEvaluator(sensorID string, alarms []config.AlarmConfig) *Evaluator {
+	e := &Evaluator{sensorID: sensorID}
+	e.Reconfigure(alarms)
+	return e
+}
+
+// Reconfigure replaces the alarm configurations. Alarms that keep their name keep their
+// state and are evaluated against their new settings from the next reading on; removed
+// alarms are dropped without a cleared event.
+func (e *Evaluator) Reconfigure(alarms []config.AlarmConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rules := make([]*rule, len(alarms))
+	for i, cfg := range alarms {
+		rules[i] = &rule{cfg: cfg, acked: true}
+		for _, r := range e.rules {
+			if r.cfg.Name() == cfg.Name() {
+				r.cfg = cfg
+				rules[i] = r
+				break
+			}
+		}
+	}
+	e.rules = rules
+}
+
+// Evaluate updates the alarms with a reading taken at, whose value is value, or
+// values[field] for the alarms of a field. Alarms of a field missing from values are
+// left as they are. It returns an event for every alarm raised or cleared, except for
+// shelved alarms.
+func (e *Evaluator) Evaluate(value float64, values map[string]float64, at time.Time) []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var events []Event
+	for _, r := range e.rules {
+		v := value
+		if r.cfg.Field != "" {
+			var ok bool
+			if v, ok = values[r.cfg.Field]; !ok {
+				continue
+			}
+		}
+		if r.cfg.Type == config.AlarmRateOfChange {
+			prev, prevAt, ok := r.last, r.lastAt, r.hasLast
+			r.last, r.lastAt, r.hasLast = v, at, true
+			if !ok || !at.After(prevAt) {
+				continue
+			}
+			v = math.Abs(v-prev) / at.Sub(prevAt).Seconds()
+		}
+		if r.step(v, at) && r.shelvedUntil.IsZero() {
+			events = append(events, r.event(e.sensorID, v, at))
+		}
+	}
+	return events
+}
+
+// step updates the rule with the value v at the given time and reports whether the
+// alarm was raised or cleared.
+func (r *rule) step(v float64, at time.Time) bool {
+	if r.exceeded(v) == r.active {
+		r.pending = time.Time{}
+		return false
+	}
+	if r.pending.IsZero() {
+		r.pending = at
+	}
+	delay := r.cfg.DelayOn
+	if r.active {
+		delay = r.cfg.DelayOff
+	}
+	if at.Sub(r.pending) < delay {
+		return false
+	}
+
+	r.active = !r.active
+	if r.active {
+		r.acked = false
+	}
+	r.pending = time.Time{}
+	r.value, r.since = v, at
+	return true
+}
+
+// exceeded reports whether v is in the alarm condition. Once raised, the value must move
+// past the limit by the deadband to leave it.
+func (r *rule) exceeded(v float64) bool {
+	limit := r.cfg.Limit
+	switch r.cfg.Type {
+	case config.AlarmLow, config.AlarmLowLow:
+		if r.active {
+			limit += r.cfg.Deadband
+		}
+		return v < limit
+	default:
+		if r.active {
+			limit -= r.cfg.Deadband
+		}
+		return v > limit
+	}
+}
+
+// event returns the event of the last change of the rule.
+func (r *rule) event(sensorID string, v float64, at time.Time) Event {
+	state := StateCleared
+	if r.active {
+		state = StateRaised
+	}
+	return Event{
+		SensorID:  sensorID,
+		Alarm:     r.cfg.Name(),
+		Type:      r.cfg.Type,
+		Field:     r.cfg.Field,
+		State:     state,
+		Value:     v,
+		Limit:     r.cfg.Limit,
+		Timestamp: at,
+	}
+}
+
+// state returns the state of the rule.
+func (r *rule) state() string {
+	switch {
+	case !r.shelvedUntil.IsZero():
+		return StateShelved
+	case r.active && r.acked:
+		return StateActiveAcked
+	case r.active:
+		return StateActiveUnacked
+	case !r.acked:
+		return StateClearedUnacked
+	}
+	return StateNormal
+}
+
+// alarm returns the state of the rule as an Alarm.
+func (r *rule) alarm(sensorID string) Alarm {
+	a := Alarm{
+		SensorID: sensorID,
+		Alarm:    r.cfg.Name(),
+		Type:     r.cfg.Type,
+		Field:    r.cfg.Field,
+		State:    r.state(),
+		Active:   r.active,
+		Value:    r.value,
+		Limit:    r.cfg.Limit,
+		Since:    r.since,
+	}
+	if !r.shelvedUntil.IsZero() {
+		until := r.shelvedUntil
+		a.ShelvedUntil = &until
+	}
+	return a
+}
+
+// Active returns the active alarms, shelved or not, sorted by name.
+func (e *Evaluator) Active() []Alarm {
+	return e.list(func(r *rule) bool { return r.active })
+}
+
+// List returns the alarms that are not normal, sorted by name.
+func (e *Evaluator) List() []Alarm {
+	return e.list(func(r *rule) bool { return r.state() != StateNormal })
+}
+
+// list returns the alarms of the rules selected by match, sorted by name.
+func (e *Evaluator) list(match func(*rule) bool) []Alarm {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var alarms []Alarm
+	for _, r := range e.rules {
+		if match(r) {
+			alarms = append(alarms, r.alarm(e.sensorID))
+		}
+	}
+	slices.SortFunc(alarms, func(a, b Alarm) int { return cmp.Compare(a.Alarm, b.Alarm) })
+	return alarms
+}
+
+// find returns the rule of the named alarm, or nil. The caller must hold e.mu.
+func (e *Evaluator) find(name string) *rule {
+	for _, r := range e.rules {
+		if r.cfg.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Ack acknowledges the named alarm: an active alarm becomes acknowledged, and a cleared
+// one returns to normal. It returns ErrUnknown or ErrNotUnacked.
+func (e *Evaluator) Ack(name string) (Alarm, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r := e.find(name)
+	switch {
+	case r == nil:
+		return Alarm{}, ErrUnknown
+	case r.acked:
+		return Alarm{}, ErrNotUnacked
+	}
+	r.acked = true
+	return r.alarm(e.sensorID), nil
+}
+
+// Shelve suppresses the events of the named alarm until the given time, replacing the
+// expiry of an alarm that is already shelved. It returns ErrUnknown.
+func (e *Evaluator) Shelve(name string, until time.Time) (Alarm, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r := e.find(name)
+	if r == nil {
+		return Alarm{}, ErrUnknown
+	}
+	r.shelvedUntil = until
+	return r.alarm(e.sensorID), nil
+}
+
+// Unshelve ends the shelving of the named alarm. With a non-zero expiry, the alarm is only
+// unshelved if it is still shelved until then, so an expiry timer does not end a later
+// shelving. An alarm that is active when unshelved is announced again as unacknowledged,
+// with a raised event taken at. It returns ErrUnknown or ErrNotShelved.
+func (e *Evaluator) Unshelve(name string, expiry, at time.Time) (Alarm, []Event, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r := e.find(name)
+	switch {
+	case r == nil:
+		return Alarm{}, nil, ErrUnknown
+	case r.shelvedUntil.IsZero() || (!expiry.IsZero() && !r.shelvedUntil.Equal(expiry)):
+		return Alarm{}, nil, ErrNotShelved
+	}
+	r.shelvedUntil = time.Time{}
+
+	var events []Event
+	if r.active {
+		r.acked = false
+		events = append(events, r.event(e.sensorID, r.value, at))
+	}
+	return r.alarm(e.sensorID), events, nil
+}
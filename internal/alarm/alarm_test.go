@@ -0,0 +1,161 @@
+package alarm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/config"
+)
+
+// states feeds values to e one second apart and returns the alarm changes, e.g.
+// "high raised@2" for the high alarm raised by the third value.
+func states(e *Evaluator, values ...float64) string {
+	start := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+	var changes []string
+	for i, v := range values {
+		for _, event := range e.Evaluate(v, nil, start.Add(time.Duration(i)*time.Second)) {
+			changes = append(changes, fmt.Sprintf("%s %s@%d", event.Alarm, event.State, i))
+		}
+	}
+	return strings.Join(changes, ", ")
+}
+
+// TestDeadband tests that high and low alarms only clear once the value is back past
+// the limit by the deadband.
+func TestDeadband(t *testing.T) {
+	e := NewEvaluator("temp-01", []config.AlarmConfig{
+		{Type: config.AlarmHigh, Limit: 30, Deadband: 2},
+		{Type: config.AlarmLow, Limit: 10, Deadband: 2},
+	})
+	got := states(e, 25, 31, 29, 30.5, 28, 9, 11, 12)
+	want := "high raised@1, high cleared@4, low raised@5, low cleared@7"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+// TestDelays tests that the condition must hold for the on delay to raise an alarm,
+// and be gone for the off delay to clear it.
+func TestDelays(t *testing.T) {
+	e := NewEvaluator("temp-01", []config.AlarmConfig{
+		{Type: config.AlarmHighHigh, Limit: 30, DelayOn: 2 * time.Second, DelayOff: time.Second},
+	})
+	got := states(e, 31, 32, 29, 31, 31, 31, 29, 31, 29, 29)
+	want := "high_high raised@5, high_high cleared@9"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if active := e.Active(); len(active) != 0 {
+		t.Errorf("Expected no active alarms, got %+v", active)
+	}
+}
+
+// TestRateOfChange tests that a rate-of-change alarm compares the change per second
+// between readings with its limit.
+func TestRateOfChange(t *testing.T) {
+	e := NewEvaluator("temp-01", []config.AlarmConfig{
+		{Type: config.AlarmRateOfChange, Limit: 3, Deadband: 1},
+	})
+	got := states(e, 20, 22, 26, 28.5, 30, 30.5)
+	want := "rate_of_change raised@2, rate_of_change cleared@4"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+// TestFieldsAndActive tests alarms of value fields and the active alarm state.
+func TestFieldsAndActive(t *testing.T) {
+	e := NewEvaluator("gps-01", []config.AlarmConfig{
+		{Type: config.AlarmHigh, Field: "speed", Limit: 30},
+		{Type: config.AlarmLow, Field: "hdop", Limit: 0.5},
+	})
+	at := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+	events := e.Evaluate(0, map[string]float64{"speed": 35}, at)
+	if len(events) != 1 || events[0].Alarm != "speed.high" || events[0].Value != 35 || events[0].Limit != 30 {
+		t.Fatalf("Expected speed.high to be raised at 35, got %+v", events)
+	}
+
+	active := e.Active()
+	if len(active) != 1 || active[0].SensorID != "gps-01" || active[0].Alarm != "speed.high" || !active[0].Since.Equal(at) {
+		t.Errorf("Expected speed.high to be active since %v, got %+v", at, active)
+	}
+
+	// Alarms keep their state when reconfigured, and use their new settings.
+	e.Reconfigure([]config.AlarmConfig{{Type: config.AlarmHigh, Field: "speed", Limit: 40}})
+	if active := e.Active(); len(active) != 1 || active[0].Limit != 40 {
+		t.Errorf("Expected speed.high to stay active with limit 40, got %+v", active)
+	}
+	if events := e.Evaluate(0, map[string]float64{"speed": 35}, at.Add(time.Second)); len(events) != 1 || events[0].State != StateCleared {
+		t.Errorf("Expected speed.high to clear below the new limit, got %+v", events)
+	}
+}
+
+// TestAck tests the acknowledgement of active and cleared alarms.
+func TestAck(t *testing.T) {
+	e := NewEvaluator("temp-01", []config.AlarmConfig{{Type: config.AlarmHigh, Limit: 30}})
+	at := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+	if _, err := e.Ack("high"); !errors.Is(err, ErrNotUnacked) {
+		t.Errorf("Expected a normal alarm to need no acknowledgement, got %v", err)
+	}
+	if _, err := e.Ack("low"); !errors.Is(err, ErrUnknown) {
+		t.Errorf("Expected ErrUnknown, got %v", err)
+	}
+
+	e.Evaluate(35, nil, at)
+	if list := e.List(); len(list) != 1 || list[0].State != StateActiveUnacked {
+		t.Fatalf("Expected the alarm to be active and unacknowledged, got %+v", list)
+	}
+	if a, err := e.Ack("high"); err != nil || a.State != StateActiveAcked {
+		t.Errorf("Expected the alarm to be acknowledged, got %+v, %v", a, err)
+	}
+	e.Evaluate(25, nil, at.Add(time.Second))
+	if list := e.List(); len(list) != 0 {
+		t.Errorf("Expected an acknowledged alarm to return to normal when it clears, got %+v", list)
+	}
+
+	// An alarm that clears before it is acknowledged stays listed until it is.
+	e.Evaluate(35, nil, at.Add(2*time.Second))
+	e.Evaluate(25, nil, at.Add(3*time.Second))
+	if list := e.List(); len(list) != 1 || list[0].State != StateClearedUnacked || list[0].Active {
+		t.Fatalf("Expected the alarm to be cleared and unacknowledged, got %+v", list)
+	}
+	if a, err := e.Ack("high"); err != nil || a.State != StateNormal {
+		t.Errorf("Expected the alarm to return to normal, got %+v, %v", a, err)
+	}
+}
+
+// TestShelve tests that shelved alarms are evaluated without events, and announced
+// again when unshelved while active.
+func TestShelve(t *testing.T) {
+	e := NewEvaluator("temp-01", []config.AlarmConfig{{Type: config.AlarmHigh, Limit: 30}})
+	at := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+	until := at.Add(time.Hour)
+
+	if a, err := e.Shelve("high", until); err != nil || a.State != StateShelved || !a.ShelvedUntil.Equal(until) {
+		t.Fatalf("Expected the alarm to be shelved until %v, got %+v, %v", until, a, err)
+	}
+	if events := e.Evaluate(35, nil, at); len(events) != 0 {
+		t.Errorf("Expected no events while shelved, got %+v", events)
+	}
+	if active := e.Active(); len(active) != 1 || active[0].State != StateShelved {
+		t.Errorf("Expected the shelved alarm to be active, got %+v", active)
+	}
+
+	// A timer of an earlier shelving does not end the current one.
+	if _, _, err := e.Unshelve("high", at.Add(time.Minute), at); !errors.Is(err, ErrNotShelved) {
+		t.Errorf("Expected ErrNotShelved for another expiry, got %v", err)
+	}
+	a, events, err := e.Unshelve("high", until, until)
+	if err != nil || a.State != StateActiveUnacked {
+		t.Fatalf("Expected the alarm to be active and unacknowledged, got %+v, %v", a, err)
+	}
+	if len(events) != 1 || events[0].State != StateRaised || !events[0].Timestamp.Equal(until) {
+		t.Errorf("Expected the alarm to be raised again, got %+v", events)
+	}
+	if _, _, err := e.Unshelve("high", time.Time{}, until); !errors.Is(err, ErrNotShelved) {
+		t.Errorf("Expected ErrNotShelved, got %v", err)
+	}
+}
@@ -0,0 +1,589 @@
+// Package api defines the versioned request and response types of the NATS control API.
+// Every reply is wrapped in a Response envelope, and every request is decoded strictly
+// and validated before a handler acts on it.
+package api
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"iot-device-simulator/internal/alarm"
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/sensor"
+	"iot-device-simulator/internal/storage"
+)
+
+// Version identifies the revision of the request/response schemas.
+const Version = "v1"
+
+// Subject is a subject of a device after its iot.{device} prefix, with what it is for.
+// Braced tokens of event subjects, such as {sensor}, stand for the ID they carry.
+type Subject struct {
+	Name        string
+	Description string
+}
+
+// RequestSubjects are the subjects a device answers requests on. Requests change or query
+// the device, unlike the events it publishes.
+var RequestSubjects = []Subject{
+	{"config", "get sensor configs"},
+	{"status", "get device status"},
+	{"config.update", "update sensor configs"},
+	{"sensor.register", "register a sensor"},
+	{"readings.latest", "get the latest reading of a sensor"},
+	{"readings.query", "query reading history"},
+	{"readings.aggregate", "reading statistics per time bucket"},
+	{"alarms.active", "get active alarms"},
+	{"alarms.list", "list alarms to handle"},
+	{"alarms.ack", "acknowledge an alarm"},
+	{"alarms.shelve", "shelve an alarm"},
+	{"alarms.unshelve", "unshelve an alarm"},
+	{"api.schemas", "get the request/response JSON Schemas"},
+}
+
+// EventSubjects are the subjects a device publishes events on.
+var EventSubjects = []Subject{
+	{"readings.{type}.{sensor}", "sensor readings"},
+	{"events.state.{type}.{sensor}", "discrete sensor state changes"},
+	{"alarms.{sensor}", "alarm raised and cleared events"},
+	{"rules.{rule}", "rule events, unless the rule names its subject"},
+	{"config.reloaded", "config hot-reload events"},
+}
+
+// IsRequestSubject reports whether subject, such as iot.device-001.config.update, is one
+// of the RequestSubjects of a device.
+func IsRequestSubject(subject string) bool {
+	parts := strings.SplitN(subject, ".", 3)
+	return len(parts) == 3 && parts[0] == "iot" && slices.ContainsFunc(RequestSubjects, func(s Subject) bool {
+		return s.Name == parts[2]
+	})
+}
+
+// Error codes returned in Response.Error.Code.
+const (
+	CodeInvalidJSON        = "invalid_json"
+	CodeInvalidArgument    = "invalid_argument"
+	CodeNotFound           = "not_found"
+	CodeAlreadyExists      = "already_exists"
+	CodeFailedPrecondition = "failed_precondition"
+	CodeUnavailable        = "unavailable"
+	CodeInternal           = "internal"
+)
+
+// Response is the envelope wrapping every reply of the control API.
+type Response struct {
+	OK    bool   `json:"ok"`
+	Error *Error `json:"error,omitempty"`
+	Data  any    `json:"data,omitempty"`
+}
+
+// Error describes why a request failed.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// NewError creates an Error with the given code and formatted message.
+func NewError(code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// OK wraps data in a successful Response.
+func OK(data any) Response {
+	return Response{OK: true, Data: data}
+}
+
+// Fail wraps err in a failed Response.
+func Fail(err *Error) Response {
+	return Response{OK: false, Error: err}
+}
+
+// Request is implemented by every request type so it can be validated after decoding.
+type Request interface {
+	Validate() error
+}
+
+// Decode strictly decodes data into req and validates it.
+// Unknown fields and trailing data are rejected with CodeInvalidJSON;
+// validation failures are reported with CodeInvalidArgument.
+func Decode(data []byte, req Request) *Error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req); err != nil {
+		return NewError(CodeInvalidJSON, "%v", err)
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return NewError(CodeInvalidJSON, "unexpected data after request body")
+	}
+	if err := req.Validate(); err != nil {
+		return NewError(CodeInvalidArgument, "%v", err)
+	}
+	return nil
+}
+
+// parseFrequency validates an optional duration string and returns its value.
+func parseFrequency(field string, value *string) (time.Duration, error) {
+	if value == nil {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(*value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration %q", field, *value)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s: must be greater than zero", field)
+	}
+	return d, nil
+}
+
+// validationErrors joins problems into a single error, or returns nil if there are none.
+func validationErrors(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// Thresholds holds optional generation bounds for a sensor.
+type Thresholds struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// ConfigUpdateRequest is the body of iot.{device}.config.update.
+type ConfigUpdateRequest struct {
+	SensorID  string   `json:"sensor_id"`
+	Frequency *string  `json:"frequency,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+
+	// Thresholds is accepted for backward compatibility; top-level min/max take precedence.
+	Thresholds *Thresholds `json:"thresholds,omitempty"`
+}
+
+// Validate checks the request fields.
+func (r *ConfigUpdateRequest) Validate() error {
+	var problems []string
+	if r.SensorID == "" {
+		problems = append(problems, "sensor_id: is required")
+	}
+	if _, err := parseFrequency("frequency", r.Frequency); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if min, max := r.Bounds(); min != nil && max != nil && *min > *max {
+		problems = append(problems, fmt.Sprintf("min: %v is greater than max %v", *min, *max))
+	}
+	return validationErrors(problems)
+}
+
+// FrequencyDuration returns the requested frequency, or zero if none was given.
+// It must only be called on a validated request.
+func (r *ConfigUpdateRequest) FrequencyDuration() time.Duration {
+	d, _ := parseFrequency("frequency", r.Frequency)
+	return d
+}
+
+// Bounds returns the requested min and max, merging the legacy thresholds object.
+func (r *ConfigUpdateRequest) Bounds() (min, max *float64) {
+	if r.Thresholds != nil {
+		min, max = r.Thresholds.Min, r.Thresholds.Max
+	}
+	if r.Min != nil {
+		min = r.Min
+	}
+	if r.Max != nil {
+		max = r.Max
+	}
+	return min, max
+}
+
+// SensorRegisterRequest is the body of iot.{device}.sensor.register.
+type SensorRegisterRequest struct {
+	SensorID  string   `json:"sensor_id"`
+	Type      string   `json:"type"`
+	Frequency *string  `json:"frequency,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Unit      string   `json:"unit,omitempty"`
+
+	// Values registers a multi-value sensor with these named fields.
+	Values map[string]config.ValueConfig `json:"values,omitempty"`
+}
+
+// Validate checks the request fields.
+func (r *SensorRegisterRequest) Validate() error {
+	var problems []string
+	if r.SensorID == "" {
+		problems = append(problems, "sensor_id: is required")
+	}
+	if r.Type == "" {
+		problems = append(problems, "type: is required")
+	}
+	if _, err := parseFrequency("frequency", r.Frequency); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		problems = append(problems, fmt.Sprintf("min: %v is greater than max %v", *r.Min, *r.Max))
+	}
+	return validationErrors(problems)
+}
+
+// SensorConfig builds the configuration of the sensor to register,
+// applying defaults for omitted fields. It must only be called on a validated request.
+func (r *SensorRegisterRequest) SensorConfig() config.SensorConfig {
+	cfg := config.SensorConfig{
+		ID:        r.SensorID,
+		Type:      r.Type,
+		Enabled:   true,
+		Frequency: 30 * time.Second, // Default frequency
+		Min:       0,                // Default min
+		Max:       100,              // Default max
+		Unit:      r.Unit,
+		Values:    r.Values,
+	}
+	if d, _ := parseFrequency("frequency", r.Frequency); d > 0 {
+		cfg.Frequency = d
+	}
+	if r.Min != nil {
+		cfg.Min = *r.Min
+	}
+	if r.Max != nil {
+		cfg.Max = *r.Max
+	}
+	return cfg
+}
+
+// LatestReadingsRequest is the body of iot.{device}.readings.latest.
+type LatestReadingsRequest struct {
+	SensorID string `json:"sensor_id"`
+}
+
+// Validate checks the request fields.
+func (r *LatestReadingsRequest) Validate() error {
+	if r.SensorID == "" {
+		return errors.New("sensor_id: is required")
+	}
+	return nil
+}
+
+// AlarmsActiveRequest is the body of iot.{device}.alarms.active.
+// An omitted sensor selects every sensor of the device.
+type AlarmsActiveRequest struct {
+	SensorID string `json:"sensor_id,omitempty"`
+}
+
+// Validate checks the request fields.
+func (r *AlarmsActiveRequest) Validate() error {
+	return nil
+}
+
+// AlarmsListRequest is the body of iot.{device}.alarms.list.
+// An omitted sensor selects every sensor of the device.
+type AlarmsListRequest struct {
+	SensorID string `json:"sensor_id,omitempty"`
+}
+
+// Validate checks the request fields.
+func (r *AlarmsListRequest) Validate() error {
+	return nil
+}
+
+// AlarmAckRequest is the body of iot.{device}.alarms.ack.
+// User and Comment are recorded in the audit trail.
+type AlarmAckRequest struct {
+	SensorID string `json:"sensor_id"`
+	Alarm    string `json:"alarm"`
+	User     string `json:"user,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Validate checks the request fields.
+func (r *AlarmAckRequest) Validate() error {
+	return validationErrors(alarmProblems(r.SensorID, r.Alarm))
+}
+
+// AlarmShelveRequest is the body of iot.{device}.alarms.shelve.
+// User and Comment are recorded in the audit trail.
+type AlarmShelveRequest struct {
+	SensorID string `json:"sensor_id"`
+	Alarm    string `json:"alarm"`
+	// Duration is how long the alarm stays shelved, as a duration string, e.g. "30m".
+	Duration string `json:"duration"`
+	User     string `json:"user,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Validate checks the request fields.
+func (r *AlarmShelveRequest) Validate() error {
+	problems := alarmProblems(r.SensorID, r.Alarm)
+	if r.Duration == "" {
+		problems = append(problems, "duration: is required")
+	} else if _, err := parseFrequency("duration", &r.Duration); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return validationErrors(problems)
+}
+
+// ShelveDuration returns how long the alarm stays shelved.
+// It must only be called on a validated request.
+func (r *AlarmShelveRequest) ShelveDuration() time.Duration {
+	d, _ := parseFrequency("duration", &r.Duration)
+	return d
+}
+
+// AlarmUnshelveRequest is the body of iot.{device}.alarms.unshelve.
+// User and Comment are recorded in the audit trail.
+type AlarmUnshelveRequest struct {
+	SensorID string `json:"sensor_id"`
+	Alarm    string `json:"alarm"`
+	User     string `json:"user,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Validate checks the request fields.
+func (r *AlarmUnshelveRequest) Validate() error {
+	return validationErrors(alarmProblems(r.SensorID, r.Alarm))
+}
+
+// alarmProblems returns the problems of the fields identifying an alarm.
+func alarmProblems(sensorID, alarm string) []string {
+	var problems []string
+	if sensorID == "" {
+		problems = append(problems, "sensor_id: is required")
+	}
+	if alarm == "" {
+		problems = append(problems, "alarm: is required")
+	}
+	return problems
+}
+
+// ReadingsQueryRequest is the body of iot.{device}.readings.query.
+// Omitted sensors select every sensor of the device, omitted bounds leave the range open.
+type ReadingsQueryRequest struct {
+	SensorID  string     `json:"sensor_id,omitempty"`
+	SensorIDs []string   `json:"sensor_ids,omitempty"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+	Limit     int        `json:"limit,omitempty"`
+	Cursor    string     `json:"cursor,omitempty"`
+
+	// IncludeErrors returns readings that only carry a communication error; true if omitted.
+	IncludeErrors *bool `json:"include_errors,omitempty"`
+}
+
+// Validate checks the request fields.
+func (r *ReadingsQueryRequest) Validate() error {
+	var problems []string
+	for i, id := range r.SensorIDs {
+		if id == "" {
+			problems = append(problems, fmt.Sprintf("sensor_ids[%d]: must not be empty", i))
+		}
+	}
+	if r.From != nil && r.To != nil && !r.From.Before(*r.To) {
+		problems = append(problems, "from: must be before to")
+	}
+	if r.Limit < 0 || r.Limit > storage.MaxQueryLimit {
+		problems = append(problems, fmt.Sprintf("limit: must be between 0 and %d", storage.MaxQueryLimit))
+	}
+	return validationErrors(problems)
+}
+
+// Query builds the storage query of the request for a device.
+// It must only be called on a validated request.
+func (r *ReadingsQueryRequest) Query(deviceID string) storage.ReadingQuery {
+	q := storage.ReadingQuery{
+		DeviceID:      deviceID,
+		SensorIDs:     r.SensorIDs,
+		Limit:         r.Limit,
+		Cursor:        r.Cursor,
+		IncludeErrors: r.IncludeErrors == nil || *r.IncludeErrors,
+	}
+	if r.SensorID != "" {
+		q.SensorIDs = append([]string{r.SensorID}, r.SensorIDs...)
+	}
+	if r.From != nil {
+		q.From = *r.From
+	}
+	if r.To != nil {
+		q.To = *r.To
+	}
+	return q
+}
+
+// ReadingsAggregateRequest is the body of iot.{device}.readings.aggregate.
+type ReadingsAggregateRequest struct {
+	SensorID string     `json:"sensor_id"`
+	From     *time.Time `json:"from"`
+	To       *time.Time `json:"to"`
+	// Bucket is the bucket width as a duration string, e.g. "1m" or "1h".
+	Bucket string `json:"bucket"`
+	// Percentiles lists the percentiles (0-100) to compute, e.g. [50, 95, 99], with the
+	// nearest-rank method on every backend.
+	Percentiles []float64 `json:"percentiles,omitempty"`
+	// Field names the value of a multi-value sensor to aggregate, e.g. "x" for an
+	// accelerometer. It is required for those sensors, whose scalar value is unused.
+	Field string `json:"field,omitempty"`
+}
+
+// Validate checks the request fields.
+func (r *ReadingsAggregateRequest) Validate() error {
+	var problems []string
+	if r.SensorID == "" {
+		problems = append(problems, "sensor_id: is required")
+	}
+	if r.From == nil {
+		problems = append(problems, "from: is required")
+	}
+	if r.To == nil {
+		problems = append(problems, "to: is required")
+	}
+	if r.From != nil && r.To != nil && !r.From.Before(*r.To) {
+		problems = append(problems, "from: must be before to")
+	}
+
+	bucket, err := time.ParseDuration(r.Bucket)
+	switch {
+	case r.Bucket == "":
+		problems = append(problems, "bucket: is required")
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("bucket: invalid duration %q", r.Bucket))
+	case bucket < time.Second:
+		problems = append(problems, "bucket: must be at least 1s")
+	case bucket%time.Millisecond != 0:
+		problems = append(problems, "bucket: must be a whole number of milliseconds")
+	case r.From != nil && r.To != nil && r.To.Sub(*r.From)/bucket >= storage.MaxAggregateBuckets:
+		problems = append(problems, fmt.Sprintf("bucket: range spans more than %d buckets", storage.MaxAggregateBuckets))
+	}
+
+	for i, p := range r.Percentiles {
+		if p < 0 || p > 100 {
+			problems = append(problems, fmt.Sprintf("percentiles[%d]: %v is not between 0 and 100", i, p))
+		}
+	}
+	if strings.ContainsAny(r.Field, ".$") {
+		problems = append(problems, fmt.Sprintf("field: %q must not contain '.' or '$'", r.Field))
+	}
+	return validationErrors(problems)
+}
+
+// Query builds the storage aggregation of the request for a device.
+// It must only be called on a validated request.
+func (r *ReadingsAggregateRequest) Query(deviceID string) storage.AggregateQuery {
+	bucket, _ := time.ParseDuration(r.Bucket)
+	return storage.AggregateQuery{
+		DeviceID:    deviceID,
+		SensorID:    r.SensorID,
+		From:        *r.From,
+		To:          *r.To,
+		Bucket:      bucket,
+		Percentiles: r.Percentiles,
+		Field:       r.Field,
+	}
+}
+
+// ConfigResponse is the data of iot.{device}.config, keyed by sensor ID.
+type ConfigResponse map[string]config.SensorConfig
+
+// StatusResponse is the data of iot.{device}.status.
+type StatusResponse struct {
+	DeviceID        string    `json:"device_id"`
+	TotalSensors    int       `json:"total_sensors"`
+	EnabledSensors  int       `json:"enabled_sensors"`
+	DisabledSensors int       `json:"disabled_sensors"`
+	Timestamp       time.Time `json:"timestamp"`
+
+	// WriteQueue is present when readings are written in batches.
+	WriteQueue *storage.BatchStats `json:"write_queue,omitempty"`
+}
+
+// ConfigUpdateResponse is the data of iot.{device}.config.update.
+type ConfigUpdateResponse struct {
+	Status   string              `json:"status"`
+	SensorID string              `json:"sensor_id"`
+	Config   config.SensorConfig `json:"config"`
+}
+
+// SensorRegisterResponse is the data of iot.{device}.sensor.register.
+type SensorRegisterResponse struct {
+	Status   string              `json:"status"`
+	SensorID string              `json:"sensor_id"`
+	Config   config.SensorConfig `json:"config"`
+}
+
+// LatestReadingsResponse is the data of iot.{device}.readings.latest.
+type LatestReadingsResponse struct {
+	SensorID      string         `json:"sensor_id"`
+	LatestReading sensor.Reading `json:"latest_reading"`
+}
+
+// ReadingsQueryResponse is the data of iot.{device}.readings.query.
+// NextCursor is set when more readings match; pass it as cursor to get the next page.
+type ReadingsQueryResponse struct {
+	Readings   []sensor.Reading `json:"readings"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// ReadingsAggregateResponse is the data of iot.{device}.readings.aggregate.
+type ReadingsAggregateResponse struct {
+	SensorID string                    `json:"sensor_id"`
+	Bucket   string                    `json:"bucket"`
+	Buckets  []storage.AggregateBucket `json:"buckets"`
+}
+
+// AlarmsActiveResponse is the data of iot.{device}.alarms.active.
+type AlarmsActiveResponse struct {
+	Alarms []alarm.Alarm `json:"alarms"`
+}
+
+// AlarmsListResponse is the data of iot.{device}.alarms.list.
+type AlarmsListResponse struct {
+	Alarms []alarm.Alarm `json:"alarms"`
+}
+
+// AlarmActionResponse is the data of iot.{device}.alarms.ack, .shelve and .unshelve,
+// with the state of the alarm after the action.
+type AlarmActionResponse struct {
+	Status string      `json:"status"`
+	Alarm  alarm.Alarm `json:"alarm"`
+}
+
+// ConfigReloadedEvent is published on iot.{device}.config.reloaded after a hot-reload.
+type ConfigReloadedEvent struct {
+	DeviceID  string      `json:"device_id"`
+	Diff      config.Diff `json:"diff"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+//go:embed schemas/*.schema.json
+var schemaFS embed.FS
+
+// Schemas returns the published JSON Schemas, keyed by schema name
+// (the file name without the ".schema.json" suffix).
+func Schemas() (map[string]json.RawMessage, error) {
+	entries, err := schemaFS.ReadDir("schemas")
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := make(map[string]json.RawMessage, len(entries))
+	for _, entry := range entries {
+		data, err := schemaFS.ReadFile("schemas/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		schemas[strings.TrimSuffix(entry.Name(), ".schema.json")] = data
+	}
+	return schemas, nil
+}
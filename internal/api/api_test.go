@@ -0,0 +1,236 @@
+// Package api_test contains the unit tests for the api package.
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestDecodeConfigUpdate tests that a valid config update request is decoded and validated.
+func TestDecodeConfigUpdate(t *testing.T) {
+	var req ConfigUpdateRequest
+	if err := Decode([]byte(`{"sensor_id": "temp-01", "frequency": "2s", "min": 10, "thresholds": {"max": 40}}`), &req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if req.FrequencyDuration() != 2*time.Second {
+		t.Errorf("Expected frequency 2s, got %v", req.FrequencyDuration())
+	}
+
+	min, max := req.Bounds()
+	if min == nil || *min != 10 {
+		t.Errorf("Expected min 10, got %v", min)
+	}
+	if max == nil || *max != 40 {
+		t.Errorf("Expected max 40 from thresholds, got %v", max)
+	}
+}
+
+// TestDecodeErrors tests that malformed and invalid requests are rejected with the right code.
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"invalid JSON", `{`, CodeInvalidJSON},
+		{"unknown field", `{"sensor_id": "temp-01", "frequncy": "2s"}`, CodeInvalidJSON},
+		{"trailing data", `{"sensor_id": "temp-01"} {}`, CodeInvalidJSON},
+		{"missing sensor_id", `{"frequency": "2s"}`, CodeInvalidArgument},
+		{"unparsable frequency", `{"sensor_id": "temp-01", "frequency": "fast"}`, CodeInvalidArgument},
+		{"negative frequency", `{"sensor_id": "temp-01", "frequency": "-1s"}`, CodeInvalidArgument},
+		{"min above max", `{"sensor_id": "temp-01", "min": 50, "max": 10}`, CodeInvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req ConfigUpdateRequest
+			err := Decode([]byte(tt.body), &req)
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+			if err.Code != tt.code {
+				t.Errorf("Expected code %s, got %s (%s)", tt.code, err.Code, err.Message)
+			}
+		})
+	}
+}
+
+// TestSensorRegisterDefaults tests that omitted register fields receive defaults.
+func TestSensorRegisterDefaults(t *testing.T) {
+	var req SensorRegisterRequest
+	if err := Decode([]byte(`{"sensor_id": "light-01", "type": "light"}`), &req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cfg := req.SensorConfig()
+	if cfg.Frequency != 30*time.Second || cfg.Min != 0 || cfg.Max != 100 || !cfg.Enabled {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+}
+
+// TestSensorRegisterValues tests registering a multi-value sensor with per-field models.
+func TestSensorRegisterValues(t *testing.T) {
+	var req SensorRegisterRequest
+	body := `{"sensor_id": "accel-01", "type": "acceleration", "unit": "g",
+		"values": {"x": {"min": -2, "max": 2}, "z": {"min": 0.9, "max": 1.1, "model": "sine", "period": "10s"}}}`
+	if err := Decode([]byte(body), &req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cfg := req.SensorConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+	if len(cfg.Values) != 2 || cfg.Values["z"].Period != 10*time.Second || cfg.Values["x"].Min != -2 {
+		t.Errorf("Unexpected values: %+v", cfg.Values)
+	}
+}
+
+// TestReadingsQuery tests decoding a readings query and building its storage query.
+func TestReadingsQuery(t *testing.T) {
+	var req ReadingsQueryRequest
+	body := `{"sensor_id": "temp-01", "sensor_ids": ["temp-02"], "from": "2025-01-01T00:00:00Z", "limit": 10}`
+	if err := Decode([]byte(body), &req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	q := req.Query("device-001")
+	if q.DeviceID != "device-001" || len(q.SensorIDs) != 2 || q.SensorIDs[0] != "temp-01" {
+		t.Errorf("Unexpected query: %+v", q)
+	}
+	if !q.From.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) || !q.To.IsZero() {
+		t.Errorf("Unexpected range: %v - %v", q.From, q.To)
+	}
+	if !q.IncludeErrors {
+		t.Errorf("Expected error readings to be included by default")
+	}
+
+	for _, body := range []string{
+		`{"from": "2025-01-02T00:00:00Z", "to": "2025-01-01T00:00:00Z"}`,
+		`{"limit": 5000}`,
+		`{"sensor_ids": [""]}`,
+	} {
+		var req ReadingsQueryRequest
+		if err := Decode([]byte(body), &req); err == nil || err.Code != CodeInvalidArgument {
+			t.Errorf("Expected invalid_argument for %s, got %v", body, err)
+		}
+	}
+}
+
+// TestReadingsAggregate tests decoding and validating an aggregation request.
+func TestReadingsAggregate(t *testing.T) {
+	var req ReadingsAggregateRequest
+	body := `{"sensor_id": "temp-01", "from": "2025-01-01T00:00:00Z", "to": "2025-01-02T00:00:00Z", "bucket": "1h", "percentiles": [50, 95]}`
+	if err := Decode([]byte(body), &req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if q := req.Query("device-001"); q.Bucket != time.Hour || q.To.Sub(q.From) != 24*time.Hour || len(q.Percentiles) != 2 {
+		t.Errorf("Unexpected query: %+v", q)
+	}
+
+	req = ReadingsAggregateRequest{}
+	body = `{"sensor_id": "accel-01", "from": "2025-01-01T00:00:00Z", "to": "2025-01-02T00:00:00Z", "bucket": "1h", "field": "x"}`
+	if err := Decode([]byte(body), &req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if q := req.Query("device-001"); q.Field != "x" {
+		t.Errorf("Expected field x, got %q", q.Field)
+	}
+
+	for _, body := range []string{
+		`{"from": "2025-01-01T00:00:00Z", "to": "2025-01-02T00:00:00Z", "bucket": "1h"}`,
+		`{"sensor_id": "temp-01", "to": "2025-01-02T00:00:00Z", "bucket": "1h"}`,
+		`{"sensor_id": "temp-01", "from": "2025-01-01T00:00:00Z", "to": "2025-01-02T00:00:00Z", "bucket": "100ms"}`,
+		`{"sensor_id": "temp-01", "from": "2025-01-01T00:00:00Z", "to": "2025-03-01T00:00:00Z", "bucket": "1s"}`,
+		`{"sensor_id": "temp-01", "from": "2025-01-01T00:00:00Z", "to": "2025-01-02T00:00:00Z", "bucket": "1h", "percentiles": [101]}`,
+		`{"sensor_id": "accel-01", "from": "2025-01-01T00:00:00Z", "to": "2025-01-02T00:00:00Z", "bucket": "1h", "field": "$x"}`,
+	} {
+		var req ReadingsAggregateRequest
+		if err := Decode([]byte(body), &req); err == nil || err.Code != CodeInvalidArgument {
+			t.Errorf("Expected invalid_argument for %s, got %v", body, err)
+		}
+	}
+}
+
+// TestAlarmShelve tests decoding and validating a shelve request.
+func TestAlarmShelve(t *testing.T) {
+	var req AlarmShelveRequest
+	body := `{"sensor_id": "temp-01", "alarm": "high", "duration": "30m", "user": "op", "comment": "calibration"}`
+	if err := Decode([]byte(body), &req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d := req.ShelveDuration(); d != 30*time.Minute {
+		t.Errorf("Expected 30m, got %v", d)
+	}
+
+	for _, body := range []string{
+		`{"alarm": "high", "duration": "30m"}`,
+		`{"sensor_id": "temp-01", "duration": "30m"}`,
+		`{"sensor_id": "temp-01", "alarm": "high"}`,
+		`{"sensor_id": "temp-01", "alarm": "high", "duration": "-1m"}`,
+	} {
+		var req AlarmShelveRequest
+		if err := Decode([]byte(body), &req); err == nil || err.Code != CodeInvalidArgument {
+			t.Errorf("Expected invalid_argument for %s, got %v", body, err)
+		}
+	}
+}
+
+// TestResponseEnvelope tests the JSON shape of successful and failed responses.
+func TestResponseEnvelope(t *testing.T) {
+	data, _ := json.Marshal(Fail(NewError(CodeNotFound, "sensor %s not found", "x")))
+	if string(data) != `{"ok":false,"error":{"code":"not_found","message":"sensor x not found"}}` {
+		t.Errorf("Unexpected failure envelope: %s", data)
+	}
+
+	data, _ = json.Marshal(OK(map[string]int{"n": 1}))
+	if string(data) != `{"ok":true,"data":{"n":1}}` {
+		t.Errorf("Unexpected success envelope: %s", data)
+	}
+}
+
+// TestSchemas tests that every published schema is valid JSON with a title.
+func TestSchemas(t *testing.T) {
+	schemas, err := Schemas()
+	if err != nil {
+		t.Fatalf("Failed to load schemas: %v", err)
+	}
+
+	for _, name := range []string{"response", "config_update_request", "sensor_register_request", "latest_readings_request", "readings_query_request", "readings_aggregate_request", "alarms_active_request", "alarms_list_request", "alarm_ack_request", "alarm_shelve_request", "alarm_unshelve_request"} {
+		if _, ok := schemas[name]; !ok {
+			t.Errorf("Expected schema %s to be published", name)
+		}
+	}
+
+	for name, raw := range schemas {
+		var doc map[string]any
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			t.Errorf("Schema %s is not valid JSON: %v", name, err)
+			continue
+		}
+		if doc["title"] == nil {
+			t.Errorf("Schema %s has no title", name)
+		}
+	}
+}
+
+// TestIsRequestSubject tests that request subjects are told apart from event subjects.
+func TestIsRequestSubject(t *testing.T) {
+	tests := map[string]bool{
+		"iot.device-001.config.update":                true,
+		"iot.device-001.alarms.ack":                   true,
+		"iot.device-001.status":                       true,
+		"iot.device-001.config.reloaded":              false,
+		"iot.device-001.alarms.temp-01":               false,
+		"iot.device-001.readings.temperature.temp-01": false,
+		"iot.device-001.events.state.door.door-01":    false,
+		"other.device-001.config.update":              false,
+	}
+	for subject, want := range tests {
+		if got := IsRequestSubject(subject); got != want {
+			t.Errorf("IsRequestSubject(%s): expected %v, got %v", subject, want, got)
+		}
+	}
+}
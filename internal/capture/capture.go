@@ -0,0 +1,163 @@
+// Package capture records NATS messages to a compact log file and plays them back.
+// A log is gzip-compressed NDJSON with one message per line, so it can also be
+// inspected with zcat and jq. Payloads are kept byte for byte.
+package capture
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Message is one recorded NATS message.
+type Message struct {
+	// Time is when the recorder received the message.
+	Time    time.Time   `json:"t"`
+	Subject string      `json:"s"`
+	Reply   string      `json:"r,omitempty"`
+	Header  nats.Header `json:"h,omitempty"`
+	// Data is the raw payload, base64-encoded in the log.
+	Data []byte `json:"d,omitempty"`
+}
+
+// Writer appends messages to a log. It is not safe for concurrent use.
+type Writer struct {
+	buf *bufio.Writer
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+// NewWriter returns a Writer that writes a compressed log to w.
+func NewWriter(w io.Writer) *Writer {
+	gz := gzip.NewWriter(w)
+	buf := bufio.NewWriter(gz)
+	return &Writer{buf: buf, gz: gz, enc: json.NewEncoder(buf)}
+}
+
+// Write appends msg, received at the given time, to the log.
+func (w *Writer) Write(msg *nats.Msg, received time.Time) error {
+	return w.enc.Encode(Message{
+		Time:    received.UTC(),
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Header:  msg.Header,
+		Data:    msg.Data,
+	})
+}
+
+// Flush pushes the buffered messages to the underlying writer, so an interrupted
+// recording still holds everything written up to the last flush.
+func (w *Writer) Flush() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.gz.Flush()
+}
+
+// Close flushes the log and writes the gzip footer. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+// Reader reads messages from a log.
+type Reader struct {
+	gz  *gzip.Reader
+	dec *json.Decoder
+}
+
+// NewReader returns a Reader for the compressed log in r.
+func NewReader(r io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("opening capture log: %w", err)
+	}
+	return &Reader{gz: gz, dec: json.NewDecoder(gz)}, nil
+}
+
+// Next returns the next message of the log, or io.EOF at its end.
+// A log cut off by an interrupted recording ends with io.ErrUnexpectedEOF.
+func (r *Reader) Next() (Message, error) {
+	var m Message
+	if err := r.dec.Decode(&m); err != nil {
+		return Message{}, err
+	}
+	return m, nil
+}
+
+// Close releases the reader. It does not close the underlying reader.
+func (r *Reader) Close() error {
+	return r.gz.Close()
+}
+
+// Publisher publishes NATS messages; *nats.Conn implements it.
+type Publisher interface {
+	PublishMsg(msg *nats.Msg) error
+}
+
+// PlayOptions controls how Play republishes a log.
+type PlayOptions struct {
+	// Speed divides the relative timing of the messages, so 1 plays in real time and 10
+	// ten times faster; zero or less publishes them without waiting.
+	Speed float64
+	// Skip, if set, leaves out the messages for which it returns true.
+	Skip func(m Message) bool
+}
+
+// Play republishes the messages of r through pub and returns how many were published
+// and how many skipped. Reply subjects are dropped, as the original requesters are gone.
+func Play(ctx context.Context, r *Reader, pub Publisher, opts PlayOptions) (published, skipped int, err error) {
+	var (
+		first time.Time
+		start = time.Now()
+		timer = time.NewTimer(0)
+	)
+	timer.Stop()
+
+	for n := 0; ; n++ {
+		m, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return published, skipped, nil
+		}
+		if err != nil {
+			return published, skipped, fmt.Errorf("reading message %d: %w", n+1, err)
+		}
+
+		if n == 0 {
+			first = m.Time
+		}
+		if opts.Skip != nil && opts.Skip(m) {
+			skipped++
+			continue
+		}
+		if opts.Speed > 0 {
+			due := start.Add(time.Duration(float64(m.Time.Sub(first)) / opts.Speed))
+			if wait := time.Until(due); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-ctx.Done():
+					return published, skipped, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return published, skipped, err
+		}
+
+		msg := &nats.Msg{Subject: m.Subject, Header: m.Header, Data: m.Data}
+		if err := pub.PublishMsg(msg); err != nil {
+			return published, skipped, fmt.Errorf("publishing to %s: %w", m.Subject, err)
+		}
+		published++
+	}
+}
@@ -0,0 +1,165 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// recordingPublisher keeps every published message.
+type recordingPublisher struct {
+	msgs  []*nats.Msg
+	times []time.Time
+}
+
+// PublishMsg records msg and when it was published.
+func (p *recordingPublisher) PublishMsg(msg *nats.Msg) error {
+	p.msgs = append(p.msgs, msg)
+	p.times = append(p.times, time.Now())
+	return nil
+}
+
+// writeLog writes msgs, received one interval apart, to a compressed log.
+func writeLog(t *testing.T, interval time.Duration, msgs ...*nats.Msg) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, msg := range msgs {
+		if err := w.Write(msg, start.Add(time.Duration(i)*interval)); err != nil {
+			t.Fatalf("Error writing message: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Error closing writer: %v", err)
+	}
+	return &buf
+}
+
+// TestRoundTrip tests that subjects, headers and payloads survive a write and a read.
+func TestRoundTrip(t *testing.T) {
+	header := nats.Header{"Traceparent": []string{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}}
+	payload := []byte("{\"value\": 21.5}\n\x00")
+	buf := writeLog(t, time.Second,
+		&nats.Msg{Subject: "iot.device-001.readings.temperature.temp-01", Header: header, Data: payload},
+		&nats.Msg{Subject: "iot.device-001.status", Reply: "_INBOX.abc"},
+	)
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatalf("Error opening reader: %v", err)
+	}
+	defer r.Close()
+
+	first, err := r.Next()
+	if err != nil {
+		t.Fatalf("Error reading message: %v", err)
+	}
+	if first.Subject != "iot.device-001.readings.temperature.temp-01" {
+		t.Errorf("Expected the readings subject, got %s", first.Subject)
+	}
+	if first.Header.Get("Traceparent") != header.Get("Traceparent") {
+		t.Errorf("Expected the traceparent header, got %v", first.Header)
+	}
+	if !bytes.Equal(first.Data, payload) {
+		t.Errorf("Expected payload %q, got %q", payload, first.Data)
+	}
+
+	second, err := r.Next()
+	if err != nil {
+		t.Fatalf("Error reading message: %v", err)
+	}
+	if second.Reply != "_INBOX.abc" || len(second.Data) != 0 {
+		t.Errorf("Unexpected second message: %+v", second)
+	}
+	if second.Time.Sub(first.Time) != time.Second {
+		t.Errorf("Expected messages 1s apart, got %v", second.Time.Sub(first.Time))
+	}
+
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF at the end of the log, got %v", err)
+	}
+}
+
+// TestPlay tests that messages are republished in order at the playback speed, without reply subjects.
+func TestPlay(t *testing.T) {
+	buf := writeLog(t, time.Second,
+		&nats.Msg{Subject: "a", Data: []byte("1")},
+		&nats.Msg{Subject: "b", Reply: "_INBOX.abc", Data: []byte("2")},
+		&nats.Msg{Subject: "c", Data: []byte("3")},
+	)
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatalf("Error opening reader: %v", err)
+	}
+
+	pub := &recordingPublisher{}
+	start := time.Now()
+	n, _, err := Play(context.Background(), r, pub, PlayOptions{Speed: 20})
+	if err != nil {
+		t.Fatalf("Error playing log: %v", err)
+	}
+
+	if n != 3 || len(pub.msgs) != 3 {
+		t.Fatalf("Expected 3 published messages, got %d (%d)", n, len(pub.msgs))
+	}
+	for i, subject := range []string{"a", "b", "c"} {
+		if pub.msgs[i].Subject != subject {
+			t.Errorf("Message %d: expected subject %s, got %s", i, subject, pub.msgs[i].Subject)
+		}
+	}
+	if pub.msgs[1].Reply != "" {
+		t.Errorf("Expected the reply subject to be dropped, got %s", pub.msgs[1].Reply)
+	}
+	// Two seconds of recording at 20x take 100ms.
+	if elapsed := pub.times[2].Sub(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected playback to take at least 100ms, took %v", elapsed)
+	}
+}
+
+// TestPlaySkip tests that skipped messages are counted and not published.
+func TestPlaySkip(t *testing.T) {
+	buf := writeLog(t, time.Second,
+		&nats.Msg{Subject: "a"},
+		&nats.Msg{Subject: "b", Reply: "_INBOX.abc"},
+		&nats.Msg{Subject: "c"},
+	)
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatalf("Error opening reader: %v", err)
+	}
+
+	pub := &recordingPublisher{}
+	skip := func(m Message) bool { return m.Reply != "" }
+	published, skipped, err := Play(context.Background(), r, pub, PlayOptions{Skip: skip})
+	if err != nil {
+		t.Fatalf("Error playing log: %v", err)
+	}
+	if published != 2 || skipped != 1 || len(pub.msgs) != 2 || pub.msgs[1].Subject != "c" {
+		t.Errorf("Expected a and c published and b skipped, got %d published, %d skipped: %v", published, skipped, pub.msgs)
+	}
+}
+
+// TestPlayCanceled tests that playback stops when the context is canceled.
+func TestPlayCanceled(t *testing.T) {
+	buf := writeLog(t, time.Hour,
+		&nats.Msg{Subject: "a"},
+		&nats.Msg{Subject: "b"},
+	)
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatalf("Error opening reader: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	n, _, err := Play(ctx, r, &recordingPublisher{}, PlayOptions{Speed: 1})
+	if !errors.Is(err, context.DeadlineExceeded) || n != 1 {
+		t.Errorf("Expected 1 message and a deadline error, got %d and %v", n, err)
+	}
+}
@@ -2,18 +2,140 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
 	"os"
+	"path"
+	"slices"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"iot-device-simulator/internal/correlation"
+	"iot-device-simulator/internal/expr"
+)
+
+// Default values used when neither the YAML file nor an override sets them.
+const (
+	DefaultStorage       = "mongodb"
+	DefaultMongoURI      = "mongodb://localhost:27017"
+	DefaultMongoDatabase = "iot_simulator"
+	DefaultSQLitePath    = "iot_simulator.db"
+	DefaultLogLevel      = "info"
 )
 
 // Config represents the top-level configuration structure, loaded from a YAML file.
-// It includes device settings, NATS connection info, and a list of sensor configurations.
+// It includes device settings, NATS connection info, storage and tracing settings,
+// and a list of sensor configurations.
 type Config struct {
-	DeviceID string         `yaml:"device_id"`
-	NATS     NATSConfig     `yaml:"nats"`
-	Sensors  []SensorConfig `yaml:"sensors"`
+	DeviceID  string          `yaml:"device_id"`
+	LogLevel  string          `yaml:"log_level"`
+	NATS      NATSConfig      `yaml:"nats"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Telemetry TelemetryConfig `yaml:"telemetry"`
+	Sensors   []SensorConfig  `yaml:"sensors"`
+
+	// Tags are free-form device labels (site, rack, ...) attached to every reading.
+	Tags map[string]string `yaml:"tags"`
+
+	// ReloadInterval is how often the config file is polled for changes.
+	// Zero disables hot-reload.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+
+	// CorrelationGroups make the values of groups of sensors move together.
+	CorrelationGroups []CorrelationGroup `yaml:"correlation_groups"`
+
+	// Rules react to the readings of the device's sensors, like edge logic would.
+	Rules []RuleConfig `yaml:"rules"`
+}
+
+// CorrelationGroup makes the random values of its sensors correlated, as if they shared
+// latent factors. Every reading takes its member's component of a draw of standard normal
+// variables correlated by Matrix, and maps it onto the sensor's range with a Gaussian
+// copula, so each sensor keeps its uniform distribution between Min and Max. A draw is
+// shared by one reading of each member, so members should have the same frequency.
+// The rank correlation of the values is (6/π)·asin(ρ/2), within 0.02 of the entry ρ.
+type CorrelationGroup struct {
+	ID string `yaml:"id"`
+	// Sensors lists the members in the order of the rows and columns of Matrix.
+	// Members draw random values: they have no values, discrete, counter, location,
+	// virtual or replay settings.
+	Sensors []string `yaml:"sensors"`
+	// Matrix is the symmetric, positive definite correlation matrix of the members,
+	// with ones on its diagonal.
+	Matrix [][]float64 `yaml:"matrix"`
+}
+
+// RuleConfig is an edge rule of the device. Once its condition has held for its window,
+// its actions are carried out, once; when the condition stops holding, its on_clear
+// actions are, and the rule is armed again.
+type RuleConfig struct {
+	ID string `yaml:"id"`
+	// Condition is an expression over the latest readings of the device's sensors, written
+	// like the expression of a virtual sensor, that holds while it is non-zero.
+	Condition string `yaml:"condition"`
+	// Window is how long the condition must hold before the actions are carried out.
+	// Zero carries them out on the first reading that meets it.
+	Window  time.Duration `yaml:"window,omitempty"`
+	Actions []RuleAction  `yaml:"actions"`
+	OnClear []RuleAction  `yaml:"on_clear,omitempty"`
+}
+
+// RuleAction is an action of a rule. Exactly one of its fields is set.
+type RuleAction struct {
+	Publish     *PublishAction     `yaml:"publish,omitempty"`
+	Configure   *ConfigureAction   `yaml:"configure,omitempty"`
+	SetActuator *SetActuatorAction `yaml:"set_actuator,omitempty"`
+}
+
+// PublishAction publishes a rule event.
+type PublishAction struct {
+	// Subject defaults to iot.{device}.rules.{rule}.
+	Subject string `yaml:"subject,omitempty"`
+	Message string `yaml:"message,omitempty"`
+}
+
+// ConfigureAction changes the configuration of a sensor. Fields left unset are not changed.
+type ConfigureAction struct {
+	Sensor    string        `yaml:"sensor"`
+	Frequency time.Duration `yaml:"frequency,omitempty"`
+	Min       *float64      `yaml:"min,omitempty"`
+	Max       *float64      `yaml:"max,omitempty"`
+	Enabled   *bool         `yaml:"enabled,omitempty"`
+}
+
+// Apply returns cfg with the changes of the action.
+func (a ConfigureAction) Apply(cfg SensorConfig) SensorConfig {
+	if a.Frequency > 0 {
+		cfg.Frequency = a.Frequency
+	}
+	if a.Min != nil {
+		cfg.Min = *a.Min
+	}
+	if a.Max != nil {
+		cfg.Max = *a.Max
+	}
+	if a.Enabled != nil {
+		cfg.Enabled = *a.Enabled
+	}
+	return cfg
+}
+
+// SetActuatorAction commands an actuator into a state. Actuators are discrete sensors,
+// usually with the markov model and no transitions, so only commands change their state.
+type SetActuatorAction struct {
+	Sensor string `yaml:"sensor"`
+	State  string `yaml:"state"`
+}
+
+// Correlated reports whether the sensor draws its values randomly and can therefore be
+// a member of a correlation group.
+func (c SensorConfig) Correlated() bool {
+	return (c.Generator == "" || c.Generator == GeneratorRandom) && len(c.Values) == 0 &&
+		c.Discrete == nil && c.Counter == nil && c.Location == nil && c.Virtual == nil
 }
 
 // NATSConfig holds the configuration for connecting to the NATS server.
@@ -21,30 +143,1175 @@ type NATSConfig struct {
 	URL string `yaml:"url"`
 }
 
+// StorageConfig holds the persistence settings.
+// Backend is one of "mongodb" (default), "memory", "sqlite" or "none";
+// only the options of the selected backend are used.
+type StorageConfig struct {
+	Backend string        `yaml:"backend"`
+	MongoDB MongoDBConfig `yaml:"mongodb"`
+	Memory  MemoryConfig  `yaml:"memory"`
+	SQLite  SQLiteConfig  `yaml:"sqlite"`
+	Batch   BatchConfig   `yaml:"batch"`
+
+	// Cache keeps recent readings in memory in front of a persistent backend,
+	// sized by Memory.Capacity. It is enabled unless set to false.
+	Cache *bool `yaml:"cache"`
+}
+
+// CacheEnabled reports whether the in-memory read-through cache is enabled.
+func (c StorageConfig) CacheEnabled() bool {
+	return c.Cache == nil || *c.Cache
+}
+
+// BatchConfig holds the settings of asynchronous, batched reading writes.
+// Zero values fall back to the storage package defaults.
+type BatchConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Size          int           `yaml:"size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	QueueSize     int           `yaml:"queue_size"`
+}
+
+// SQLiteConfig holds the settings of the embedded SQLite backend.
+type SQLiteConfig struct {
+	// Path is the database file, created if it does not exist.
+	Path string `yaml:"path"`
+}
+
+// MemoryConfig holds the settings of the in-memory store and cache.
+type MemoryConfig struct {
+	// Capacity is the number of readings kept per sensor.
+	Capacity int `yaml:"capacity"`
+}
+
+// MongoDBConfig holds the configuration for connecting to MongoDB.
+type MongoDBConfig struct {
+	URI      string `yaml:"uri"`
+	Database string `yaml:"database"`
+
+	// Retention is how long readings are kept (expireAfterSeconds); zero keeps them forever.
+	// It is at most MaxRetention.
+	Retention time.Duration `yaml:"retention"`
+	// Granularity of the time-series 'readings' collection: "seconds" (default), "minutes" or "hours".
+	Granularity string `yaml:"granularity"`
+}
+
+// MaxRetention is the longest MongoDB retention: expireAfterSeconds is a 32-bit integer.
+const MaxRetention = math.MaxInt32 * time.Second
+
+// TelemetryConfig holds the OpenTelemetry tracing settings.
+// Exporter is one of "none" (default), "otlp" or "file".
+type TelemetryConfig struct {
+	Exporter string `yaml:"exporter"`
+	Endpoint string `yaml:"endpoint"`
+	Insecure bool   `yaml:"insecure"`
+	File     string `yaml:"file"`
+}
+
+// ReservedSensorIDs are the sensor IDs that cannot be used, because the alarm events
+// of a sensor published on iot.{device}.alarms.{sensor} would reach the alarm requests.
+var ReservedSensorIDs = []string{"active", "list", "ack", "shelve", "unshelve"}
+
 // SensorConfig defines the configuration for a single simulated sensor.
 // This includes its identity, behavior, and operational parameters.
 type SensorConfig struct {
-	ID        string        `yaml:"id"`
-	Type      string        `yaml:"type"`
-	Frequency time.Duration `yaml:"frequency"`
-	Min       float64       `yaml:"min"`
-	Max       float64       `yaml:"max"`
-	Unit      string        `yaml:"unit"`
-	Enabled   bool          `yaml:"enabled"`
+	ID        string        `yaml:"id" json:"id"`
+	Type      string        `yaml:"type" json:"type"`
+	Frequency time.Duration `yaml:"frequency" json:"frequency"`
+	Min       float64       `yaml:"min" json:"min"`
+	Max       float64       `yaml:"max" json:"max"`
+	Unit      string        `yaml:"unit" json:"unit"`
+	Enabled   bool          `yaml:"enabled" json:"enabled"`
+
+	// Generator selects where values come from: "random" (default) draws them
+	// uniformly between Min and Max, "replay" plays back the recording in Replay.
+	Generator string        `yaml:"generator,omitempty" json:"generator,omitempty"`
+	Replay    *ReplayConfig `yaml:"replay,omitempty" json:"replay,omitempty"`
+
+	// Values turns the sensor into a multi-value sensor, such as a 3-axis
+	// accelerometer, whose readings carry one value per named field instead of a
+	// single value. Min and Max are then unused.
+	Values map[string]ValueConfig `yaml:"values,omitempty" json:"values,omitempty"`
+
+	// Discrete turns the sensor into a discrete sensor reporting a boolean or
+	// enumerated state instead of a value. Min and Max are then unused.
+	Discrete *DiscreteConfig `yaml:"discrete,omitempty" json:"discrete,omitempty"`
+
+	// Counter turns the sensor into a cumulative counter, such as a utility meter,
+	// whose value only increases. Min and Max are then unused.
+	Counter *CounterConfig `yaml:"counter,omitempty" json:"counter,omitempty"`
+
+	// Location turns the sensor into a location sensor, such as a vehicle tracker,
+	// whose readings carry a moving position as multiple values. Min and Max are then unused.
+	Location *LocationConfig `yaml:"location,omitempty" json:"location,omitempty"`
+
+	// Virtual turns the sensor into a virtual sensor whose value is computed from the
+	// latest values of other sensors of the device. Min and Max are then unused.
+	Virtual *VirtualConfig `yaml:"virtual,omitempty" json:"virtual,omitempty"`
+
+	// Alarms are threshold alarms evaluated on every valid reading of the sensor.
+	Alarms []AlarmConfig `yaml:"alarms,omitempty" json:"alarms,omitempty"`
+}
+
+// AlarmConfig describes a threshold alarm of a sensor. A "high" or "high_high" alarm is
+// raised when the value rises above Limit, a "low" or "low_low" alarm when it falls below
+// Limit, and a "rate_of_change" alarm when the value changes faster than Limit units per
+// second between two readings. The alarm clears once the value is back past Limit by
+// Deadband, so a value hovering around the limit does not raise it again and again.
+type AlarmConfig struct {
+	Type  string  `yaml:"type" json:"type"`
+	Limit float64 `yaml:"limit" json:"limit"`
+	// Field selects the value field of a multi-value or location sensor, or the delta of
+	// a counter; it is required by multi-value and location sensors.
+	Field    string  `yaml:"field,omitempty" json:"field,omitempty"`
+	Deadband float64 `yaml:"deadband,omitempty" json:"deadband,omitempty"`
+	// DelayOn is how long the condition must hold before the alarm is raised, and
+	// DelayOff how long it must be gone before the alarm clears. Both are checked on
+	// readings, so they are effectively rounded up to the sensor frequency.
+	DelayOn  time.Duration `yaml:"delay_on,omitempty" json:"delay_on,omitempty"`
+	DelayOff time.Duration `yaml:"delay_off,omitempty" json:"delay_off,omitempty"`
+}
+
+// Alarm types.
+const (
+	AlarmHigh         = "high"
+	AlarmHighHigh     = "high_high"
+	AlarmLow          = "low"
+	AlarmLowLow       = "low_low"
+	AlarmRateOfChange = "rate_of_change"
+)
+
+// Name returns the name of the alarm, unique within its sensor: the type, prefixed by
+// the field and a dot if set (e.g. "high" or "speed.high").
+func (c AlarmConfig) Name() string {
+	if c.Field != "" {
+		return c.Field + "." + c.Type
+	}
+	return c.Type
+}
+
+// VirtualConfig describes a virtual sensor. Its value is an expression over the latest
+// valid readings of other sensors, such as "dewpoint(temp-01, humidity-01)" or
+// "avg(temp-*)"; see package expr for the syntax. Patterns never match the sensor itself.
+type VirtualConfig struct {
+	Expression string `yaml:"expression" json:"expression"`
+	// OnChange recomputes the value when an input reports a new reading, at most once
+	// per frequency, instead of every frequency.
+	OnChange bool `yaml:"on_change,omitempty" json:"on_change,omitempty"`
+}
+
+// Inputs returns the sensor IDs of the names an expression refers to among ids, the
+// IDs of the sensors of a device. A name refers to the sensor with that ID or, for a
+// field of a multi-value sensor, to the sensor whose ID is the part before the last dot.
+// It reports false if a name refers to no sensor; patterns may match none.
+func Inputs(name string, ids []string) ([]string, bool) {
+	if expr.IsPattern(name) {
+		var matched []string
+		for _, id := range ids {
+			if ok, _ := path.Match(name, id); ok {
+				matched = append(matched, id)
+			}
+		}
+		return matched, true
+	}
+	if slices.Contains(ids, name) {
+		return []string{name}, true
+	}
+	if i := strings.LastIndex(name, "."); i > 0 && slices.Contains(ids, name[:i]) {
+		return []string{name[:i]}, true
+	}
+	return nil, false
+}
+
+// LocationConfig describes a location sensor moving along a route at a constant speed.
+// Its readings carry the values lat, lon, alt, speed, heading and hdop.
+type LocationConfig struct {
+	// Route is a GeoJSON file whose first LineString is followed, with [lon, lat] or
+	// [lon, lat, alt] coordinates. Relative paths are resolved against the working directory.
+	Route string `yaml:"route,omitempty" json:"route,omitempty"`
+	// Without a route, the sensor drives a closed loop through Waypoints random points
+	// within Radius meters of Center.
+	Center    Position `yaml:"center,omitempty" json:"center,omitempty"`
+	Radius    float64  `yaml:"radius,omitempty" json:"radius,omitempty"`
+	Waypoints int      `yaml:"waypoints,omitempty" json:"waypoints,omitempty"`
+	// Speed is the speed along the route in meters per second.
+	Speed float64 `yaml:"speed" json:"speed"`
+	// Loop restarts a route from its first point once its end is reached; otherwise
+	// the sensor stops there. Random routes always loop.
+	Loop bool `yaml:"loop,omitempty" json:"loop,omitempty"`
+	// Noise is the standard deviation of the horizontal position error in meters at an
+	// HDOP of 1. It scales with the HDOP, and the altitude error is 1.5 times larger.
+	Noise float64 `yaml:"noise,omitempty" json:"noise,omitempty"`
+	// HDOP is the horizontal dilution of precision, following the range and model of a
+	// value field. An empty range means a constant HDOP of 1.
+	HDOP ValueConfig `yaml:"hdop,omitempty" json:"hdop,omitempty"`
+	// FixLoss is the chance of each reading that the sensor loses its fix for a time
+	// within FixLossDuration, or for that reading only without a duration. Readings
+	// without a fix carry an error and no position.
+	FixLoss         float64     `yaml:"fix_loss,omitempty" json:"fix_loss,omitempty"`
+	FixLossDuration DwellConfig `yaml:"fix_loss_duration,omitempty" json:"fix_loss_duration,omitempty"`
+}
+
+// Position is a WGS 84 position in degrees, with the altitude in meters.
+type Position struct {
+	Lat float64 `yaml:"lat" json:"lat"`
+	Lon float64 `yaml:"lon" json:"lon"`
+	Alt float64 `yaml:"alt,omitempty" json:"alt,omitempty"`
+}
+
+// CounterConfig describes a counter sensor. Its value is the integral of Rate over
+// time, wrapping to zero at Width bits, and restarting from zero when it is reset.
+type CounterConfig struct {
+	// Rate is the increase per second, following the range and model of a value field.
+	// Its Min must not be negative.
+	Rate ValueConfig `yaml:"rate" json:"rate"`
+	// Initial is the value the counter starts from.
+	Initial float64 `yaml:"initial,omitempty" json:"initial,omitempty"`
+	// Width is the size of the counter in bits; it rolls over to zero at 2^Width.
+	// Zero means it never rolls over.
+	Width int `yaml:"width,omitempty" json:"width,omitempty"`
+	// ResetProbability is the chance of each reading that the counter restarts
+	// from zero first, simulating a device reboot.
+	ResetProbability float64 `yaml:"reset_probability,omitempty" json:"reset_probability,omitempty"`
+	// Delta also publishes the increase since the previous reading as values["delta"].
+	// It is the true increase, regardless of rollovers and resets.
+	Delta bool `yaml:"delta,omitempty" json:"delta,omitempty"`
+}
+
+// Rollover returns the value at which the counter wraps to zero, or +Inf without a width.
+func (c *CounterConfig) Rollover() float64 {
+	if c.Width == 0 {
+		return math.Inf(1)
+	}
+	return math.Ldexp(1, c.Width)
+}
+
+// ValueConfig defines one named field of a multi-value sensor.
+type ValueConfig struct {
+	Min float64 `yaml:"min" json:"min"`
+	Max float64 `yaml:"max" json:"max"`
+	// Model selects how the value evolves: "uniform" (default) draws every value
+	// between Min and Max, "random_walk" moves up to Step away from the previous
+	// value, and "sine" oscillates between Min and Max once every Period.
+	Model string `yaml:"model,omitempty" json:"model,omitempty"`
+	// Step is the largest change of a random walk per reading; zero means a tenth of the range.
+	Step   float64       `yaml:"step,omitempty" json:"step,omitempty"`
+	Period time.Duration `yaml:"period,omitempty" json:"period,omitempty"`
+}
+
+// Value models of multi-value sensor fields.
+const (
+	ModelUniform    = "uniform"
+	ModelRandomWalk = "random_walk"
+	ModelSine       = "sine"
+)
+
+// Sensor value generators.
+const (
+	GeneratorRandom = "random"
+	GeneratorReplay = "replay"
+)
+
+// ReplayConfig describes a recording played back by the "replay" generator.
+type ReplayConfig struct {
+	// File is a CSV or NDJSON recording with timestamp and value fields.
+	// Relative paths are resolved against the working directory.
+	File string `yaml:"file" json:"file"`
+	// Format is "csv" or "ndjson"; it is guessed from the file extension if empty.
+	Format string `yaml:"format,omitempty" json:"format,omitempty"`
+	// Speed scales the playback rate: 2 plays twice as fast. Zero means 1.
+	Speed float64 `yaml:"speed,omitempty" json:"speed,omitempty"`
+	// Loop restarts the recording, one sensor frequency after its last sample.
+	Loop bool `yaml:"loop,omitempty" json:"loop,omitempty"`
+	// Interpolate emits a reading every sensor frequency, linearly interpolated
+	// between the recorded samples, instead of one reading per sample.
+	Interpolate bool `yaml:"interpolate,omitempty" json:"interpolate,omitempty"`
+	// RebaseTimestamps stamps readings with the current time instead of the recorded one.
+	RebaseTimestamps bool `yaml:"rebase_timestamps,omitempty" json:"rebase_timestamps,omitempty"`
+}
+
+// MarshalJSON encodes the sensor configuration with its frequency
+// as a duration string (e.g. "5s"), matching the YAML representation.
+func (c SensorConfig) MarshalJSON() ([]byte, error) {
+	type alias SensorConfig
+	return json.Marshal(struct {
+		alias
+		Frequency string `json:"frequency"`
+	}{alias(c), c.Frequency.String()})
+}
+
+// UnmarshalJSON decodes a sensor configuration whose frequency is a duration string.
+func (c *SensorConfig) UnmarshalJSON(data []byte) error {
+	type alias SensorConfig
+	aux := struct {
+		*alias
+		Frequency string `json:"frequency"`
+	}{alias: (*alias)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.Frequency == "" {
+		return nil
+	}
+	frequency, err := time.ParseDuration(aux.Frequency)
+	if err != nil {
+		return fmt.Errorf("frequency: %w", err)
+	}
+	c.Frequency = frequency
+	return nil
+}
+
+// DiscreteConfig describes the states of a discrete sensor and how it moves between them.
+// The model is stepped every sensor frequency; a reading is published when the state
+// changes and, if Keepalive is set, when the state has not been reported for that long.
+type DiscreteConfig struct {
+	// Kind is "boolean" (default) or "enum".
+	Kind string `yaml:"kind,omitempty" json:"kind,omitempty"`
+	// States names the states. Boolean sensors have two, "false" and "true" by default;
+	// enumerated sensors need at least two.
+	States []string `yaml:"states,omitempty" json:"states,omitempty"`
+	// Initial is the state the sensor starts in; it defaults to the first state.
+	Initial string `yaml:"initial,omitempty" json:"initial,omitempty"`
+	// Model is "markov" (default) or "dwell". With "markov", Transitions holds the
+	// probability of moving from one state to another at each step. With "dwell",
+	// the sensor stays in each state for a random time within its Dwell range and
+	// then moves to another state, chosen with the Transitions weights if any,
+	// uniformly otherwise.
+	Model       string                        `yaml:"model,omitempty" json:"model,omitempty"`
+	Transitions map[string]map[string]float64 `yaml:"transitions,omitempty" json:"transitions,omitempty"`
+	Dwell       map[string]DwellConfig        `yaml:"dwell,omitempty" json:"dwell,omitempty"`
+	// Keepalive republishes an unchanged state this often; zero reports by exception only.
+	Keepalive time.Duration `yaml:"keepalive,omitempty" json:"keepalive,omitempty"`
+}
+
+// DwellConfig is the range of time a discrete sensor stays in a state.
+type DwellConfig struct {
+	Min time.Duration `yaml:"min" json:"min"`
+	Max time.Duration `yaml:"max" json:"max"`
+}
+
+// Discrete sensor kinds and models.
+const (
+	KindBoolean = "boolean"
+	KindEnum    = "enum"
+
+	ModelMarkov = "markov"
+	ModelDwell  = "dwell"
+)
+
+// StateNames returns the states of the sensor, with the boolean defaults applied.
+func (c *DiscreteConfig) StateNames() []string {
+	if len(c.States) == 0 && (c.Kind == "" || c.Kind == KindBoolean) {
+		return []string{"false", "true"}
+	}
+	return c.States
+}
+
+// InitialState returns the state the sensor starts in.
+func (c *DiscreteConfig) InitialState() string {
+	if c.Initial != "" {
+		return c.Initial
+	}
+	if states := c.StateNames(); len(states) > 0 {
+		return states[0]
+	}
+	return ""
+}
+
+// MarshalJSON encodes the discrete configuration with its keepalive as a duration string.
+func (c DiscreteConfig) MarshalJSON() ([]byte, error) {
+	type alias DiscreteConfig
+	aux := struct {
+		alias
+		Keepalive string `json:"keepalive,omitempty"`
+	}{alias: alias(c)}
+	if c.Keepalive != 0 {
+		aux.Keepalive = c.Keepalive.String()
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON decodes a discrete configuration whose keepalive is a duration string.
+func (c *DiscreteConfig) UnmarshalJSON(data []byte) error {
+	type alias DiscreteConfig
+	aux := struct {
+		*alias
+		Keepalive string `json:"keepalive"`
+	}{alias: (*alias)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.Keepalive == "" {
+		return nil
+	}
+	keepalive, err := time.ParseDuration(aux.Keepalive)
+	if err != nil {
+		return fmt.Errorf("keepalive: %w", err)
+	}
+	c.Keepalive = keepalive
+	return nil
+}
+
+// MarshalJSON encodes the dwell range as duration strings.
+func (c DwellConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Min string `json:"min"`
+		Max string `json:"max"`
+	}{c.Min.String(), c.Max.String()})
+}
+
+// UnmarshalJSON decodes a dwell range given as duration strings.
+func (c *DwellConfig) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Min string `json:"min"`
+		Max string `json:"max"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	var err error
+	if c.Min, err = time.ParseDuration(aux.Min); err != nil {
+		return fmt.Errorf("min: %w", err)
+	}
+	if c.Max, err = time.ParseDuration(aux.Max); err != nil {
+		return fmt.Errorf("max: %w", err)
+	}
+	return nil
+}
+
+// MarshalJSON encodes the value configuration with its period as a duration string.
+func (c ValueConfig) MarshalJSON() ([]byte, error) {
+	type alias ValueConfig
+	aux := struct {
+		alias
+		Period string `json:"period,omitempty"`
+	}{alias: alias(c)}
+	if c.Period != 0 {
+		aux.Period = c.Period.String()
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON decodes a value configuration whose period is a duration string.
+func (c *ValueConfig) UnmarshalJSON(data []byte) error {
+	type alias ValueConfig
+	aux := struct {
+		*alias
+		Period string `json:"period"`
+	}{alias: (*alias)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.Period == "" {
+		return nil
+	}
+	period, err := time.ParseDuration(aux.Period)
+	if err != nil {
+		return fmt.Errorf("period: %w", err)
+	}
+	c.Period = period
+	return nil
+}
+
+// MarshalJSON encodes the alarm configuration with its delays as duration strings.
+func (c AlarmConfig) MarshalJSON() ([]byte, error) {
+	type alias AlarmConfig
+	aux := struct {
+		alias
+		DelayOn  string `json:"delay_on,omitempty"`
+		DelayOff string `json:"delay_off,omitempty"`
+	}{alias: alias(c)}
+	if c.DelayOn != 0 {
+		aux.DelayOn = c.DelayOn.String()
+	}
+	if c.DelayOff != 0 {
+		aux.DelayOff = c.DelayOff.String()
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON decodes an alarm configuration whose delays are duration strings.
+func (c *AlarmConfig) UnmarshalJSON(data []byte) error {
+	type alias AlarmConfig
+	aux := struct {
+		*alias
+		DelayOn  string `json:"delay_on"`
+		DelayOff string `json:"delay_off"`
+	}{alias: (*alias)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	var err error
+	if aux.DelayOn != "" {
+		if c.DelayOn, err = time.ParseDuration(aux.DelayOn); err != nil {
+			return fmt.Errorf("delay_on: %w", err)
+		}
+	}
+	if aux.DelayOff != "" {
+		if c.DelayOff, err = time.ParseDuration(aux.DelayOff); err != nil {
+			return fmt.Errorf("delay_off: %w", err)
+		}
+	}
+	return nil
 }
 
 // Load reads a YAML configuration file from the given path and decodes it into a Config struct.
-// It returns the populated Config struct or an error if the file cannot be read or parsed.
-func Load(filename string) (*Config, error) {
+// ${VAR} references in the file are replaced by environment variables (see Interpolate),
+// defaults are filled in, and the overrides are applied in order, so later ones win.
+// It returns the populated Config struct or an error if the file cannot be read or parsed,
+// or if the configuration is invalid (see Validate).
+func Load(filename string, overrides ...Overrides) (*Config, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
 	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	if err := yaml.Unmarshal(Interpolate(data, os.LookupEnv), &config); err != nil {
+		return nil, err
+	}
+
+	config.applyDefaults()
+	for _, o := range overrides {
+		o.Apply(&config)
+	}
+
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 
 	return &config, nil
 }
+
+// Problem describes a single invalid configuration field.
+type Problem struct {
+	Field   string
+	Message string
+}
+
+// ValidationError lists every problem found while validating a configuration.
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error joins all problems into a single message, e.g.
+// "invalid configuration: sensors[0].frequency: must be greater than zero".
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		parts[i] = p.Field + ": " + p.Message
+	}
+	return "invalid configuration: " + strings.Join(parts, "; ")
+}
+
+// validationError returns a *ValidationError for problems, or nil if there are none.
+func validationError(problems []Problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// Validate checks the whole configuration and reports all problems found,
+// with field paths relative to the top of the YAML document.
+func (c *Config) Validate() error {
+	var problems []Problem
+
+	if c.DeviceID == "" {
+		problems = append(problems, Problem{"device_id", "is required"})
+	}
+
+	switch c.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, Problem{"log_level", fmt.Sprintf("unknown level %q (want debug, info, warn or error)", c.LogLevel)})
+	}
+
+	switch c.Storage.Backend {
+	case "", "mongodb", "memory", "sqlite", "none":
+	default:
+		problems = append(problems, Problem{"storage.backend", fmt.Sprintf("unknown backend %q (want mongodb, memory, sqlite or none)", c.Storage.Backend)})
+	}
+
+	if c.Storage.MongoDB.Retention < 0 {
+		problems = append(problems, Problem{"storage.mongodb.retention", "must not be negative"})
+	} else if c.Storage.MongoDB.Retention > 0 && c.Storage.MongoDB.Retention < time.Second {
+		problems = append(problems, Problem{"storage.mongodb.retention", "must be at least 1s"})
+	} else if c.Storage.MongoDB.Retention > MaxRetention {
+		problems = append(problems, Problem{"storage.mongodb.retention", fmt.Sprintf("must be at most %v", MaxRetention)})
+	}
+	switch c.Storage.MongoDB.Granularity {
+	case "", "seconds", "minutes", "hours":
+	default:
+		problems = append(problems, Problem{"storage.mongodb.granularity", fmt.Sprintf("unknown granularity %q (want seconds, minutes or hours)", c.Storage.MongoDB.Granularity)})
+	}
+
+	if c.Storage.Memory.Capacity < 0 {
+		problems = append(problems, Problem{"storage.memory.capacity", "must not be negative"})
+	}
+
+	if c.Storage.Batch.Size < 0 {
+		problems = append(problems, Problem{"storage.batch.size", "must not be negative"})
+	}
+	if c.Storage.Batch.FlushInterval < 0 {
+		problems = append(problems, Problem{"storage.batch.flush_interval", "must not be negative"})
+	}
+	if c.Storage.Batch.QueueSize < 0 {
+		problems = append(problems, Problem{"storage.batch.queue_size", "must not be negative"})
+	}
+
+	if c.ReloadInterval < 0 {
+		problems = append(problems, Problem{"reload_interval", "must not be negative"})
+	}
+
+	switch c.Telemetry.Exporter {
+	case "", "none", "otlp":
+	case "file":
+		if c.Telemetry.File == "" {
+			problems = append(problems, Problem{"telemetry.file", "is required by the file exporter"})
+		}
+	default:
+		problems = append(problems, Problem{"telemetry.exporter", fmt.Sprintf("unknown exporter %q", c.Telemetry.Exporter)})
+	}
+
+	seen := make(map[string]int)
+	for i, sensor := range c.Sensors {
+		prefix := fmt.Sprintf("sensors[%d].", i)
+		problems = append(problems, sensor.problems(prefix)...)
+
+		if sensor.ID == "" {
+			continue
+		}
+		if first, ok := seen[sensor.ID]; ok {
+			problems = append(problems, Problem{prefix + "id", fmt.Sprintf("duplicate sensor ID %q (also sensors[%d])", sensor.ID, first)})
+			continue
+		}
+		seen[sensor.ID] = i
+	}
+
+	ids := make([]string, len(c.Sensors))
+	for i, sensor := range c.Sensors {
+		ids[i] = sensor.ID
+	}
+	grouped := make(map[string]int)
+	for i, group := range c.CorrelationGroups {
+		problems = append(problems, group.problems(fmt.Sprintf("correlation_groups[%d].", i), c.Sensors)...)
+		for _, id := range group.Sensors {
+			if first, ok := grouped[id]; ok {
+				if first != i { // duplicates within a group are reported with it
+					problems = append(problems, Problem{fmt.Sprintf("correlation_groups[%d].sensors", i), fmt.Sprintf("sensor %q is also in correlation_groups[%d]", id, first)})
+				}
+				continue
+			}
+			grouped[id] = i
+		}
+	}
+
+	for i, sensor := range c.Sensors {
+		if sensor.Virtual == nil {
+			continue
+		}
+		e, err := expr.Parse(sensor.Virtual.Expression)
+		if err != nil {
+			continue // reported with the sensor
+		}
+		for _, name := range e.Refs() {
+			if _, ok := Inputs(name, ids); !ok {
+				problems = append(problems, Problem{fmt.Sprintf("sensors[%d].virtual.expression", i), fmt.Sprintf("unknown sensor %q", name)})
+			}
+		}
+	}
+
+	rules := make(map[string]int)
+	for i, rule := range c.Rules {
+		prefix := fmt.Sprintf("rules[%d].", i)
+		problems = append(problems, rule.problems(prefix, c.Sensors)...)
+
+		if rule.ID == "" {
+			continue
+		}
+		if first, ok := rules[rule.ID]; ok {
+			problems = append(problems, Problem{prefix + "id", fmt.Sprintf("duplicate rule ID %q (also rules[%d])", rule.ID, first)})
+			continue
+		}
+		rules[rule.ID] = i
+	}
+
+	return validationError(problems)
+}
+
+// Validate checks a single sensor configuration and reports all problems found.
+func (c SensorConfig) Validate() error {
+	return validationError(c.problems(""))
+}
+
+// problems returns the problems of the sensor configuration, with field names prefixed by prefix.
+func (c SensorConfig) problems(prefix string) []Problem {
+	var problems []Problem
+
+	switch {
+	case c.ID == "":
+		problems = append(problems, Problem{prefix + "id", "is required"})
+	case strings.ContainsAny(c.ID, ".*> \t"):
+		// The ID is a token of NATS subjects, and "a.b" names field b of sensor a in expressions.
+		problems = append(problems, Problem{prefix + "id", "must not contain dots, wildcards or spaces"})
+	case slices.Contains(ReservedSensorIDs, c.ID):
+		problems = append(problems, Problem{prefix + "id", fmt.Sprintf("%q is reserved for the alarm requests", c.ID)})
+	}
+	if c.Type == "" {
+		problems = append(problems, Problem{prefix + "type", "is required"})
+	}
+	if c.Frequency <= 0 {
+		problems = append(problems, Problem{prefix + "frequency", "must be greater than zero"})
+	}
+	if c.Min > c.Max {
+		problems = append(problems, Problem{prefix + "min", fmt.Sprintf("%v is greater than max %v", c.Min, c.Max)})
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.Values)) {
+		problems = append(problems, c.Values[name].problems(fmt.Sprintf("%svalues.%s.", prefix, name), name)...)
+	}
+
+	if c.Discrete != nil {
+		problems = append(problems, c.Discrete.problems(prefix+"discrete.")...)
+		if len(c.Values) > 0 {
+			problems = append(problems, Problem{prefix + "values", "cannot be combined with discrete"})
+		}
+	}
+	if c.Counter != nil {
+		problems = append(problems, c.Counter.problems(prefix+"counter.")...)
+		if len(c.Values) > 0 || c.Discrete != nil {
+			problems = append(problems, Problem{prefix + "counter", "cannot be combined with values or discrete"})
+		}
+	}
+	if c.Location != nil {
+		problems = append(problems, c.Location.problems(prefix+"location.")...)
+		if len(c.Values) > 0 || c.Discrete != nil || c.Counter != nil {
+			problems = append(problems, Problem{prefix + "location", "cannot be combined with values, discrete or counter"})
+		}
+	}
+	if c.Virtual != nil {
+		problems = append(problems, c.Virtual.problems(prefix+"virtual.", c.ID)...)
+		if len(c.Values) > 0 || c.Discrete != nil || c.Counter != nil || c.Location != nil {
+			problems = append(problems, Problem{prefix + "virtual", "cannot be combined with values, discrete, counter or location"})
+		}
+	}
+
+	for i, alarm := range c.Alarms {
+		field := fmt.Sprintf("%salarms[%d]", prefix, i)
+		problems = append(problems, alarm.problems(field+".", c)...)
+		if j := slices.IndexFunc(c.Alarms, func(a AlarmConfig) bool { return a.Name() == alarm.Name() }); j != i {
+			problems = append(problems, Problem{field, fmt.Sprintf("alarm %q is also alarms[%d]", alarm.Name(), j)})
+		}
+	}
+
+	switch c.Generator {
+	case "", GeneratorRandom:
+	case GeneratorReplay:
+		problems = append(problems, c.Replay.problems(prefix+"replay.")...)
+		if len(c.Values) > 0 {
+			problems = append(problems, Problem{prefix + "values", "are not supported by the replay generator"})
+		}
+		if c.Discrete != nil {
+			problems = append(problems, Problem{prefix + "discrete", "is not supported by the replay generator"})
+		}
+		if c.Counter != nil {
+			problems = append(problems, Problem{prefix + "counter", "is not supported by the replay generator"})
+		}
+		if c.Location != nil {
+			problems = append(problems, Problem{prefix + "location", "is not supported by the replay generator"})
+		}
+		if c.Virtual != nil {
+			problems = append(problems, Problem{prefix + "virtual", "is not supported by the replay generator"})
+		}
+	default:
+		problems = append(problems, Problem{prefix + "generator", fmt.Sprintf("unknown generator %q (want random or replay)", c.Generator)})
+	}
+
+	return problems
+}
+
+// problems returns the problems of the value field called name, with field names prefixed by prefix.
+func (c ValueConfig) problems(prefix, name string) []Problem {
+	var problems []Problem
+
+	if name == "" || strings.ContainsAny(name, ".$ ") {
+		problems = append(problems, Problem{strings.TrimSuffix(prefix, "."), "name must be non-empty without dots, dollar signs or spaces"})
+	}
+	if c.Min > c.Max {
+		problems = append(problems, Problem{prefix + "min", fmt.Sprintf("%v is greater than max %v", c.Min, c.Max)})
+	}
+	switch c.Model {
+	case "", ModelUniform, ModelRandomWalk:
+	case ModelSine:
+		if c.Period <= 0 {
+			problems = append(problems, Problem{prefix + "period", "must be greater than zero for the sine model"})
+		}
+	default:
+		problems = append(problems, Problem{prefix + "model", fmt.Sprintf("unknown model %q (want uniform, random_walk or sine)", c.Model)})
+	}
+	if c.Step < 0 {
+		problems = append(problems, Problem{prefix + "step", "must not be negative"})
+	}
+	return problems
+}
+
+// problems returns the problems of a discrete configuration, with field names prefixed by prefix.
+func (c *DiscreteConfig) problems(prefix string) []Problem {
+	var problems []Problem
+
+	states := c.StateNames()
+	switch c.Kind {
+	case "", KindBoolean:
+		if len(states) != 2 {
+			problems = append(problems, Problem{prefix + "states", "a boolean sensor has exactly two states"})
+		}
+	case KindEnum:
+		if len(states) < 2 {
+			problems = append(problems, Problem{prefix + "states", "an enum sensor needs at least two states"})
+		}
+	default:
+		problems = append(problems, Problem{prefix + "kind", fmt.Sprintf("unknown kind %q (want boolean or enum)", c.Kind)})
+	}
+	for i, state := range states {
+		if state == "" || slices.Index(states, state) != i {
+			problems = append(problems, Problem{fmt.Sprintf("%sstates[%d]", prefix, i), "must be non-empty and unique"})
+		}
+	}
+	if c.Initial != "" && !slices.Contains(states, c.Initial) {
+		problems = append(problems, Problem{prefix + "initial", fmt.Sprintf("unknown state %q", c.Initial)})
+	}
+
+	for _, from := range slices.Sorted(maps.Keys(c.Transitions)) {
+		field := fmt.Sprintf("%stransitions.%s", prefix, from)
+		if !slices.Contains(states, from) {
+			problems = append(problems, Problem{field, fmt.Sprintf("unknown state %q", from)})
+			continue
+		}
+		total := 0.0
+		for _, to := range slices.Sorted(maps.Keys(c.Transitions[from])) {
+			p := c.Transitions[from][to]
+			switch {
+			case !slices.Contains(states, to) || to == from:
+				problems = append(problems, Problem{field + "." + to, "must name another state"})
+			case p < 0 || (p > 1 && c.Model != ModelDwell):
+				problems = append(problems, Problem{field + "." + to, fmt.Sprintf("%v is not a probability", p)})
+			}
+			total += p
+		}
+		if total > 1 && c.Model != ModelDwell {
+			problems = append(problems, Problem{field, fmt.Sprintf("probabilities add up to %v, more than 1", total)})
+		}
+	}
+
+	switch c.Model {
+	case "", ModelMarkov:
+	case ModelDwell:
+		for _, state := range states {
+			field := fmt.Sprintf("%sdwell.%s", prefix, state)
+			d, ok := c.Dwell[state]
+			switch {
+			case !ok:
+				problems = append(problems, Problem{field, "is required by the dwell model"})
+			case d.Min < 0 || d.Max <= 0 || d.Min > d.Max:
+				problems = append(problems, Problem{field, "needs 0 <= min <= max and max > 0"})
+			}
+		}
+	default:
+		problems = append(problems, Problem{prefix + "model", fmt.Sprintf("unknown model %q (want markov or dwell)", c.Model)})
+	}
+	for _, state := range slices.Sorted(maps.Keys(c.Dwell)) {
+		if !slices.Contains(states, state) {
+			problems = append(problems, Problem{fmt.Sprintf("%sdwell.%s", prefix, state), fmt.Sprintf("unknown state %q", state)})
+		}
+	}
+
+	if c.Keepalive < 0 {
+		problems = append(problems, Problem{prefix + "keepalive", "must not be negative"})
+	}
+	return problems
+}
+
+// problems returns the problems of a counter configuration, with field names prefixed by prefix.
+func (c *CounterConfig) problems(prefix string) []Problem {
+	problems := c.Rate.problems(prefix+"rate.", "rate")
+	if c.Rate.Min < 0 {
+		problems = append(problems, Problem{prefix + "rate.min", "must not be negative, counters only increase"})
+	}
+	if c.Width < 0 || c.Width > 64 {
+		problems = append(problems, Problem{prefix + "width", fmt.Sprintf("%d is not between 0 and 64 bits", c.Width)})
+	} else if c.Initial < 0 || c.Initial >= c.Rollover() {
+		problems = append(problems, Problem{prefix + "initial", fmt.Sprintf("%v is outside the range of the counter", c.Initial)})
+	}
+	if c.ResetProbability < 0 || c.ResetProbability > 1 {
+		problems = append(problems, Problem{prefix + "reset_probability", fmt.Sprintf("%v is not a probability", c.ResetProbability)})
+	}
+	return problems
+}
+
+// problems returns the problems of a location configuration, with field names prefixed by prefix.
+func (c *LocationConfig) problems(prefix string) []Problem {
+	var problems []Problem
+
+	if c.Route == "" {
+		if c.Waypoints < 2 {
+			problems = append(problems, Problem{prefix + "waypoints", "at least two are needed without a route"})
+		}
+		if c.Radius <= 0 {
+			problems = append(problems, Problem{prefix + "radius", "must be greater than zero without a route"})
+		}
+		if c.Center.Lat < -90 || c.Center.Lat > 90 || c.Center.Lon < -180 || c.Center.Lon > 180 {
+			problems = append(problems, Problem{prefix + "center", fmt.Sprintf("%v, %v is not a valid position", c.Center.Lat, c.Center.Lon)})
+		}
+	} else if c.Waypoints != 0 {
+		problems = append(problems, Problem{prefix + "waypoints", "cannot be combined with route"})
+	}
+	if c.Speed < 0 {
+		problems = append(problems, Problem{prefix + "speed", "must not be negative"})
+	}
+	if c.Noise < 0 {
+		problems = append(problems, Problem{prefix + "noise", "must not be negative"})
+	}
+	problems = append(problems, c.HDOP.problems(prefix+"hdop.", "hdop")...)
+	if c.HDOP.Min < 0 {
+		problems = append(problems, Problem{prefix + "hdop.min", "must not be negative"})
+	}
+	if c.FixLoss < 0 || c.FixLoss > 1 {
+		problems = append(problems, Problem{prefix + "fix_loss", fmt.Sprintf("%v is not a probability", c.FixLoss)})
+	}
+	if d := c.FixLossDuration; d.Min < 0 || d.Min > d.Max {
+		problems = append(problems, Problem{prefix + "fix_loss_duration", "needs 0 <= min <= max"})
+	}
+	return problems
+}
+
+// problems returns the problems of the virtual configuration of the sensor called id,
+// with field names prefixed by prefix. References to other sensors are checked by Config.Validate.
+func (c *VirtualConfig) problems(prefix, id string) []Problem {
+	e, err := expr.Parse(c.Expression)
+	if err != nil {
+		return []Problem{{prefix + "expression", err.Error()}}
+	}
+	for _, name := range e.Refs() {
+		if _, self := Inputs(name, []string{id}); self && !expr.IsPattern(name) {
+			return []Problem{{prefix + "expression", "cannot refer to the sensor itself"}}
+		}
+	}
+	return nil
+}
+
+// problems returns the problems of an alarm of the sensor, with field names prefixed by prefix.
+func (c AlarmConfig) problems(prefix string, sensor SensorConfig) []Problem {
+	var problems []Problem
+
+	switch c.Type {
+	case AlarmHigh, AlarmHighHigh, AlarmLow, AlarmLowLow:
+	case AlarmRateOfChange:
+		if c.Limit <= 0 {
+			problems = append(problems, Problem{prefix + "limit", "must be greater than zero for a rate_of_change alarm"})
+		}
+	default:
+		problems = append(problems, Problem{prefix + "type", fmt.Sprintf("unknown type %q (want high, high_high, low, low_low or rate_of_change)", c.Type)})
+	}
+	if math.IsNaN(c.Limit) || math.IsInf(c.Limit, 0) {
+		problems = append(problems, Problem{prefix + "limit", "must be a finite number"})
+	}
+
+	switch {
+	case len(sensor.Values) > 0:
+		if _, ok := sensor.Values[c.Field]; !ok {
+			problems = append(problems, Problem{prefix + "field", fmt.Sprintf("%q is not a value field of the sensor", c.Field)})
+		}
+	case sensor.Location != nil:
+		if c.Field == "" {
+			problems = append(problems, Problem{prefix + "field", "is required by location sensors"})
+		}
+	case sensor.Counter != nil && c.Field == "delta":
+		if !sensor.Counter.Delta {
+			problems = append(problems, Problem{prefix + "field", "the counter does not publish its delta"})
+		}
+	case c.Field != "":
+		problems = append(problems, Problem{prefix + "field", "the sensor has a single value"})
+	}
+
+	if c.Deadband < 0 {
+		problems = append(problems, Problem{prefix + "deadband", "must not be negative"})
+	}
+	if c.DelayOn < 0 {
+		problems = append(problems, Problem{prefix + "delay_on", "must not be negative"})
+	}
+	if c.DelayOff < 0 {
+		problems = append(problems, Problem{prefix + "delay_off", "must not be negative"})
+	}
+	return problems
+}
+
+// problems returns the problems of a correlation group of the sensors, with field names
+// prefixed by prefix. Sensors in several groups are reported by Config.Validate.
+func (g CorrelationGroup) problems(prefix string, sensors []SensorConfig) []Problem {
+	var problems []Problem
+
+	if g.ID == "" {
+		problems = append(problems, Problem{prefix + "id", "is required"})
+	}
+	if len(g.Sensors) < 2 {
+		problems = append(problems, Problem{prefix + "sensors", "a group needs at least two sensors"})
+	}
+	for i, id := range g.Sensors {
+		field := fmt.Sprintf("%ssensors[%d]", prefix, i)
+		j := slices.IndexFunc(sensors, func(sc SensorConfig) bool { return sc.ID == id })
+		switch {
+		case j < 0:
+			problems = append(problems, Problem{field, fmt.Sprintf("unknown sensor %q", id)})
+		case !sensors[j].Correlated():
+			problems = append(problems, Problem{field, fmt.Sprintf("sensor %q does not draw random values", id)})
+		case slices.Index(g.Sensors, id) != i:
+			problems = append(problems, Problem{field, fmt.Sprintf("sensor %q is listed twice", id)})
+		}
+	}
+
+	if len(g.Matrix) != len(g.Sensors) {
+		return append(problems, Problem{prefix + "matrix", fmt.Sprintf("has %d rows, want one per sensor (%d)", len(g.Matrix), len(g.Sensors))})
+	}
+	for i, row := range g.Matrix {
+		if len(row) != len(g.Sensors) {
+			return append(problems, Problem{fmt.Sprintf("%smatrix[%d]", prefix, i), fmt.Sprintf("has %d entries, want %d", len(row), len(g.Sensors))})
+		}
+		for j, r := range row {
+			switch {
+			case i == j && r != 1:
+				problems = append(problems, Problem{fmt.Sprintf("%smatrix[%d][%d]", prefix, i, j), "must be 1 on the diagonal"})
+			case r < -1 || r > 1:
+				problems = append(problems, Problem{fmt.Sprintf("%smatrix[%d][%d]", prefix, i, j), fmt.Sprintf("%v is not a correlation", r)})
+			case j > i && r != g.Matrix[j][i]:
+				problems = append(problems, Problem{fmt.Sprintf("%smatrix[%d][%d]", prefix, i, j), fmt.Sprintf("differs from matrix[%d][%d]", j, i)})
+			}
+		}
+	}
+	if len(problems) == 0 {
+		if _, err := correlation.Cholesky(g.Matrix); err != nil {
+			problems = append(problems, Problem{prefix + "matrix", "is not positive definite"})
+		}
+	}
+	return problems
+}
+
+// problems returns the problems of a rule whose device has the given sensors, with field
+// names prefixed by prefix.
+func (r RuleConfig) problems(prefix string, sensors []SensorConfig) []Problem {
+	var problems []Problem
+
+	switch {
+	case r.ID == "":
+		problems = append(problems, Problem{prefix + "id", "is required"})
+	case strings.ContainsAny(r.ID, ".*> \t"):
+		problems = append(problems, Problem{prefix + "id", "must not contain dots, wildcards or spaces"})
+	}
+
+	ids := make([]string, len(sensors))
+	for i, sensor := range sensors {
+		ids[i] = sensor.ID
+	}
+	if r.Condition == "" {
+		problems = append(problems, Problem{prefix + "condition", "is required"})
+	} else if e, err := expr.Parse(r.Condition); err != nil {
+		problems = append(problems, Problem{prefix + "condition", err.Error()})
+	} else {
+		for _, name := range e.Refs() {
+			if _, ok := Inputs(name, ids); !ok {
+				problems = append(problems, Problem{prefix + "condition", fmt.Sprintf("unknown sensor %q", name)})
+			}
+		}
+	}
+
+	if r.Window < 0 {
+		problems = append(problems, Problem{prefix + "window", "must not be negative"})
+	}
+	if len(r.Actions) == 0 {
+		problems = append(problems, Problem{prefix + "actions", "a rule needs at least one action"})
+	}
+	for i, action := range r.Actions {
+		problems = append(problems, action.problems(fmt.Sprintf("%sactions[%d]", prefix, i), sensors)...)
+	}
+	for i, action := range r.OnClear {
+		problems = append(problems, action.problems(fmt.Sprintf("%son_clear[%d]", prefix, i), sensors)...)
+	}
+	return problems
+}
+
+// problems returns the problems of a rule action whose device has the given sensors,
+// reported at field and its subfields.
+func (a RuleAction) problems(field string, sensors []SensorConfig) []Problem {
+	set := 0
+	for _, ok := range []bool{a.Publish != nil, a.Configure != nil, a.SetActuator != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return []Problem{{field, "needs exactly one of publish, configure or set_actuator"}}
+	}
+
+	find := func(id string) (SensorConfig, bool) {
+		i := slices.IndexFunc(sensors, func(sc SensorConfig) bool { return sc.ID == id })
+		if i < 0 {
+			return SensorConfig{}, false
+		}
+		return sensors[i], true
+	}
+
+	var problems []Problem
+	switch {
+	case a.Publish != nil:
+		if strings.ContainsAny(a.Publish.Subject, "*> \t") {
+			problems = append(problems, Problem{field + ".publish.subject", "must not contain wildcards or spaces"})
+		}
+	case a.Configure != nil:
+		prefix := field + ".configure."
+		sensor, ok := find(a.Configure.Sensor)
+		if !ok {
+			return append(problems, Problem{prefix + "sensor", fmt.Sprintf("unknown sensor %q", a.Configure.Sensor)})
+		}
+		if a.Configure.Frequency < 0 {
+			return append(problems, Problem{prefix + "frequency", "must not be negative"})
+		}
+		if a.Configure.Frequency == 0 && a.Configure.Min == nil && a.Configure.Max == nil && a.Configure.Enabled == nil {
+			return append(problems, Problem{field + ".configure", "changes nothing (set frequency, min, max or enabled)"})
+		}
+		// The sensor's own problems are reported with it.
+		if len(sensor.problems("")) == 0 {
+			problems = append(problems, a.Configure.Apply(sensor).problems(prefix)...)
+		}
+	case a.SetActuator != nil:
+		prefix := field + ".set_actuator."
+		sensor, ok := find(a.SetActuator.Sensor)
+		switch {
+		case !ok:
+			problems = append(problems, Problem{prefix + "sensor", fmt.Sprintf("unknown sensor %q", a.SetActuator.Sensor)})
+		case sensor.Discrete == nil:
+			problems = append(problems, Problem{prefix + "sensor", fmt.Sprintf("sensor %q is not discrete", a.SetActuator.Sensor)})
+		case !slices.Contains(sensor.Discrete.StateNames(), a.SetActuator.State):
+			problems = append(problems, Problem{prefix + "state", fmt.Sprintf("sensor %q has no state %q", a.SetActuator.Sensor, a.SetActuator.State)})
+		}
+	}
+	return problems
+}
+
+// problems returns the problems of a replay configuration, with field names prefixed by prefix.
+// A nil configuration lacks the required file.
+func (c *ReplayConfig) problems(prefix string) []Problem {
+	if c == nil || c.File == "" {
+		return []Problem{{prefix + "file", "is required by the replay generator"}}
+	}
+
+	var problems []Problem
+	switch c.Format {
+	case "", "csv", "ndjson":
+	default:
+		problems = append(problems, Problem{prefix + "format", fmt.Sprintf("unknown format %q (want csv or ndjson)", c.Format)})
+	}
+	if c.Speed < 0 {
+		problems = append(problems, Problem{prefix + "speed", "must not be negative"})
+	}
+	return problems
+}
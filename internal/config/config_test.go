@@ -2,7 +2,11 @@
 package config
 
 import (
+	"context"
+	"math"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -11,6 +15,8 @@ import (
 func TestLoad(t *testing.T) {
 	// Create a temporary config file for testing.
 	configContent := `device_id: test-device
+tags:
+  site: lab-1
 nats:
   url: nats://localhost:4222
 
@@ -46,6 +52,10 @@ sensors:
 		t.Errorf("Expected device ID 'test-device', got '%s'", cfg.DeviceID)
 	}
 
+	if cfg.Tags["site"] != "lab-1" {
+		t.Errorf("Expected tag site 'lab-1', got %v", cfg.Tags)
+	}
+
 	if cfg.NATS.URL != "nats://localhost:4222" {
 		t.Errorf("Expected NATS URL 'nats://localhost:4222', got '%s'", cfg.NATS.URL)
 	}
@@ -63,3 +73,588 @@ sensors:
 		t.Errorf("Expected frequency 5s, got %v", sensor.Frequency)
 	}
 }
+
+// TestValidate tests that Validate reports every problem with its field path.
+func TestValidate(t *testing.T) {
+	cfg := &Config{
+		DeviceID: "test-device",
+		Sensors: []SensorConfig{
+			{ID: "temp-01", Type: "temperature", Frequency: time.Second, Min: 10, Max: 20},
+			{ID: "temp-01", Type: "temperature", Frequency: time.Second, Min: 30, Max: 20},
+			{ID: "hum-01", Type: "", Frequency: 0, Min: 0, Max: 100},
+			{ID: "active", Type: "temperature", Frequency: time.Second, Min: 10, Max: 20},
+			{ID: "shelve", Type: "temperature", Frequency: time.Second, Min: 10, Max: 20},
+			{ID: "temp.1", Type: "temperature", Frequency: time.Second, Min: 10, Max: 20},
+			{ID: "temp *", Type: "temperature", Frequency: time.Second, Min: 10, Max: 20},
+		},
+	}
+
+	err := cfg.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+
+	expected := map[string]bool{
+		"sensors[1].id":        true,
+		"sensors[1].min":       true,
+		"sensors[2].type":      true,
+		"sensors[2].frequency": true,
+		"sensors[3].id":        true,
+		"sensors[4].id":        true,
+		"sensors[5].id":        true,
+		"sensors[6].id":        true,
+	}
+	if len(verr.Problems) != len(expected) {
+		t.Errorf("Expected %d problems, got %d: %v", len(expected), len(verr.Problems), verr)
+	}
+	for _, p := range verr.Problems {
+		if !expected[p.Field] {
+			t.Errorf("Unexpected problem %s: %s", p.Field, p.Message)
+		}
+	}
+}
+
+// TestValidateRetention tests the bounds of the MongoDB retention.
+func TestValidateRetention(t *testing.T) {
+	tests := []struct {
+		retention time.Duration
+		valid     bool
+	}{
+		{0, true},
+		{720 * time.Hour, true},
+		{MaxRetention, true},
+		{-time.Hour, false},
+		{time.Millisecond, false},
+		{MaxRetention + time.Second, false},
+	}
+	for _, tt := range tests {
+		cfg := &Config{DeviceID: "test-device", Storage: StorageConfig{MongoDB: MongoDBConfig{Retention: tt.retention}}}
+		err := cfg.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("Retention %v: expected no error, got %v", tt.retention, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("Retention %v: expected an error", tt.retention)
+		}
+	}
+}
+
+// TestValidateReplay tests the validation of the generator and its replay settings.
+func TestValidateReplay(t *testing.T) {
+	base := SensorConfig{ID: "temp-01", Type: "temperature", Frequency: time.Second, Max: 30}
+	tests := []struct {
+		generator string
+		replay    *ReplayConfig
+		field     string
+	}{
+		{"", nil, ""},
+		{GeneratorReplay, &ReplayConfig{File: "temp.csv", Speed: 2, Loop: true}, ""},
+		{"noise", nil, "sensors[0].generator"},
+		{GeneratorReplay, nil, "sensors[0].replay.file"},
+		{GeneratorReplay, &ReplayConfig{File: "temp.xml", Format: "xml"}, "sensors[0].replay.format"},
+		{GeneratorReplay, &ReplayConfig{File: "temp.csv", Speed: -1}, "sensors[0].replay.speed"},
+	}
+	for _, tt := range tests {
+		sc := base
+		sc.Generator, sc.Replay = tt.generator, tt.replay
+		err := (&Config{DeviceID: "test-device", Sensors: []SensorConfig{sc}}).Validate()
+
+		if tt.field == "" {
+			if err != nil {
+				t.Errorf("Generator %q: expected no error, got %v", tt.generator, err)
+			}
+			continue
+		}
+		verr, ok := err.(*ValidationError)
+		if !ok || len(verr.Problems) != 1 || verr.Problems[0].Field != tt.field {
+			t.Errorf("Generator %q: expected a problem with %s, got %v", tt.generator, tt.field, err)
+		}
+	}
+}
+
+// TestValidateValues tests the validation of multi-value sensor fields.
+func TestValidateValues(t *testing.T) {
+	cfg := &Config{
+		DeviceID: "test-device",
+		Sensors: []SensorConfig{{
+			ID: "accel-01", Type: "acceleration", Frequency: time.Second,
+			Values: map[string]ValueConfig{
+				"x":   {Min: -2, Max: 2},
+				"y":   {Min: 2, Max: -2, Model: ModelRandomWalk},
+				"z":   {Min: 0, Max: 1, Model: ModelSine},
+				"a.b": {Min: 0, Max: 1, Model: "brownian", Step: -1},
+			},
+		}},
+	}
+
+	err := cfg.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+
+	expected := []string{
+		"sensors[0].values.a.b",
+		"sensors[0].values.a.b.model",
+		"sensors[0].values.a.b.step",
+		"sensors[0].values.y.min",
+		"sensors[0].values.z.period",
+	}
+	if len(verr.Problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %d: %v", len(expected), len(verr.Problems), verr)
+	}
+	for i, p := range verr.Problems {
+		if p.Field != expected[i] {
+			t.Errorf("Problem %d: expected %s, got %s: %s", i, expected[i], p.Field, p.Message)
+		}
+	}
+}
+
+// TestValidateDiscrete tests the validation of discrete sensor states and models.
+func TestValidateDiscrete(t *testing.T) {
+	base := SensorConfig{ID: "door-01", Type: "door", Frequency: time.Second}
+	dwell := map[string]DwellConfig{"closed": {Min: time.Second, Max: time.Minute}, "open": {Max: 10 * time.Second}}
+	tests := []struct {
+		name     string
+		discrete DiscreteConfig
+		fields   []string
+	}{
+		{"default boolean", DiscreteConfig{Transitions: map[string]map[string]float64{"false": {"true": 0.1}}}, nil},
+		{"dwell", DiscreteConfig{States: []string{"closed", "open"}, Model: ModelDwell, Dwell: dwell, Keepalive: time.Minute}, nil},
+		{"enum", DiscreteConfig{Kind: KindEnum, States: []string{"idle", "running", "fault"}, Initial: "idle",
+			Transitions: map[string]map[string]float64{"idle": {"running": 0.5, "fault": 0.1}}}, nil},
+		{"three booleans", DiscreteConfig{States: []string{"a", "b", "c"}}, []string{"discrete.states"}},
+		{"duplicate state", DiscreteConfig{Kind: KindEnum, States: []string{"a", "b", "a"}}, []string{"discrete.states[2]"}},
+		{"unknown initial", DiscreteConfig{Initial: "maybe"}, []string{"discrete.initial"}},
+		{"bad transitions", DiscreteConfig{Transitions: map[string]map[string]float64{
+			"false": {"true": 1.5}, "true": {"true": 0.1}, "maybe": {"true": 0.1}}},
+			[]string{"discrete.transitions.false.true", "discrete.transitions.false", "discrete.transitions.maybe", "discrete.transitions.true.true"}},
+		{"missing dwell", DiscreteConfig{Model: ModelDwell, Dwell: map[string]DwellConfig{"true": {Min: 2 * time.Second, Max: time.Second}}},
+			[]string{"discrete.dwell.false", "discrete.dwell.true"}},
+		{"unknown kind and model", DiscreteConfig{Kind: "analog", Model: "chaos", Keepalive: -time.Second},
+			[]string{"discrete.kind", "discrete.model", "discrete.keepalive"}},
+	}
+	for _, tt := range tests {
+		sc := base
+		sc.Discrete = &tt.discrete
+		var fields []string
+		if err := sc.Validate(); err != nil {
+			for _, p := range err.(*ValidationError).Problems {
+				fields = append(fields, p.Field)
+			}
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: expected problems %v, got %v", tt.name, tt.fields, fields)
+		}
+	}
+}
+
+// TestValidateCounter tests the validation of counter sensors.
+func TestValidateCounter(t *testing.T) {
+	base := SensorConfig{ID: "meter-01", Type: "energy", Frequency: time.Second}
+	tests := []struct {
+		name    string
+		counter CounterConfig
+		fields  []string
+	}{
+		{"valid", CounterConfig{Rate: ValueConfig{Min: 0, Max: 5}, Width: 32, Initial: 1000, ResetProbability: 0.001, Delta: true}, nil},
+		{"negative rate", CounterConfig{Rate: ValueConfig{Min: -1, Max: 5}}, []string{"counter.rate.min"}},
+		{"bad rate model", CounterConfig{Rate: ValueConfig{Max: 5, Model: ModelSine}}, []string{"counter.rate.period"}},
+		{"too wide", CounterConfig{Rate: ValueConfig{Max: 1}, Width: 65}, []string{"counter.width"}},
+		{"initial past rollover", CounterConfig{Rate: ValueConfig{Max: 1}, Width: 8, Initial: 256}, []string{"counter.initial"}},
+		{"bad reset probability", CounterConfig{Rate: ValueConfig{Max: 1}, ResetProbability: 2}, []string{"counter.reset_probability"}},
+	}
+	for _, tt := range tests {
+		sc := base
+		sc.Counter = &tt.counter
+		var fields []string
+		if err := sc.Validate(); err != nil {
+			for _, p := range err.(*ValidationError).Problems {
+				fields = append(fields, p.Field)
+			}
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: expected problems %v, got %v", tt.name, tt.fields, fields)
+		}
+	}
+
+	if r := (&CounterConfig{Width: 16}).Rollover(); r != 65536 {
+		t.Errorf("Expected a 16-bit counter to roll over at 65536, got %v", r)
+	}
+}
+
+// TestValidateLocation tests the validation of location sensors.
+func TestValidateLocation(t *testing.T) {
+	base := SensorConfig{ID: "gps-01", Type: "location", Frequency: time.Second}
+	tests := []struct {
+		name     string
+		location LocationConfig
+		fields   []string
+	}{
+		{"valid route", LocationConfig{Route: "route.geojson", Speed: 13.9, Loop: true, Noise: 3, HDOP: ValueConfig{Min: 0.8, Max: 2}, FixLoss: 0.01}, nil},
+		{"valid waypoints", LocationConfig{Center: Position{Lat: 52.52, Lon: 13.4}, Radius: 5000, Waypoints: 8, Speed: 10}, nil},
+		{"no route", LocationConfig{Speed: 10}, []string{"location.waypoints", "location.radius"}},
+		{"route and waypoints", LocationConfig{Route: "route.geojson", Waypoints: 3}, []string{"location.waypoints"}},
+		{"bad center", LocationConfig{Center: Position{Lat: 91}, Radius: 100, Waypoints: 2}, []string{"location.center"}},
+		{"negative speed", LocationConfig{Route: "route.geojson", Speed: -1}, []string{"location.speed"}},
+		{"negative hdop", LocationConfig{Route: "route.geojson", HDOP: ValueConfig{Min: -1, Max: 1}}, []string{"location.hdop.min"}},
+		{"bad fix loss", LocationConfig{Route: "route.geojson", FixLoss: 1.5, FixLossDuration: DwellConfig{Min: time.Minute, Max: time.Second}}, []string{"location.fix_loss", "location.fix_loss_duration"}},
+	}
+	for _, tt := range tests {
+		sc := base
+		sc.Location = &tt.location
+		var fields []string
+		if err := sc.Validate(); err != nil {
+			for _, p := range err.(*ValidationError).Problems {
+				fields = append(fields, p.Field)
+			}
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: expected problems %v, got %v", tt.name, tt.fields, fields)
+		}
+	}
+
+	sc := base
+	sc.Location = &LocationConfig{Route: "route.geojson"}
+	sc.Values = map[string]ValueConfig{"x": {Max: 1}}
+	if err := sc.Validate(); err == nil || err.(*ValidationError).Problems[0].Field != "location" {
+		t.Errorf("Expected location combined with values to be rejected, got %v", err)
+	}
+}
+
+// TestValidateVirtual tests the validation of virtual sensor expressions and their inputs.
+func TestValidateVirtual(t *testing.T) {
+	virtual := func(id, expression string) SensorConfig {
+		return SensorConfig{ID: id, Type: "derived", Frequency: time.Second, Virtual: &VirtualConfig{Expression: expression}}
+	}
+	cfg := &Config{
+		DeviceID: "test-device",
+		Sensors: []SensorConfig{
+			{ID: "temp-01", Type: "temperature", Frequency: time.Second, Max: 40},
+			{ID: "humidity-01", Type: "humidity", Frequency: time.Second, Max: 100},
+			{ID: "gps-01", Type: "location", Frequency: time.Second, Location: &LocationConfig{Route: "route.geojson"}},
+			virtual("dewpoint-01", "dewpoint(temp-01, humidity-01)"),
+			virtual("temp-avg", "avg(temp-*) + count(pressure-*)"),
+			virtual("speed-kmh", "gps-01.speed * 3.6"),
+			virtual("bad-syntax", "temp-01 +"),
+			virtual("unknown", "temp-02 - temp-01"),
+			virtual("self", "self + 1"),
+		},
+	}
+
+	err := cfg.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+
+	expected := []string{
+		"sensors[6].virtual.expression",
+		"sensors[8].virtual.expression",
+		"sensors[7].virtual.expression",
+	}
+	if len(verr.Problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %d: %v", len(expected), len(verr.Problems), verr)
+	}
+	for i, p := range verr.Problems {
+		if p.Field != expected[i] {
+			t.Errorf("Problem %d: expected %s, got %s: %s", i, expected[i], p.Field, p.Message)
+		}
+	}
+	if msg := verr.Problems[2].Message; msg != `unknown sensor "temp-02"` {
+		t.Errorf("Unexpected message: %s", msg)
+	}
+}
+
+// TestValidateCorrelationGroups tests the validation of correlation group members and matrices.
+func TestValidateCorrelationGroups(t *testing.T) {
+	sensors := []SensorConfig{
+		{ID: "temp-01", Type: "temperature", Frequency: time.Second, Max: 40},
+		{ID: "humidity-01", Type: "humidity", Frequency: time.Second, Max: 100},
+		{ID: "pressure-01", Type: "pressure", Frequency: time.Second, Min: 950, Max: 1050},
+		{ID: "door-01", Type: "door", Frequency: time.Second, Discrete: &DiscreteConfig{}},
+	}
+	tests := []struct {
+		name   string
+		groups []CorrelationGroup
+		fields []string
+	}{
+		{"valid", []CorrelationGroup{{ID: "weather", Sensors: []string{"temp-01", "humidity-01", "pressure-01"},
+			Matrix: [][]float64{{1, -0.6, 0.3}, {-0.6, 1, -0.2}, {0.3, -0.2, 1}}}}, nil},
+		{"bad members", []CorrelationGroup{{ID: "g", Sensors: []string{"temp-01", "wind-01", "door-01", "temp-01"},
+			Matrix: [][]float64{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}}},
+			[]string{"correlation_groups[0].sensors[1]", "correlation_groups[0].sensors[2]", "correlation_groups[0].sensors[3]"}},
+		{"two groups", []CorrelationGroup{
+			{ID: "a", Sensors: []string{"temp-01", "humidity-01"}, Matrix: [][]float64{{1, 0.5}, {0.5, 1}}},
+			{ID: "b", Sensors: []string{"pressure-01", "temp-01"}, Matrix: [][]float64{{1, 0.5}, {0.5, 1}}},
+		}, []string{"correlation_groups[1].sensors"}},
+		{"matrix size", []CorrelationGroup{{ID: "g", Sensors: []string{"temp-01", "humidity-01"}, Matrix: [][]float64{{1, 0.5}}}},
+			[]string{"correlation_groups[0].matrix"}},
+		{"matrix entries", []CorrelationGroup{{Sensors: []string{"temp-01", "humidity-01"}, Matrix: [][]float64{{0.9, 1.5}, {0.5, 1}}}},
+			[]string{"correlation_groups[0].id", "correlation_groups[0].matrix[0][0]", "correlation_groups[0].matrix[0][1]"}},
+		{"not positive definite", []CorrelationGroup{{ID: "g", Sensors: []string{"temp-01", "humidity-01", "pressure-01"},
+			Matrix: [][]float64{{1, 0.9, -0.9}, {0.9, 1, 0.9}, {-0.9, 0.9, 1}}}}, []string{"correlation_groups[0].matrix"}},
+	}
+	for _, tt := range tests {
+		cfg := &Config{DeviceID: "test-device", Sensors: sensors, CorrelationGroups: tt.groups}
+		var fields []string
+		if err := cfg.Validate(); err != nil {
+			for _, p := range err.(*ValidationError).Problems {
+				fields = append(fields, p.Field)
+			}
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: expected problems %v, got %v", tt.name, tt.fields, fields)
+		}
+	}
+}
+
+// TestValidateAlarms tests the validation of alarm types, fields and settings.
+func TestValidateAlarms(t *testing.T) {
+	temp := SensorConfig{ID: "temp-01", Type: "temperature", Frequency: time.Second, Max: 40}
+	accel := SensorConfig{ID: "accel-01", Type: "accelerometer", Frequency: time.Second,
+		Values: map[string]ValueConfig{"x": {Min: -2, Max: 2}}}
+	meter := SensorConfig{ID: "meter-01", Type: "energy", Frequency: time.Second,
+		Counter: &CounterConfig{Rate: ValueConfig{Max: 1}}}
+	tests := []struct {
+		name   string
+		sensor SensorConfig
+		alarms []AlarmConfig
+		fields []string
+	}{
+		{"valid", temp, []AlarmConfig{
+			{Type: AlarmHigh, Limit: 30, Deadband: 1, DelayOn: time.Minute},
+			{Type: AlarmHighHigh, Limit: 35},
+			{Type: AlarmRateOfChange, Limit: 0.5},
+		}, nil},
+		{"bad settings", temp, []AlarmConfig{
+			{Type: "critical", Limit: 30},
+			{Type: AlarmLow, Limit: math.NaN(), Deadband: -1, DelayOn: -time.Second, DelayOff: -time.Second},
+			{Type: AlarmRateOfChange},
+		}, []string{"sensors[0].alarms[0].type", "sensors[0].alarms[1].limit", "sensors[0].alarms[1].deadband",
+			"sensors[0].alarms[1].delay_on", "sensors[0].alarms[1].delay_off", "sensors[0].alarms[2].limit"}},
+		{"duplicate", temp, []AlarmConfig{{Type: AlarmHigh, Limit: 30}, {Type: AlarmHigh, Limit: 32}},
+			[]string{"sensors[0].alarms[1]"}},
+		{"field of a single value", temp, []AlarmConfig{{Type: AlarmHigh, Field: "x"}},
+			[]string{"sensors[0].alarms[0].field"}},
+		{"value fields", accel, []AlarmConfig{{Type: AlarmHigh, Field: "x", Limit: 1}, {Type: AlarmLow, Field: "y"}, {Type: AlarmHigh}},
+			[]string{"sensors[0].alarms[1].field", "sensors[0].alarms[2].field"}},
+		{"counter delta", meter, []AlarmConfig{{Type: AlarmHigh, Limit: 1000}, {Type: AlarmHigh, Field: "delta", Limit: 5}},
+			[]string{"sensors[0].alarms[1].field"}},
+	}
+	for _, tt := range tests {
+		tt.sensor.Alarms = tt.alarms
+		cfg := &Config{DeviceID: "test-device", Sensors: []SensorConfig{tt.sensor}}
+		var fields []string
+		if err := cfg.Validate(); err != nil {
+			for _, p := range err.(*ValidationError).Problems {
+				fields = append(fields, p.Field)
+			}
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: expected problems %v, got %v", tt.name, tt.fields, fields)
+		}
+	}
+}
+
+// TestValidateRules tests the validation of rule conditions and actions.
+func TestValidateRules(t *testing.T) {
+	sensors := []SensorConfig{
+		{ID: "temp-01", Type: "temperature", Frequency: time.Second, Max: 40},
+		{ID: "fan-01", Type: "fan", Frequency: time.Second, Discrete: &DiscreteConfig{States: []string{"off", "on"}}},
+	}
+	on := func(state string) RuleAction {
+		return RuleAction{SetActuator: &SetActuatorAction{Sensor: "fan-01", State: state}}
+	}
+	fast, low := 100*time.Millisecond, 50.0
+	tests := []struct {
+		name   string
+		rules  []RuleConfig
+		fields []string
+	}{
+		{"valid", []RuleConfig{{ID: "cooling", Condition: "temp-01 > 30", Window: time.Minute,
+			Actions: []RuleAction{on("on"), {Publish: &PublishAction{Message: "fan on"}}},
+			OnClear: []RuleAction{on("off"), {Configure: &ConfigureAction{Sensor: "temp-01", Frequency: fast}}}}}, nil},
+		{"bad rule", []RuleConfig{{ID: "a.b", Condition: "temp-02 > 30", Window: -time.Second}},
+			[]string{"rules[0].id", "rules[0].condition", "rules[0].window", "rules[0].actions"}},
+		{"duplicate", []RuleConfig{{ID: "r", Condition: "temp-01 > 30", Actions: []RuleAction{on("on")}},
+			{ID: "r", Condition: "temp-01 +", Actions: []RuleAction{on("off")}}},
+			[]string{"rules[1].condition", "rules[1].id"}},
+		{"bad actions", []RuleConfig{{ID: "r", Condition: "temp-01 > 30", Actions: []RuleAction{
+			{},
+			{Publish: &PublishAction{Subject: "iot.*.rules"}},
+			{SetActuator: &SetActuatorAction{Sensor: "temp-01", State: "on"}},
+			on("high"),
+			{Configure: &ConfigureAction{Sensor: "fan-01"}},
+			{Configure: &ConfigureAction{Sensor: "temp-01", Min: &low}},
+		}}}, []string{"rules[0].actions[0]", "rules[0].actions[1].publish.subject", "rules[0].actions[2].set_actuator.sensor",
+			"rules[0].actions[3].set_actuator.state", "rules[0].actions[4].configure", "rules[0].actions[5].configure.min"}},
+	}
+	for _, tt := range tests {
+		cfg := &Config{DeviceID: "test-device", Sensors: sensors, Rules: tt.rules}
+		var fields []string
+		if err := cfg.Validate(); err != nil {
+			for _, p := range err.(*ValidationError).Problems {
+				fields = append(fields, p.Field)
+			}
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: expected problems %v, got %v", tt.name, tt.fields, fields)
+		}
+	}
+}
+
+// TestLoadRejectsInvalidConfig tests that Load refuses a configuration that would crash a sensor.
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-config-*.yml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tmpFile.WriteString(`device_id: test-device
+sensors:
+  - id: temp-01
+    type: temperature
+    frequency: 0s
+`)
+	tmpFile.Close()
+
+	if _, err := Load(tmpFile.Name()); err == nil {
+		t.Errorf("Expected an error for a zero frequency")
+	}
+}
+
+// TestDiffSensors tests that added, removed and changed sensors are detected.
+func TestDiffSensors(t *testing.T) {
+	old := []SensorConfig{
+		{ID: "temp-01", Type: "temperature", Frequency: time.Second, Max: 30, Enabled: true},
+		{ID: "temp-02", Type: "temperature", Frequency: time.Second, Max: 30, Enabled: true},
+		{ID: "hum-01", Type: "humidity", Frequency: time.Second, Max: 100, Enabled: true},
+	}
+	new := []SensorConfig{
+		{ID: "temp-01", Type: "temperature", Frequency: time.Second, Max: 30, Enabled: true},
+		{ID: "hum-01", Type: "humidity", Frequency: 2 * time.Second, Max: 100, Enabled: false},
+		{ID: "pres-01", Type: "pressure", Frequency: time.Second, Max: 1030, Enabled: true},
+	}
+
+	diff := DiffSensors(old, new)
+
+	if len(diff.Added) != 1 || diff.Added[0].ID != "pres-01" {
+		t.Errorf("Expected pres-01 to be added, got %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "temp-02" {
+		t.Errorf("Expected temp-02 to be removed, got %v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].SensorID != "hum-01" {
+		t.Fatalf("Expected hum-01 to be changed, got %v", diff.Changed)
+	}
+	if fields := diff.Changed[0].Fields; len(fields) != 2 || fields[0] != "frequency" || fields[1] != "enabled" {
+		t.Errorf("Expected changed fields [frequency enabled], got %v", fields)
+	}
+
+	if !DiffSensors(old, old).Empty() {
+		t.Errorf("Expected no changes when comparing a config with itself")
+	}
+}
+
+// TestWatch tests that Watch reports a valid change and skips an invalid one.
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	write := func(frequency string) {
+		content := "device_id: test-device\nsensors:\n  - id: temp-01\n    type: temperature\n    frequency: " + frequency + "\n"
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+	write("5s")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan *Config, 1)
+	go Watch(ctx, path, 10*time.Millisecond, func(cfg *Config) { changes <- cfg })
+
+	write("0s") // invalid, must be ignored
+	time.Sleep(50 * time.Millisecond)
+	write("2s")
+
+	select {
+	case cfg := <-changes:
+		if cfg.Sensors[0].Frequency != 2*time.Second {
+			t.Errorf("Expected reloaded frequency 2s, got %v", cfg.Sensors[0].Frequency)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for config change")
+	}
+}
+
+// TestInterpolate tests ${VAR} and ${VAR:-default} substitution.
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"HOST": "mongodb", "EMPTY": ""}
+	lookup := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	tests := map[string]string{
+		"uri: mongodb://${HOST}:27017": "uri: mongodb://mongodb:27017",
+		"id: ${DEVICE:-device-001}":    "id: device-001",
+		"id: ${EMPTY:-fallback}":       "id: fallback",
+		"id: ${MISSING}":               "id: ",
+		"literal: $${HOST}":            "literal: ${HOST}",
+	}
+	for input, expected := range tests {
+		if got := string(Interpolate([]byte(input), lookup)); got != expected {
+			t.Errorf("Interpolate(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+// TestLoadPrecedence tests that overrides beat the file, later overrides beat earlier ones,
+// and defaults fill in what is left unset.
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	content := `device_id: ${TEST_IOT_DEVICE:-file-device}
+nats:
+  url: nats://file:4222
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	env := Overrides{DeviceID: "env-device", NATSURL: "nats://env:4222"}
+	flags := Overrides{NATSURL: "nats://flag:4222"}
+
+	cfg, err := Load(path, env, flags)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	if cfg.DeviceID != "env-device" {
+		t.Errorf("Expected device ID from env override, got %s", cfg.DeviceID)
+	}
+	if cfg.NATS.URL != "nats://flag:4222" {
+		t.Errorf("Expected NATS URL from flag override, got %s", cfg.NATS.URL)
+	}
+	if cfg.Storage.MongoDB.URI != DefaultMongoURI || cfg.Storage.MongoDB.Database != DefaultMongoDatabase {
+		t.Errorf("Expected default MongoDB settings, got %+v", cfg.Storage.MongoDB)
+	}
+	if cfg.LogLevel != DefaultLogLevel {
+		t.Errorf("Expected default log level, got %s", cfg.LogLevel)
+	}
+}
+
+// TestEnvOverrides tests that IOT_* variables are mapped to overrides.
+func TestEnvOverrides(t *testing.T) {
+	env := map[string]string{EnvMongoURI: "mongodb://mongodb:27017", EnvLogLevel: "debug"}
+	o := EnvOverrides(func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	})
+
+	if o.MongoURI != "mongodb://mongodb:27017" || o.LogLevel != "debug" || o.DeviceID != "" {
+		t.Errorf("Unexpected overrides: %+v", o)
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Diff describes the differences between two sets of sensor configurations.
+type Diff struct {
+	Added   []SensorConfig `json:"added,omitempty"`
+	Removed []string       `json:"removed,omitempty"`
+	Changed []SensorChange `json:"changed,omitempty"`
+}
+
+// SensorChange describes a sensor whose configuration changed.
+// Fields lists the YAML names of the changed fields and Config holds the new configuration.
+type SensorChange struct {
+	SensorID string       `json:"sensor_id"`
+	Fields   []string     `json:"fields"`
+	Config   SensorConfig `json:"config"`
+}
+
+// Empty reports whether the diff contains no changes.
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffSensors compares the sensor configurations in old and new by sensor ID.
+// Sensors are reported in the order they appear in their respective lists.
+func DiffSensors(old, new []SensorConfig) Diff {
+	var diff Diff
+
+	previous := make(map[string]SensorConfig, len(old))
+	for _, cfg := range old {
+		previous[cfg.ID] = cfg
+	}
+
+	next := make(map[string]bool, len(new))
+	for _, cfg := range new {
+		next[cfg.ID] = true
+
+		prev, ok := previous[cfg.ID]
+		if !ok {
+			diff.Added = append(diff.Added, cfg)
+			continue
+		}
+		if fields := changedFields(prev, cfg); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, SensorChange{SensorID: cfg.ID, Fields: fields, Config: cfg})
+		}
+	}
+
+	for _, cfg := range old {
+		if !next[cfg.ID] {
+			diff.Removed = append(diff.Removed, cfg.ID)
+		}
+	}
+
+	return diff
+}
+
+// changedFields returns the YAML names of the fields that differ between a and b.
+func changedFields(a, b SensorConfig) []string {
+	var fields []string
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		fields = append(fields, name)
+	}
+
+	return fields
+}
@@ -0,0 +1,104 @@
+package config
+
+import "regexp"
+
+// Environment variables read by EnvOverrides.
+const (
+	EnvDeviceID      = "IOT_DEVICE_ID"
+	EnvNATSURL       = "IOT_NATS_URL"
+	EnvStorage       = "IOT_STORAGE_BACKEND"
+	EnvMongoURI      = "IOT_MONGO_URI"
+	EnvMongoDatabase = "IOT_MONGO_DATABASE"
+	EnvSQLitePath    = "IOT_SQLITE_PATH"
+	EnvLogLevel      = "IOT_LOG_LEVEL"
+)
+
+// Overrides holds configuration values that take precedence over the YAML file.
+// Empty fields leave the loaded value untouched.
+type Overrides struct {
+	DeviceID      string
+	NATSURL       string
+	Storage       string
+	MongoURI      string
+	MongoDatabase string
+	SQLitePath    string
+	LogLevel      string
+}
+
+// EnvOverrides builds Overrides from the IOT_* environment variables using lookup
+// (typically os.LookupEnv).
+func EnvOverrides(lookup func(string) (string, bool)) Overrides {
+	get := func(key string) string {
+		value, _ := lookup(key)
+		return value
+	}
+
+	return Overrides{
+		DeviceID:      get(EnvDeviceID),
+		NATSURL:       get(EnvNATSURL),
+		Storage:       get(EnvStorage),
+		MongoURI:      get(EnvMongoURI),
+		MongoDatabase: get(EnvMongoDatabase),
+		SQLitePath:    get(EnvSQLitePath),
+		LogLevel:      get(EnvLogLevel),
+	}
+}
+
+// Apply sets every non-empty override on c.
+func (o Overrides) Apply(c *Config) {
+	set := func(dst *string, value string) {
+		if value != "" {
+			*dst = value
+		}
+	}
+
+	set(&c.DeviceID, o.DeviceID)
+	set(&c.NATS.URL, o.NATSURL)
+	set(&c.Storage.Backend, o.Storage)
+	set(&c.Storage.MongoDB.URI, o.MongoURI)
+	set(&c.Storage.MongoDB.Database, o.MongoDatabase)
+	set(&c.Storage.SQLite.Path, o.SQLitePath)
+	set(&c.LogLevel, o.LogLevel)
+}
+
+// applyDefaults fills in the settings the YAML file left empty.
+func (c *Config) applyDefaults() {
+	if c.Storage.Backend == "" {
+		c.Storage.Backend = DefaultStorage
+	}
+	if c.Storage.MongoDB.URI == "" {
+		c.Storage.MongoDB.URI = DefaultMongoURI
+	}
+	if c.Storage.MongoDB.Database == "" {
+		c.Storage.MongoDB.Database = DefaultMongoDatabase
+	}
+	if c.Storage.SQLite.Path == "" {
+		c.Storage.SQLite.Path = DefaultSQLitePath
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = DefaultLogLevel
+	}
+}
+
+// interpolation matches $${...} escapes and ${VAR} or ${VAR:-default} references.
+var interpolation = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Interpolate replaces ${VAR} references in data with the value returned by lookup.
+// ${VAR:-default} uses default when VAR is unset or empty, an unset VAR without a default
+// becomes an empty string, and $${ produces a literal ${.
+func Interpolate(data []byte, lookup func(string) (string, bool)) []byte {
+	return interpolation.ReplaceAllFunc(data, func(match []byte) []byte {
+		if string(match) == "$${" {
+			return []byte("${")
+		}
+
+		groups := interpolation.FindSubmatch(match)
+		if value, ok := lookup(string(groups[1])); ok && value != "" {
+			return []byte(value)
+		}
+		if groups[2] != nil {
+			return groups[3]
+		}
+		return nil
+	})
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Watch polls filename every interval and calls onChange with the newly loaded
+// configuration whenever the file content changes. The overrides are applied as in Load.
+// Files that cannot be read or fail validation are logged and skipped, keeping the
+// running configuration. Watch blocks until ctx is canceled.
+func Watch(ctx context.Context, filename string, interval time.Duration, onChange func(*Config), overrides ...Overrides) {
+	last, _ := fileHash(filename)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hash, err := fileHash(filename)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error reading config file %s: %v", filename, err))
+				continue
+			}
+			if bytes.Equal(hash, last) {
+				continue
+			}
+			last = hash
+
+			cfg, err := Load(filename, overrides...)
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Ignoring changed config file %s: %v", filename, err))
+				continue
+			}
+			onChange(cfg)
+		}
+	}
+}
+
+// fileHash returns the SHA-256 digest of the file content.
+func fileHash(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
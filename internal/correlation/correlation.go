@@ -0,0 +1,103 @@
+// Package correlation draws correlated random variables for groups of sensors that share
+// latent factors. A correlation matrix is factored with the Cholesky decomposition to
+// turn independent standard normal draws into correlated ones, which a Gaussian copula
+// then maps onto the uniform ranges of the sensors.
+package correlation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Cholesky returns the lower triangular L with L·Lᵀ = m. m must be square, symmetric
+// and positive definite.
+func Cholesky(m [][]float64) ([][]float64, error) {
+	n := len(m)
+	l := make([][]float64, n)
+	for i := range m {
+		if len(m[i]) != n {
+			return nil, fmt.Errorf("row %d has %d entries, want %d", i, len(m[i]), n)
+		}
+		l[i] = make([]float64, n)
+	}
+
+	for i := range n {
+		for j := 0; j <= i; j++ {
+			if m[i][j] != m[j][i] {
+				return nil, fmt.Errorf("not symmetric at [%d][%d]", i, j)
+			}
+			s := m[i][j]
+			for k := range j {
+				s -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				if s <= 0 {
+					return nil, errors.New("not positive definite")
+				}
+				l[i][i] = math.Sqrt(s)
+			} else {
+				l[i][j] = s / l[j][j]
+			}
+		}
+	}
+	return l, nil
+}
+
+// Uniform maps a standard normal value onto (0, 1) with the normal cumulative
+// distribution function. Applied to correlated normals, it yields uniform values
+// with the dependence of a Gaussian copula.
+func Uniform(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+// Group draws correlated standard normal values for the members of a correlation group.
+// Members share a draw until one of them asks for a value again, so members reading at
+// the same frequency see the components of the same draw. It is safe for concurrent use.
+type Group struct {
+	mu   sync.Mutex
+	l    [][]float64
+	norm func() float64 // standard normal
+
+	draw []float64
+	used []bool
+}
+
+// NewGroup returns a group whose members are correlated following the correlation matrix m.
+// norm returns independent standard normal values.
+func NewGroup(m [][]float64, norm func() float64) (*Group, error) {
+	l, err := Cholesky(m)
+	if err != nil {
+		return nil, err
+	}
+	used := make([]bool, len(m))
+	for i := range used {
+		used[i] = true // the first request draws
+	}
+	return &Group{l: l, norm: norm, draw: make([]float64, len(m)), used: used}, nil
+}
+
+// Next returns the value of member i from the current draw, first making a new draw if
+// member i already used the current one.
+func (g *Group) Next(i int) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.used[i] {
+		z := make([]float64, len(g.l))
+		for j := range z {
+			z[j] = g.norm()
+		}
+		for j, row := range g.l {
+			x := 0.0
+			for k := 0; k <= j; k++ {
+				x += row[k] * z[k]
+			}
+			g.draw[j] = x
+			g.used[j] = false
+		}
+	}
+	g.used[i] = true
+	return g.draw[i]
+}
@@ -0,0 +1,106 @@
+package correlation
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// TestCholesky tests the factorization of valid and invalid matrices.
+func TestCholesky(t *testing.T) {
+	m := [][]float64{
+		{4, 12, -16},
+		{12, 37, -43},
+		{-16, -43, 98},
+	}
+	l, err := Cholesky(m)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := [][]float64{{2, 0, 0}, {6, 1, 0}, {-8, 5, 3}}
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(l[i][j]-want[i][j]) > 1e-12 {
+				t.Errorf("L[%d][%d]: expected %v, got %v", i, j, want[i][j], l[i][j])
+			}
+		}
+	}
+
+	for name, m := range map[string][][]float64{
+		"not square":            {{1, 0}, {0}},
+		"not symmetric":         {{1, 0.5}, {0.2, 1}},
+		"not positive definite": {{1, 0.9, -0.9}, {0.9, 1, 0.9}, {-0.9, 0.9, 1}},
+	} {
+		if _, err := Cholesky(m); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// TestGroupCorrelation tests that draws shared by the members follow the correlation matrix.
+func TestGroupCorrelation(t *testing.T) {
+	m := [][]float64{
+		{1, 0.8, -0.5},
+		{0.8, 1, -0.3},
+		{-0.5, -0.3, 1},
+	}
+	rnd := rand.New(rand.NewPCG(1, 2))
+	g, err := NewGroup(m, rnd.NormFloat64)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	const n = 20000
+	var sum [3]float64
+	var prod [3][3]float64
+	for range n {
+		var x [3]float64
+		for i := range x {
+			x[i] = g.Next(i)
+		}
+		for i := range x {
+			sum[i] += x[i]
+			for j := range x {
+				prod[i][j] += x[i] * x[j]
+			}
+		}
+	}
+
+	for i := range m {
+		for j := range m {
+			cov := func(a, b int) float64 { return prod[a][b]/n - sum[a]/n*sum[b]/n }
+			r := cov(i, j) / math.Sqrt(cov(i, i)*cov(j, j))
+			if math.Abs(r-m[i][j]) > 0.03 {
+				t.Errorf("Correlation [%d][%d]: expected %v, got %v", i, j, m[i][j], r)
+			}
+		}
+	}
+}
+
+// TestGroupSharesDraws tests that members see one draw until one of them asks again.
+func TestGroupSharesDraws(t *testing.T) {
+	values := []float64{1, 2, 3, 4}
+	g, err := NewGroup([][]float64{{1, 0}, {0, 1}}, func() float64 {
+		v := values[0]
+		values = values[1:]
+		return v
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if a, b := g.Next(0), g.Next(1); a != 1 || b != 2 {
+		t.Errorf("Expected the first draw 1, 2, got %v, %v", a, b)
+	}
+	if a, b := g.Next(1), g.Next(0); a != 4 || b != 3 {
+		t.Errorf("Expected the second draw 3, 4, got %v, %v", b, a)
+	}
+}
+
+// TestUniform tests the normal cumulative distribution function.
+func TestUniform(t *testing.T) {
+	for x, want := range map[float64]float64{0: 0.5, 1.959963984540054: 0.975, -1: 0.15865525393145707} {
+		if u := Uniform(x); math.Abs(u-want) > 1e-12 {
+			t.Errorf("Uniform(%v): expected %v, got %v", x, want, u)
+		}
+	}
+}
@@ -5,315 +5,931 @@ package device
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
+	"iot-device-simulator/internal/alarm"
+	"iot-device-simulator/internal/api"
 	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/correlation"
+	"iot-device-simulator/internal/rules"
 	"iot-device-simulator/internal/sensor"
 	"iot-device-simulator/internal/storage"
+	"iot-device-simulator/internal/telemetry"
 )
 
+var tracer = otel.Tracer("iot-device-simulator/internal/device")
+
 // Device represents a simulated IoT device.
 // It holds the device's configuration, sensors, and connections to external services.
 // It is the central component for managing the device's state and behavior.
 type Device struct {
 	id      string
-	sensors []*sensor.Sensor
 	nc      *nats.Conn
-	storage *storage.MongoDB
+	storage storage.Storage
+
+	// ctx is the device lifetime context; each running sensor gets a child context.
+	ctx     context.Context
+	mu      sync.RWMutex
+	sensors []*sensor.Sensor
+	cancels map[string]context.CancelFunc
+	tags    map[string]string
+
+	// latest holds the latest reading of every sensor, the inputs of virtual sensors.
+	latest *sensor.Latest
+	// groups are the correlation groups the sensors are assigned to.
+	groups []config.CorrelationGroup
+
+	// fileSensors are the sensors of the last loaded config file. Reloads apply what
+	// changed since, keeping registered sensors and runtime updates of the others.
+	fileSensors []config.SensorConfig
+
+	// rules are the edge rules of the device; stopRules stops them once they run.
+	rules     []config.RuleConfig
+	stopRules context.CancelFunc
 }
 
 // NewDevice creates and initializes a new Device based on the provided configuration.
-// It sets up the device's sensors and establishes connections to NATS and MongoDB.
-func NewDevice(cfg *config.Config, nc *nats.Conn, store *storage.MongoDB) *Device {
+// It sets up the device's sensors on top of the given NATS connection and storage backend.
+// store may be nil, in which case nothing is persisted.
+func NewDevice(cfg *config.Config, nc *nats.Conn, store storage.Storage) *Device {
 	device := &Device{
 		id:      cfg.DeviceID,
 		nc:      nc,
 		storage: store,
+		ctx:     context.Background(),
+		cancels: make(map[string]context.CancelFunc),
+		tags:    maps.Clone(cfg.Tags),
+		latest:  sensor.NewLatest(),
+		rules:   cfg.Rules,
+
+		fileSensors: cfg.Sensors,
 	}
 
 	// Create sensors from configuration
 	for _, sensorConfig := range cfg.Sensors {
-		s := sensor.New(sensorConfig, nc, store)
-		device.sensors = append(device.sensors, s)
+		device.sensors = append(device.sensors, device.newSensor(sensorConfig))
 	}
+	device.correlate(cfg.CorrelationGroups)
 
 	return device
 }
 
+// newSensor creates a sensor of this device that tags its readings with the device labels.
+// The caller must hold d.mu, or have exclusive access to d.
+func (d *Device) newSensor(sensorConfig config.SensorConfig) *sensor.Sensor {
+	s := sensor.New(sensorConfig, d.nc, d.readingStore())
+	s.SetTags(d.tags)
+	s.SetLatest(d.latest)
+	return s
+}
+
+// correlate assigns the members of groups to a new correlation group each and makes
+// the other sensors independent. The caller must hold d.mu, or have exclusive access to d.
+func (d *Device) correlate(groups []config.CorrelationGroup) {
+	d.groups = groups
+	for _, s := range d.sensors {
+		s.SetCorrelation(nil, 0)
+	}
+	for _, gc := range groups {
+		group, err := correlation.NewGroup(gc.Matrix, rand.NormFloat64)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Ignoring correlation group %s: %v", gc.ID, err))
+			continue
+		}
+		for i, id := range gc.Sensors {
+			if s := d.findSensor(id); s != nil {
+				s.SetCorrelation(group, i)
+			}
+		}
+	}
+}
+
 // StartDevice begins the device's operation.
 // It sets up NATS subscriptions and starts all enabled sensors in separate goroutines.
 func (d *Device) StartDevice(ctx context.Context) {
-	// Set up NATS subscriptions
-	d.setupSubscriptions()
+	d.mu.Lock()
+	d.ctx = ctx
 
 	// Start sensors
 	enabledCount := 0
 	for _, s := range d.sensors {
-		go s.StartSensor(ctx, d.id)
 		if s.GetConfig().Enabled {
+			d.startSensor(s)
 			enabledCount++
 		}
 	}
+	d.startRules()
+	d.mu.Unlock()
+
+	// Set up NATS subscriptions
+	d.setupSubscriptions()
 
 	log.Printf("Device %s started with %d sensors (%d enabled, %d disabled)", d.id, len(d.sensors), enabledCount, len(d.sensors)-enabledCount)
 }
 
-// setupSubscriptions configures the NATS subscriptions for all device endpoints.
+// startSensor runs s in its own goroutine unless it is already running.
+// The caller must hold d.mu.
+func (d *Device) startSensor(s *sensor.Sensor) {
+	id := s.GetConfig().ID
+	if _, running := d.cancels[id]; running {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(d.ctx)
+	d.cancels[id] = cancel
+	go s.StartSensor(ctx, d.id)
+}
+
+// stopSensor stops the goroutine of the sensor with the given ID, if it is running.
+// The caller must hold d.mu.
+func (d *Device) stopSensor(id string) {
+	if cancel, running := d.cancels[id]; running {
+		cancel()
+		delete(d.cancels, id)
+	}
+}
+
+// startRules runs the rules of the device until they are stopped or the device stops.
+// The caller must hold d.mu.
+func (d *Device) startRules() {
+	ctx, cancel := context.WithCancel(d.ctx)
+	d.stopRules = cancel
+	rules.New(d.id, d.rules, d.nc, d.latest, d).Start(ctx)
+}
+
+// restartsSensor reports whether a change of the named configuration field only takes
+// effect when the sensor restarts, because it selects how the sensor produces readings.
+func restartsSensor(field string) bool {
+	switch field {
+	case "generator", "replay", "discrete", "location", "virtual", "counter":
+		return true
+	}
+	return false
+}
+
+// Reload applies cfg to the running device. The sensors are diffed against the previously
+// loaded file, not the running ones: sensors added to it are started, removed sensors are
+// stopped, and changed sensors take their new settings from it. Sensors registered at
+// runtime and runtime updates of unchanged sensors are kept.
+// The applied diff is logged, persisted and published on iot.{device}.config.reloaded.
+func (d *Device) Reload(cfg *config.Config) config.Diff {
+	if cfg.DeviceID != d.id {
+		slog.Warn(fmt.Sprintf("Ignoring device_id change from %s to %s on reload; restart to apply it", d.id, cfg.DeviceID))
+	}
+
+	d.mu.Lock()
+	if !maps.Equal(cfg.Tags, d.tags) {
+		d.tags = maps.Clone(cfg.Tags)
+		for _, s := range d.sensors {
+			s.SetTags(d.tags)
+		}
+		log.Printf("Reload: updated device tags")
+	}
+
+	diff := config.DiffSensors(d.fileSensors, cfg.Sensors)
+	d.fileSensors = cfg.Sensors
+
+	for _, id := range diff.Removed {
+		d.stopSensor(id)
+		for i, s := range d.sensors {
+			if s.GetConfig().ID == id {
+				d.sensors = append(d.sensors[:i], d.sensors[i+1:]...)
+				break
+			}
+		}
+		d.latest.Forget(id)
+		log.Printf("Reload: removed sensor %s", id)
+	}
+
+	for _, sensorConfig := range diff.Added {
+		d.reloadSensor(sensorConfig)
+		log.Printf("Reload: added sensor %s", sensorConfig.ID)
+	}
+
+	for _, change := range diff.Changed {
+		d.reloadSensor(change.Config)
+		log.Printf("Reload: updated sensor %s (%s)", change.SensorID, strings.Join(change.Fields, ", "))
+	}
+
+	// Added sensors may join a group, so groups are rebuilt on every reload.
+	if !reflect.DeepEqual(cfg.CorrelationGroups, d.groups) {
+		log.Printf("Reload: updated correlation groups")
+	}
+	d.correlate(cfg.CorrelationGroups)
+
+	// Restarted rules are armed again, even those whose condition still holds.
+	if !reflect.DeepEqual(cfg.Rules, d.rules) {
+		d.rules = cfg.Rules
+		if d.stopRules != nil {
+			d.stopRules()
+			d.startRules()
+		}
+		log.Printf("Reload: updated rules")
+	}
+	d.mu.Unlock()
+
+	if diff.Empty() {
+		log.Printf("Reload: no sensor changes")
+		return diff
+	}
+
+	ctx, span := tracer.Start(context.Background(), "device.config.reload",
+		trace.WithAttributes(attribute.String("device.id", d.id)))
+	defer span.End()
+
+	d.saveConfigs(ctx)
+
+	data, _ := json.Marshal(api.ConfigReloadedEvent{
+		DeviceID:  d.id,
+		Diff:      diff,
+		Timestamp: time.Now(),
+	})
+	msg := &nats.Msg{Subject: fmt.Sprintf("iot.%s.config.reloaded", d.id), Data: data}
+	telemetry.Inject(ctx, msg)
+	if err := d.nc.PublishMsg(msg); err != nil {
+		slog.Error(fmt.Sprintf("Error publishing config.reloaded event: %v", err))
+	}
+
+	return diff
+}
+
+// reloadSensor applies the file configuration cfg to the sensor with its ID, replacing
+// any runtime change, or creates the sensor if there is none. The caller must hold d.mu.
+func (d *Device) reloadSensor(cfg config.SensorConfig) {
+	s := d.findSensor(cfg.ID)
+	if s == nil {
+		s = d.newSensor(cfg)
+		d.sensors = append(d.sensors, s)
+	} else {
+		// The running configuration may differ from the file's previous one.
+		running := []config.SensorConfig{s.GetConfig()}
+		s.ApplyConfig(cfg)
+		for _, change := range config.DiffSensors(running, []config.SensorConfig{cfg}).Changed {
+			if slices.ContainsFunc(change.Fields, restartsSensor) {
+				// The value source is chosen when the sensor starts.
+				d.stopSensor(cfg.ID)
+			}
+		}
+	}
+
+	if cfg.Enabled {
+		d.startSensor(s)
+	} else {
+		d.stopSensor(cfg.ID)
+	}
+}
+
+// setupSubscriptions subscribes the handler of every request subject of api.RequestSubjects.
 func (d *Device) setupSubscriptions() {
-	// Get sensor configuration
-	d.nc.Subscribe(fmt.Sprintf("iot.%s.config", d.id), d.handleConfig)
+	handlers := d.handlers()
+	for _, subject := range api.RequestSubjects {
+		d.nc.Subscribe(fmt.Sprintf("iot.%s.%s", d.id, subject.Name), handlers[subject.Name])
+	}
+}
 
-	// Get device status
-	d.nc.Subscribe(fmt.Sprintf("iot.%s.status", d.id), d.handleStatus)
+// handlers returns the handlers of the device's requests, keyed by api.RequestSubjects name.
+func (d *Device) handlers() map[string]nats.MsgHandler {
+	return map[string]nats.MsgHandler{
+		"config":             d.handleConfig,
+		"status":             d.handleStatus,
+		"config.update":      d.handleConfigUpdate,
+		"sensor.register":    d.handleSensorRegister,
+		"readings.latest":    d.handleLatestReadings,
+		"readings.query":     d.handleReadingsQuery,
+		"readings.aggregate": d.handleReadingsAggregate,
+		"alarms.active":      d.handleAlarmsActive,
+		"alarms.list":        d.handleAlarmsList,
+		"alarms.ack":         d.handleAlarmsAck,
+		"alarms.shelve":      d.handleAlarmsShelve,
+		"alarms.unshelve":    d.handleAlarmsUnshelve,
+		"api.schemas":        d.handleSchemas,
+	}
+}
+
+// startSpan extracts the caller's trace context from msg and opens a server span for a handler.
+func (d *Device) startSpan(msg *nats.Msg, name string) (context.Context, trace.Span) {
+	ctx := telemetry.Extract(context.Background(), msg)
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("device.id", d.id),
+			attribute.String("messaging.destination.name", msg.Subject),
+		))
+}
 
-	// Update sensor configuration
-	d.nc.Subscribe(fmt.Sprintf("iot.%s.config.update", d.id), d.handleConfigUpdate)
+// respond replies to msg with resp, carrying the handler's trace context in the reply headers.
+// Failed responses also mark the handler's span as errored.
+func respond(ctx context.Context, msg *nats.Msg, resp api.Response) {
+	if resp.Error != nil {
+		trace.SpanFromContext(ctx).SetStatus(codes.Error, resp.Error.Error())
+	}
 
-	// Register a new sensor
-	d.nc.Subscribe(fmt.Sprintf("iot.%s.sensor.register", d.id), d.handleSensorRegister)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error encoding response for %s: %v", msg.Subject, err))
+		data, _ = json.Marshal(api.Fail(api.NewError(api.CodeInternal, "failed to encode response")))
+	}
 
-	// Get the latest readings for a sensor
-	d.nc.Subscribe(fmt.Sprintf("iot.%s.readings.latest", d.id), d.handleLatestReadings)
+	reply := &nats.Msg{Data: data}
+	telemetry.Inject(ctx, reply)
+	msg.RespondMsg(reply)
 }
 
-// handleConfig responds with the current configuration of all sensors.
-func (d *Device) handleConfig(msg *nats.Msg) {
-	configs := make(map[string]interface{})
+// findSensor returns the sensor with the given ID, or nil if there is none.
+// The caller must hold d.mu.
+func (d *Device) findSensor(sensorID string) *sensor.Sensor {
 	for _, s := range d.sensors {
-		configs[s.GetConfig().ID] = s.GetConfig()
+		if s.GetConfig().ID == sensorID {
+			return s
+		}
+	}
+	return nil
+}
+
+// sensorConfigs returns the configuration of every sensor, keyed by sensor ID.
+func (d *Device) sensorConfigs() api.ConfigResponse {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	configs := make(api.ConfigResponse, len(d.sensors))
+	for _, s := range d.sensors {
+		cfg := s.GetConfig()
+		configs[cfg.ID] = cfg
+	}
+	return configs
+}
+
+// readingStore returns the storage handed to sensors, keeping a nil backend a nil interface.
+func (d *Device) readingStore() sensor.Storage {
+	if d.storage == nil {
+		return nil
+	}
+	return d.storage
+}
+
+// saveConfigs persists the configuration of every sensor if storage is available.
+func (d *Device) saveConfigs(ctx context.Context) {
+	if d.storage == nil {
+		return
 	}
 
-	data, _ := json.Marshal(configs)
-	msg.Respond(data)
+	d.storage.SaveConfig(ctx, d.id, d.sensorConfigs())
+}
+
+// handleConfig responds with the current configuration of all sensors.
+func (d *Device) handleConfig(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.config")
+	defer span.End()
+
+	respond(ctx, msg, api.OK(d.sensorConfigs()))
 }
 
 // handleStatus responds with the current operational status of the device.
 func (d *Device) handleStatus(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.status")
+	defer span.End()
+
+	d.mu.RLock()
+	total := len(d.sensors)
 	enabledCount := 0
 	for _, s := range d.sensors {
 		if s.GetConfig().Enabled {
 			enabledCount++
 		}
 	}
+	d.mu.RUnlock()
 
-	status := map[string]interface{}{
-		"device_id":        d.id,
-		"total_sensors":    len(d.sensors),
-		"enabled_sensors":  enabledCount,
-		"disabled_sensors": len(d.sensors) - enabledCount,
-		"timestamp":        time.Now(),
+	status := api.StatusResponse{
+		DeviceID:        d.id,
+		TotalSensors:    total,
+		EnabledSensors:  enabledCount,
+		DisabledSensors: total - enabledCount,
+		Timestamp:       time.Now(),
+	}
+	if stats, ok := storage.FindBatchStats(d.storage); ok {
+		status.WriteQueue = &stats
 	}
 
-	data, _ := json.Marshal(status)
-	msg.Respond(data)
+	respond(ctx, msg, api.OK(status))
 }
 
 // handleConfigUpdate processes requests to update a sensor's configuration.
 func (d *Device) handleConfigUpdate(msg *nats.Msg) {
-	var updateRequest map[string]interface{}
-	if err := json.Unmarshal(msg.Data, &updateRequest); err != nil {
-		msg.Respond([]byte(`{"error": "invalid JSON"}`))
+	ctx, span := d.startSpan(msg, "device.config.update")
+	defer span.End()
+
+	var req api.ConfigUpdateRequest
+	if err := api.Decode(msg.Data, &req); err != nil {
+		respond(ctx, msg, api.Fail(err))
 		return
 	}
 
-	sensorID, ok := updateRequest["sensor_id"].(string)
-	if !ok {
-		msg.Respond([]byte(`{"error": "sensor_id is required"}`))
+	// Find, validate and update the target sensor under the lock, so a concurrent
+	// reload cannot remove or replace it in between.
+	d.mu.Lock()
+	targetSensor := d.findSensor(req.SensorID)
+	if targetSensor == nil {
+		d.mu.Unlock()
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeNotFound, "sensor %s not found", req.SensorID)))
 		return
 	}
 
-	// Find the target sensor
-	var targetSensor *sensor.Sensor
-	for _, s := range d.sensors {
-		if s.GetConfig().ID == sensorID {
-			targetSensor = s
-			break
-		}
+	// Validate the resulting configuration before applying any change
+	candidate := targetSensor.GetConfig()
+	frequency := req.FrequencyDuration()
+	if frequency > 0 {
+		candidate.Frequency = frequency
 	}
-
-	if targetSensor == nil {
-		msg.Respond([]byte(`{"error": "sensor not found"}`))
+	thresholdUpdates := make(map[string]interface{})
+	min, max := req.Bounds()
+	if min != nil {
+		candidate.Min = *min
+		thresholdUpdates["min"] = *min
+	}
+	if max != nil {
+		candidate.Max = *max
+		thresholdUpdates["max"] = *max
+	}
+	if err := candidate.Validate(); err != nil {
+		d.mu.Unlock()
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeInvalidArgument, "%v", err)))
 		return
 	}
 
 	// Update frequency if provided
-	if frequency, ok := updateRequest["frequency"]; ok {
-		if freqStr, ok := frequency.(string); ok {
-			if duration, err := time.ParseDuration(freqStr); err == nil {
-				targetSensor.UpdateFrequency(duration)
-			}
-		}
+	if frequency > 0 {
+		targetSensor.UpdateFrequency(frequency)
 	}
 
 	// Update thresholds if provided
-	thresholdUpdates := make(map[string]interface{})
-	if min, ok := updateRequest["min"]; ok {
-		thresholdUpdates["min"] = min
-	}
-	if max, ok := updateRequest["max"]; ok {
-		thresholdUpdates["max"] = max
-	}
 	if len(thresholdUpdates) > 0 {
 		targetSensor.UpdateThresholds(thresholdUpdates)
 	}
+	d.mu.Unlock()
 
-	// Also handle thresholds object for backward compatibility
-	if thresholds, ok := updateRequest["thresholds"]; ok {
-		if threshMap, ok := thresholds.(map[string]interface{}); ok {
-			targetSensor.UpdateThresholds(threshMap)
-		}
+	// Save updated configuration if storage is available
+	d.saveConfigs(ctx)
+
+	respond(ctx, msg, api.OK(api.ConfigUpdateResponse{
+		Status:   "updated",
+		SensorID: req.SensorID,
+		Config:   targetSensor.GetConfig(),
+	}))
+}
+
+// handleSensorRegister processes requests to register a new sensor with the device.
+func (d *Device) handleSensorRegister(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.sensor.register")
+	defer span.End()
+
+	var req api.SensorRegisterRequest
+	if err := api.Decode(msg.Data, &req); err != nil {
+		respond(ctx, msg, api.Fail(err))
+		return
 	}
 
-	// Save updated configuration to MongoDB if storage is available
-	if d.storage != nil {
-		configs := make(map[string]interface{})
-		for _, s := range d.sensors {
-			configs[s.GetConfig().ID] = s.GetConfig()
-		}
-		d.storage.SaveConfig(d.id, configs)
+	// Create a new sensor configuration from the request
+	sensorConfig := req.SensorConfig()
+	if err := sensorConfig.Validate(); err != nil {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeInvalidArgument, "%v", err)))
+		return
+	}
+
+	d.mu.Lock()
+	// Check if sensor already exists
+	if d.findSensor(req.SensorID) != nil {
+		d.mu.Unlock()
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeAlreadyExists, "sensor %s already exists", req.SensorID)))
+		return
+	}
+
+	// Create and add the new sensor
+	newSensor := d.newSensor(sensorConfig)
+	d.sensors = append(d.sensors, newSensor)
+
+	// Start the new sensor immediately in a new goroutine
+	if sensorConfig.Enabled {
+		d.startSensor(newSensor)
+		log.Printf("Started new sensor %s with frequency %v", sensorConfig.ID, sensorConfig.Frequency)
 	}
+	d.mu.Unlock()
 
-	msg.Respond([]byte(`{"status": "updated"}`))
+	// Save the updated device configuration
+	d.saveConfigs(ctx)
+
+	respond(ctx, msg, api.OK(api.SensorRegisterResponse{
+		Status:   "registered",
+		SensorID: sensorConfig.ID,
+		Config:   sensorConfig,
+	}))
 }
 
-// handleSensorRegister processes requests to register a new sensor with the device.
-func (d *Device) handleSensorRegister(msg *nats.Msg) {
-	var registerRequest map[string]interface{}
-	if err := json.Unmarshal(msg.Data, &registerRequest); err != nil {
-		msg.Respond([]byte(`{"error": "invalid JSON"}`))
+// handleLatestReadings responds with the most recent reading for a given sensor.
+func (d *Device) handleLatestReadings(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.readings.latest")
+	defer span.End()
+
+	var req api.LatestReadingsRequest
+	if err := api.Decode(msg.Data, &req); err != nil {
+		respond(ctx, msg, api.Fail(err))
 		return
 	}
 
-	sensorID, ok := registerRequest["sensor_id"].(string)
-	if !ok {
-		msg.Respond([]byte(`{"error": "sensor_id is required"}`))
+	d.mu.RLock()
+	targetSensor := d.findSensor(req.SensorID)
+	d.mu.RUnlock()
+	if targetSensor == nil {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeNotFound, "sensor %s not found", req.SensorID)))
 		return
 	}
 
-	sensorType, ok := registerRequest["type"].(string)
-	if !ok {
-		msg.Respond([]byte(`{"error": "type is required"}`))
+	// Get latest readings if storage is available
+	if d.storage == nil {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeUnavailable, "storage not available")))
 		return
 	}
 
-	// Check if sensor already exists
-	for _, s := range d.sensors {
-		if s.GetConfig().ID == sensorID {
-			msg.Respond([]byte(`{"error": "sensor already exists"}`))
-			return
-		}
+	readings, err := d.storage.GetLatestReadings(ctx, d.id, req.SensorID, 1)
+	if err != nil {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeInternal, "failed to retrieve readings")))
+		return
 	}
 
-	// Create a new sensor configuration from the request
-	sensorConfig := config.SensorConfig{
-		ID:        sensorID,
-		Type:      sensorType,
-		Enabled:   true,
-		Frequency: 30 * time.Second, // Default frequency
-		Min:       0,                // Default min
-		Max:       100,              // Default max
-		Unit:      "",
-	}
-
-	// Parse optional parameters from the request
-	if frequency, ok := registerRequest["frequency"].(string); ok {
-		if duration, err := time.ParseDuration(frequency); err == nil {
-			sensorConfig.Frequency = duration
-		}
+	if len(readings) == 0 {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeNotFound, "no readings found for sensor %s", req.SensorID)))
+		return
 	}
-	if min, ok := registerRequest["min"].(float64); ok {
-		sensorConfig.Min = min
+
+	respond(ctx, msg, api.OK(api.LatestReadingsResponse{
+		SensorID:      req.SensorID,
+		LatestReading: readings[0],
+	}))
+}
+
+// handleReadingsQuery handles requests for a page of stored readings.
+// Sensors are not checked against the current configuration, so the history
+// of removed sensors can still be queried.
+func (d *Device) handleReadingsQuery(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.readings.query")
+	defer span.End()
+
+	var req api.ReadingsQueryRequest
+	if err := api.Decode(msg.Data, &req); err != nil {
+		respond(ctx, msg, api.Fail(err))
+		return
 	}
-	if max, ok := registerRequest["max"].(float64); ok {
-		sensorConfig.Max = max
+
+	if d.storage == nil {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeUnavailable, "storage not available")))
+		return
 	}
-	if unit, ok := registerRequest["unit"].(string); ok {
-		sensorConfig.Unit = unit
+
+	page, err := d.storage.QueryReadings(ctx, req.Query(d.id))
+	switch {
+	case errors.Is(err, storage.ErrInvalidCursor):
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeInvalidArgument, "cursor: is invalid")))
+		return
+	case err != nil:
+		slog.Error(fmt.Sprintf("Error querying readings: %v", err))
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeInternal, "failed to query readings")))
+		return
 	}
 
-	// Create and add the new sensor
-	newSensor := sensor.New(sensorConfig, d.nc, d.storage)
-	d.sensors = append(d.sensors, newSensor)
+	respond(ctx, msg, api.OK(api.ReadingsQueryResponse{
+		Readings:   page.Readings,
+		NextCursor: page.NextCursor,
+	}))
+}
 
-	// Start the new sensor immediately in a new goroutine
-	if sensorConfig.Enabled {
-		go newSensor.StartSensor(context.Background(), d.id)
-		log.Printf("Started new sensor %s with frequency %v", sensorID, sensorConfig.Frequency)
+// handleReadingsAggregate handles requests for per-bucket statistics of a sensor's readings.
+func (d *Device) handleReadingsAggregate(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.readings.aggregate")
+	defer span.End()
+
+	var req api.ReadingsAggregateRequest
+	if err := api.Decode(msg.Data, &req); err != nil {
+		respond(ctx, msg, api.Fail(err))
+		return
 	}
 
-	// Save the updated device configuration to MongoDB
-	if d.storage != nil {
-		configs := make(map[string]interface{})
-		for _, s := range d.sensors {
-			configs[s.GetConfig().ID] = s.GetConfig()
-		}
-		d.storage.SaveConfig(d.id, configs)
+	if d.storage == nil {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeUnavailable, "storage not available")))
+		return
+	}
+
+	// The scalar value of multi-value sensors is unused, so they need a field.
+	// Removed sensors are not checked, as their readings may still be stored.
+	d.mu.RLock()
+	var fields []string
+	found, multiValue := false, false
+	if s := d.findSensor(req.SensorID); s != nil {
+		cfg := s.GetConfig()
+		fields, found, multiValue = sensor.Fields(cfg), true, sensor.MultiValue(cfg)
+	}
+	d.mu.RUnlock()
+	switch {
+	case multiValue && req.Field == "":
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeInvalidArgument,
+			"field: sensor %s has several values, name one of %s", req.SensorID, strings.Join(fields, ", "))))
+		return
+	case found && req.Field != "" && !slices.Contains(fields, req.Field):
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeInvalidArgument,
+			"field: sensor %s has no value %s", req.SensorID, req.Field)))
+		return
+	}
+
+	buckets, err := d.storage.AggregateReadings(ctx, req.Query(d.id))
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error aggregating readings of %s: %v", req.SensorID, err))
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeInternal, "failed to aggregate readings")))
+		return
+	}
+	if buckets == nil {
+		buckets = []storage.AggregateBucket{}
+	}
+
+	respond(ctx, msg, api.OK(api.ReadingsAggregateResponse{
+		SensorID: req.SensorID,
+		Bucket:   req.Bucket,
+		Buckets:  buckets,
+	}))
+}
+
+// handleAlarmsActive responds with the active alarms of a sensor, or of every sensor.
+func (d *Device) handleAlarmsActive(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.alarms.active")
+	defer span.End()
+
+	var req api.AlarmsActiveRequest
+	if err := api.Decode(msg.Data, &req); err != nil {
+		respond(ctx, msg, api.Fail(err))
+		return
 	}
 
-	response := map[string]interface{}{
-		"status":    "registered",
-		"sensor_id": sensorID,
-		"config":    sensorConfig,
+	alarms, found := d.collectAlarms(req.SensorID, (*sensor.Sensor).ActiveAlarms)
+	if !found {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeNotFound, "sensor %s not found", req.SensorID)))
+		return
 	}
 
-	data, _ := json.Marshal(response)
-	msg.Respond(data)
+	respond(ctx, msg, api.OK(api.AlarmsActiveResponse{Alarms: alarms}))
 }
 
-// handleLatestReadings responds with the most recent reading for a given sensor.
-func (d *Device) handleLatestReadings(msg *nats.Msg) {
-	var request map[string]interface{}
-	if err := json.Unmarshal(msg.Data, &request); err != nil {
-		msg.Respond([]byte(`{"error": "invalid JSON"}`))
+// handleAlarmsList responds with the alarms that are not normal, of a sensor or of every sensor.
+func (d *Device) handleAlarmsList(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.alarms.list")
+	defer span.End()
+
+	var req api.AlarmsListRequest
+	if err := api.Decode(msg.Data, &req); err != nil {
+		respond(ctx, msg, api.Fail(err))
 		return
 	}
 
-	sensorID, ok := request["sensor_id"].(string)
-	if !ok {
-		msg.Respond([]byte(`{"error": "sensor_id is required"}`))
+	alarms, found := d.collectAlarms(req.SensorID, (*sensor.Sensor).ListAlarms)
+	if !found {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeNotFound, "sensor %s not found", req.SensorID)))
 		return
 	}
 
-	// Find the target sensor
-	var targetSensor *sensor.Sensor
+	respond(ctx, msg, api.OK(api.AlarmsListResponse{Alarms: alarms}))
+}
+
+// collectAlarms returns the alarms that list returns for the sensor with the given ID,
+// or for every sensor if it is empty. It reports false if there is no such sensor.
+func (d *Device) collectAlarms(sensorID string, list func(*sensor.Sensor) []alarm.Alarm) ([]alarm.Alarm, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	alarms := []alarm.Alarm{}
 	for _, s := range d.sensors {
-		if s.GetConfig().ID == sensorID {
-			targetSensor = s
-			break
+		if sensorID == "" || s.GetConfig().ID == sensorID {
+			alarms = append(alarms, list(s)...)
 		}
 	}
+	return alarms, sensorID == "" || d.findSensor(sensorID) != nil
+}
+
+// handleAlarmsAck processes requests to acknowledge an alarm.
+func (d *Device) handleAlarmsAck(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.alarms.ack")
+	defer span.End()
 
+	var req api.AlarmAckRequest
+	if err := api.Decode(msg.Data, &req); err != nil {
+		respond(ctx, msg, api.Fail(err))
+		return
+	}
+
+	d.mu.RLock()
+	targetSensor := d.findSensor(req.SensorID)
+	d.mu.RUnlock()
 	if targetSensor == nil {
-		msg.Respond([]byte(`{"error": "sensor not found"}`))
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeNotFound, "sensor %s not found", req.SensorID)))
 		return
 	}
 
-	// Get latest readings from MongoDB if storage is available
-	if d.storage != nil {
-		readings, err := d.storage.GetLatestReadings(sensorID, 1)
-		if err != nil {
-			msg.Respond([]byte(`{"error": "failed to retrieve readings"}`))
-			return
-		}
+	a, err := targetSensor.AckAlarm(req.Alarm)
+	if err != nil {
+		respond(ctx, msg, api.Fail(alarmError(err, req.SensorID, req.Alarm)))
+		return
+	}
+	d.saveAudit(ctx, alarm.ActionAck, a, req.User, req.Comment)
 
-		if len(readings) == 0 {
-			msg.Respond([]byte(`{"error": "no readings found"}`))
-			return
-		}
+	respond(ctx, msg, api.OK(api.AlarmActionResponse{Status: "acknowledged", Alarm: a}))
+}
+
+// handleAlarmsShelve processes requests to shelve an alarm for a while.
+func (d *Device) handleAlarmsShelve(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.alarms.shelve")
+	defer span.End()
+
+	var req api.AlarmShelveRequest
+	if err := api.Decode(msg.Data, &req); err != nil {
+		respond(ctx, msg, api.Fail(err))
+		return
+	}
+
+	d.mu.RLock()
+	targetSensor := d.findSensor(req.SensorID)
+	d.mu.RUnlock()
+	if targetSensor == nil {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeNotFound, "sensor %s not found", req.SensorID)))
+		return
+	}
+
+	until := time.Now().Add(req.ShelveDuration())
+	a, err := targetSensor.ShelveAlarm(req.Alarm, until)
+	if err != nil {
+		respond(ctx, msg, api.Fail(alarmError(err, req.SensorID, req.Alarm)))
+		return
+	}
+	d.saveAudit(ctx, alarm.ActionShelve, a, req.User, req.Comment)
+	time.AfterFunc(time.Until(until), func() { d.expireShelving(req.SensorID, req.Alarm, until) })
+
+	respond(ctx, msg, api.OK(api.AlarmActionResponse{Status: "shelved", Alarm: a}))
+}
+
+// handleAlarmsUnshelve processes requests to unshelve an alarm before its shelving expires.
+func (d *Device) handleAlarmsUnshelve(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.alarms.unshelve")
+	defer span.End()
+
+	var req api.AlarmUnshelveRequest
+	if err := api.Decode(msg.Data, &req); err != nil {
+		respond(ctx, msg, api.Fail(err))
+		return
+	}
+
+	d.mu.RLock()
+	targetSensor := d.findSensor(req.SensorID)
+	d.mu.RUnlock()
+	if targetSensor == nil {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeNotFound, "sensor %s not found", req.SensorID)))
+		return
+	}
+
+	a, err := targetSensor.UnshelveAlarm(ctx, d.id, req.Alarm, time.Time{})
+	if err != nil {
+		respond(ctx, msg, api.Fail(alarmError(err, req.SensorID, req.Alarm)))
+		return
+	}
+	d.saveAudit(ctx, alarm.ActionUnshelve, a, req.User, req.Comment)
+
+	respond(ctx, msg, api.OK(api.AlarmActionResponse{Status: "unshelved", Alarm: a}))
+}
+
+// expireShelving unshelves an alarm whose shelving until the given time has expired,
+// unless it was unshelved, shelved again or removed in the meantime.
+func (d *Device) expireShelving(sensorID, name string, until time.Time) {
+	d.mu.RLock()
+	deviceCtx := d.ctx
+	targetSensor := d.findSensor(sensorID)
+	d.mu.RUnlock()
+	if targetSensor == nil || deviceCtx.Err() != nil {
+		return
+	}
+
+	ctx, span := tracer.Start(deviceCtx, "device.alarms.expire",
+		trace.WithAttributes(
+			attribute.String("device.id", d.id),
+			attribute.String("sensor.id", sensorID),
+			attribute.String("alarm.name", name),
+		))
+	defer span.End()
+
+	a, err := targetSensor.UnshelveAlarm(ctx, d.id, name, until)
+	if err != nil {
+		return
+	}
+	d.saveAudit(ctx, alarm.ActionExpire, a, "", "")
+}
+
+// alarmError maps an error of an alarm action to an API error.
+func alarmError(err error, sensorID, name string) *api.Error {
+	switch {
+	case errors.Is(err, alarm.ErrUnknown):
+		return api.NewError(api.CodeNotFound, "alarm %s of sensor %s not found", name, sensorID)
+	case errors.Is(err, alarm.ErrNotUnacked):
+		return api.NewError(api.CodeFailedPrecondition, "alarm %s of sensor %s is not unacknowledged", name, sensorID)
+	case errors.Is(err, alarm.ErrNotShelved):
+		return api.NewError(api.CodeFailedPrecondition, "alarm %s of sensor %s is not shelved", name, sensorID)
+	}
+	return api.NewError(api.CodeInternal, "%v", err)
+}
+
+// saveAudit logs an action on an alarm, whose state after the action is a, and appends
+// it to the audit trail if storage is available.
+func (d *Device) saveAudit(ctx context.Context, action string, a alarm.Alarm, user, comment string) {
+	entry := alarm.AuditEntry{
+		DeviceID:     d.id,
+		SensorID:     a.SensorID,
+		Alarm:        a.Alarm,
+		Action:       action,
+		User:         user,
+		Comment:      comment,
+		State:        a.State,
+		ShelvedUntil: a.ShelvedUntil,
+		Timestamp:    time.Now(),
+	}
+	log.Printf("Alarm %s of sensor %s: %s by %q, now %s", entry.Alarm, entry.SensorID, action, user, entry.State)
+
+	if d.storage == nil {
+		return
+	}
+	if err := d.storage.SaveAlarmAudit(ctx, entry); err != nil {
+		slog.Error(fmt.Sprintf("Error saving alarm audit entry: %v", err))
+	}
+}
+
+// ConfigureSensor applies the changes of a rule's configure action to its sensor, starting
+// or stopping the sensor if it is enabled or disabled, and persists the configuration.
+func (d *Device) ConfigureSensor(ctx context.Context, action config.ConfigureAction) error {
+	d.mu.Lock()
+	targetSensor := d.findSensor(action.Sensor)
+	if targetSensor == nil {
+		d.mu.Unlock()
+		return fmt.Errorf("sensor %s not found", action.Sensor)
+	}
+	cfg := action.Apply(targetSensor.GetConfig())
+	if err := cfg.Validate(); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	targetSensor.ApplyConfig(cfg)
+	if cfg.Enabled {
+		d.startSensor(targetSensor)
+	} else {
+		d.stopSensor(cfg.ID)
+	}
+	d.mu.Unlock()
+
+	d.saveConfigs(ctx)
+	return nil
+}
+
+// SetActuator commands the discrete sensor with the given ID into state, for rules.
+func (d *Device) SetActuator(ctx context.Context, sensorID, state string) error {
+	d.mu.RLock()
+	targetSensor := d.findSensor(sensorID)
+	d.mu.RUnlock()
+	if targetSensor == nil {
+		return fmt.Errorf("sensor %s not found", sensorID)
+	}
+	return targetSensor.SetState(state)
+}
+
+// handleSchemas responds with the published JSON Schemas of the control API.
+func (d *Device) handleSchemas(msg *nats.Msg) {
+	ctx, span := d.startSpan(msg, "device.api.schemas")
+	defer span.End()
 
-		data, _ := json.Marshal(map[string]interface{}{
-			"sensor_id":      sensorID,
-			"latest_reading": readings[0],
-		})
-		msg.Respond(data)
+	schemas, err := api.Schemas()
+	if err != nil {
+		respond(ctx, msg, api.Fail(api.NewError(api.CodeInternal, "failed to load schemas")))
 		return
 	}
 
-	msg.Respond([]byte(`{"error": "storage not available"}`))
+	respond(ctx, msg, api.OK(map[string]any{
+		"version": api.Version,
+		"schemas": schemas,
+	}))
 }
 
 // GetID returns the unique identifier of the device.
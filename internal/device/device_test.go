@@ -0,0 +1,111 @@
+package device
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"iot-device-simulator/internal/api"
+	"iot-device-simulator/internal/config"
+)
+
+// newTestDevice returns a device without NATS or storage whose sensors stop with the test.
+// The sensors read once an hour, so they publish nothing while a test runs.
+func newTestDevice(t *testing.T, sensors ...config.SensorConfig) *Device {
+	d := NewDevice(&config.Config{DeviceID: "device-001", Sensors: sensors}, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	d.ctx = ctx
+	return d
+}
+
+// testSensor returns the configuration of an enabled temperature sensor.
+func testSensor(id string, max float64) config.SensorConfig {
+	return config.SensorConfig{ID: id, Type: "temperature", Frequency: time.Hour, Min: 0, Max: max, Unit: "°C", Enabled: true}
+}
+
+// running reports whether the sensor with the given ID exists and runs.
+func (d *Device) running(id string) (exists, running bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, running = d.cancels[id]
+	return d.findSensor(id) != nil, running
+}
+
+// TestReload tests that a reload starts added sensors, stops removed ones and updates changed ones.
+func TestReload(t *testing.T) {
+	file := []config.SensorConfig{testSensor("temp-01", 30), testSensor("temp-02", 30)}
+	d := newTestDevice(t, file...)
+
+	disabled := testSensor("temp-02", 30)
+	disabled.Enabled = false
+	diff := d.Reload(&config.Config{DeviceID: "device-001", Sensors: []config.SensorConfig{disabled, testSensor("temp-03", 30)}})
+
+	if len(diff.Added) != 1 || len(diff.Removed) != 1 || len(diff.Changed) != 1 {
+		t.Fatalf("Expected 1 added, 1 removed and 1 changed sensor, got %+v", diff)
+	}
+	if exists, _ := d.running("temp-01"); exists {
+		t.Errorf("Expected temp-01 to be removed")
+	}
+	if exists, running := d.running("temp-02"); !exists || running {
+		t.Errorf("Expected temp-02 to exist and be stopped, got %v and %v", exists, running)
+	}
+	if exists, running := d.running("temp-03"); !exists || !running {
+		t.Errorf("Expected temp-03 to exist and run, got %v and %v", exists, running)
+	}
+}
+
+// TestReloadKeepsRuntimeChanges tests that a reload keeps registered sensors and the runtime
+// updates of sensors unchanged in the file, while an edited sensor takes its file settings.
+func TestReloadKeepsRuntimeChanges(t *testing.T) {
+	file := []config.SensorConfig{testSensor("temp-01", 30), testSensor("temp-02", 30)}
+	d := newTestDevice(t, file...)
+
+	d.handleSensorRegister(&nats.Msg{Data: []byte(`{"sensor_id": "temp-09", "type": "temperature", "frequency": "1h", "min": 0, "max": 30}`)})
+	d.handleConfigUpdate(&nats.Msg{Data: []byte(`{"sensor_id": "temp-01", "max": 40}`)})
+	d.handleConfigUpdate(&nats.Msg{Data: []byte(`{"sensor_id": "temp-02", "max": 40}`)})
+
+	// Only temp-02 changes in the file.
+	diff := d.Reload(&config.Config{DeviceID: "device-001", Sensors: []config.SensorConfig{testSensor("temp-01", 30), testSensor("temp-02", 35)}})
+	if len(diff.Added) != 0 || len(diff.Removed) != 0 || len(diff.Changed) != 1 {
+		t.Fatalf("Expected only temp-02 to change, got %+v", diff)
+	}
+
+	configs := d.sensorConfigs()
+	if _, ok := configs["temp-09"]; !ok {
+		t.Errorf("Expected the registered temp-09 to be kept")
+	}
+	if max := configs["temp-01"].Max; max != 40 {
+		t.Errorf("Expected temp-01 to keep its updated max 40, got %v", max)
+	}
+	if max := configs["temp-02"].Max; max != 35 {
+		t.Errorf("Expected temp-02 to take the max 35 of the file, got %v", max)
+	}
+
+	// A file sensor with the ID of a registered one replaces its configuration.
+	diff = d.Reload(&config.Config{DeviceID: "device-001", Sensors: []config.SensorConfig{testSensor("temp-01", 30), testSensor("temp-02", 35), testSensor("temp-09", 50)}})
+	if len(diff.Added) != 1 {
+		t.Fatalf("Expected temp-09 to be added, got %+v", diff)
+	}
+	if n := len(d.sensorConfigs()); n != 3 {
+		t.Errorf("Expected 3 sensors, got %d", n)
+	}
+	if max := d.sensorConfigs()["temp-09"].Max; max != 50 {
+		t.Errorf("Expected temp-09 to take the max 50 of the file, got %v", max)
+	}
+}
+
+// TestHandlers tests that every request subject has a handler and every handler a request subject.
+func TestHandlers(t *testing.T) {
+	handlers := newTestDevice(t).handlers()
+	for _, subject := range api.RequestSubjects {
+		if handlers[subject.Name] == nil {
+			t.Errorf("Expected a handler for %s", subject.Name)
+		}
+	}
+	if len(handlers) != len(api.RequestSubjects) {
+		t.Errorf("Expected %d handlers, got %d", len(api.RequestSubjects), len(handlers))
+	}
+}
@@ -0,0 +1,170 @@
+// Package export writes stored sensor readings to files for offline analysis,
+// as CSV, newline-delimited JSON or Parquet.
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"iot-device-simulator/internal/sensor"
+	"iot-device-simulator/internal/storage"
+)
+
+// Supported export formats.
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// Columns selects the optional columns of an export. Every export has the
+// timestamp, sensor_id, type, value and unit columns.
+type Columns struct {
+	// ValueKeys adds a "value_<key>" column per named value of multi-value readings.
+	// Readings without the value leave it empty in CSV, null in NDJSON and NaN in Parquet.
+	ValueKeys []string
+	// MultiValue lists the IDs of multi-value sensors, whose scalar "value" is unused
+	// and left empty like a missing named value.
+	MultiValue []string
+	// State adds the "state" of discrete sensor readings, empty for the others.
+	State bool
+	// Faults adds a boolean "fault" label and the "error" message of failed readings.
+	Faults bool
+	// Device adds "device_id" and a "tag_<key>" column for each of TagKeys.
+	Device  bool
+	TagKeys []string
+}
+
+// Writer writes readings in one format.
+type Writer interface {
+	// Write appends a reading.
+	Write(reading sensor.Reading) error
+	// Close flushes buffered data and finishes the file. It does not close the underlying io.Writer.
+	Close() error
+}
+
+// NewWriter returns a Writer for format that writes to w.
+func NewWriter(format string, w io.Writer, cols Columns) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, cols.columns())
+	case FormatNDJSON:
+		return newNDJSONWriter(w, cols.columns()), nil
+	case FormatParquet:
+		return newParquetWriter(w, cols.columns()), nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q (want csv, ndjson or parquet)", format)
+	}
+}
+
+// FormatFromPath guesses the format from the file extension of path.
+// It returns an empty string if the extension is not recognized.
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".parquet":
+		return FormatParquet
+	}
+	return ""
+}
+
+// Export streams every reading matching q from s into w, page by page, and
+// returns the number of readings written. q.Cursor and q.Limit are managed by Export.
+// It does not close w.
+func Export(ctx context.Context, s storage.Storage, q storage.ReadingQuery, w Writer) (int, error) {
+	q.Limit = storage.MaxQueryLimit
+	q.Cursor = ""
+
+	written := 0
+	for {
+		page, err := s.QueryReadings(ctx, q)
+		if err != nil {
+			return written, err
+		}
+		for _, reading := range page.Readings {
+			if err := w.Write(reading); err != nil {
+				return written, err
+			}
+			written++
+		}
+		if page.NextCursor == "" {
+			return written, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// kind is the type of a column.
+type kind int
+
+const (
+	kindTime kind = iota
+	kindString
+	kindFloat
+	kindBool
+)
+
+// column is one column of an export and how its value is taken from a reading.
+type column struct {
+	name  string
+	kind  kind
+	value func(r sensor.Reading) any
+}
+
+// columns returns the columns of an export, in file order.
+func (c Columns) columns() []column {
+	cols := []column{
+		{"timestamp", kindTime, func(r sensor.Reading) any { return r.Timestamp }},
+	}
+	if c.Device {
+		cols = append(cols, column{"device_id", kindString, func(r sensor.Reading) any { return r.DeviceID }})
+	}
+	cols = append(cols,
+		column{"sensor_id", kindString, func(r sensor.Reading) any { return r.SensorID }},
+		column{"type", kindString, func(r sensor.Reading) any { return r.Type }},
+		column{"value", kindFloat, func(r sensor.Reading) any {
+			if slices.Contains(c.MultiValue, r.SensorID) {
+				return math.NaN()
+			}
+			return r.Value
+		}},
+	)
+	for _, key := range c.ValueKeys {
+		cols = append(cols, column{"value_" + key, kindFloat, func(r sensor.Reading) any {
+			if v, ok := r.Values[key]; ok {
+				return v
+			}
+			return math.NaN()
+		}})
+	}
+	if c.State {
+		cols = append(cols, column{"state", kindString, func(r sensor.Reading) any { return r.State }})
+	}
+	cols = append(cols, column{"unit", kindString, func(r sensor.Reading) any { return r.Unit }})
+	if c.Faults {
+		cols = append(cols,
+			column{"fault", kindBool, func(r sensor.Reading) any { return r.Error != "" }},
+			column{"error", kindString, func(r sensor.Reading) any { return r.Error }},
+		)
+	}
+	if c.Device {
+		for _, key := range c.TagKeys {
+			cols = append(cols, column{"tag_" + key, kindString, func(r sensor.Reading) any { return r.Tags[key] }})
+		}
+	}
+	return cols
+}
+
+// timestampValue returns the timestamp in UTC, the zone of every exported timestamp.
+func timestampValue(v any) time.Time {
+	return v.(time.Time).UTC()
+}
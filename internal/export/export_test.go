@@ -0,0 +1,188 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"iot-device-simulator/internal/sensor"
+	"iot-device-simulator/internal/storage"
+)
+
+// testStore returns a memory store with three readings of device-001, one of them failed,
+// and one reading of another device.
+func testStore(t *testing.T) (storage.Storage, time.Time) {
+	t.Helper()
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tags := map[string]string{"site": "lab-1"}
+	s := storage.NewMemory(0)
+	for _, r := range []sensor.Reading{
+		{DeviceID: "device-001", SensorID: "temp-01", Type: "temperature", Value: 21.5, Unit: "°C", Timestamp: start, Tags: tags},
+		{DeviceID: "device-001", SensorID: "hum-01", Type: "humidity", Value: 40, Unit: "%", Timestamp: start.Add(time.Second), Tags: tags},
+		{DeviceID: "device-001", SensorID: "temp-01", Type: "temperature", Unit: "°C", Timestamp: start.Add(2 * time.Second), Error: "sensor communication error", Tags: tags},
+		{DeviceID: "device-002", SensorID: "temp-01", Type: "temperature", Value: 99, Unit: "°C", Timestamp: start},
+	} {
+		if err := s.SaveReading(context.Background(), r); err != nil {
+			t.Fatalf("Error saving reading: %v", err)
+		}
+	}
+	return s, start
+}
+
+// export runs an export of device-001 into a buffer.
+func export(t *testing.T, format string, q storage.ReadingQuery, cols Columns) ([]byte, int) {
+	t.Helper()
+	s, _ := testStore(t)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf, cols)
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	q.DeviceID = "device-001"
+	n, err := Export(context.Background(), s, q, w)
+	if err != nil {
+		t.Fatalf("Error exporting: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Error closing writer: %v", err)
+	}
+	return buf.Bytes(), n
+}
+
+// TestExportCSV tests the CSV header, optional columns and filters.
+func TestExportCSV(t *testing.T) {
+	data, n := export(t, FormatCSV, storage.ReadingQuery{IncludeErrors: true},
+		Columns{Faults: true, Device: true, TagKeys: []string{"site"}})
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	if n != 3 || len(records) != 4 {
+		t.Fatalf("Expected a header and 3 rows, got %d readings and %d records", n, len(records))
+	}
+	if header := strings.Join(records[0], ","); header != "timestamp,device_id,sensor_id,type,value,unit,fault,error,tag_site" {
+		t.Errorf("Unexpected header: %s", header)
+	}
+	if row := strings.Join(records[1], ","); row != "2025-01-01T00:00:00Z,device-001,temp-01,temperature,21.5,°C,false,,lab-1" {
+		t.Errorf("Unexpected first row: %s", row)
+	}
+	if records[3][6] != "true" || records[3][7] != "sensor communication error" {
+		t.Errorf("Expected the last row to be labeled as a fault, got %v", records[3])
+	}
+
+	data, n = export(t, FormatCSV, storage.ReadingQuery{SensorIDs: []string{"temp-01"}}, Columns{})
+	if n != 1 || string(data) != "timestamp,sensor_id,type,value,unit\n2025-01-01T00:00:00Z,temp-01,temperature,21.5,°C\n" {
+		t.Errorf("Unexpected filtered export (%d readings):\n%s", n, data)
+	}
+
+	data, _ = export(t, FormatCSV, storage.ReadingQuery{SensorIDs: []string{"temp-01"}}, Columns{State: true})
+	if string(data) != "timestamp,sensor_id,type,value,state,unit\n2025-01-01T00:00:00Z,temp-01,temperature,21.5,,°C\n" {
+		t.Errorf("Unexpected export with states:\n%s", data)
+	}
+}
+
+// TestExportNDJSON tests that every line is a JSON object with the selected columns.
+func TestExportNDJSON(t *testing.T) {
+	data, _ := export(t, FormatNDJSON, storage.ReadingQuery{IncludeErrors: true}, Columns{Faults: true})
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %d", len(lines))
+	}
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(lines[2]), &obj); err != nil {
+		t.Fatalf("Invalid JSON line: %v", err)
+	}
+	if obj["fault"] != true || obj["sensor_id"] != "temp-01" || obj["timestamp"] != "2025-01-01T00:00:02Z" {
+		t.Errorf("Unexpected line: %v", obj)
+	}
+	if _, ok := obj["device_id"]; ok {
+		t.Errorf("Expected no device columns, got %v", obj)
+	}
+}
+
+// TestExportValues tests the value_<key> columns of multi-value readings.
+func TestExportValues(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := storage.NewMemory(0)
+	for _, r := range []sensor.Reading{
+		{DeviceID: "device-001", SensorID: "accel-01", Type: "acceleration", Values: map[string]float64{"x": 0.5, "y": -1}, Unit: "g", Timestamp: start},
+		{DeviceID: "device-001", SensorID: "temp-01", Type: "temperature", Value: 21.5, Unit: "°C", Timestamp: start.Add(time.Second)},
+	} {
+		if err := s.SaveReading(context.Background(), r); err != nil {
+			t.Fatalf("Error saving reading: %v", err)
+		}
+	}
+
+	for format, expected := range map[string]string{
+		FormatCSV: "timestamp,sensor_id,type,value,value_x,value_y,unit\n" +
+			"2025-01-01T00:00:00Z,accel-01,acceleration,,0.5,-1,g\n" +
+			"2025-01-01T00:00:01Z,temp-01,temperature,21.5,,,°C\n",
+		FormatNDJSON: `{"sensor_id":"accel-01","timestamp":"2025-01-01T00:00:00Z","type":"acceleration","unit":"g","value":null,"value_x":0.5,"value_y":-1}` + "\n" +
+			`{"sensor_id":"temp-01","timestamp":"2025-01-01T00:00:01Z","type":"temperature","unit":"°C","value":21.5,"value_x":null,"value_y":null}` + "\n",
+	} {
+		var buf bytes.Buffer
+		w, err := NewWriter(format, &buf, Columns{ValueKeys: []string{"x", "y"}, MultiValue: []string{"accel-01"}})
+		if err != nil {
+			t.Fatalf("Error creating writer: %v", err)
+		}
+		if _, err := Export(context.Background(), s, storage.ReadingQuery{DeviceID: "device-001"}, w); err != nil {
+			t.Fatalf("Error exporting: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Error closing writer: %v", err)
+		}
+		if buf.String() != expected {
+			t.Errorf("Unexpected %s export:\n%s", format, buf.String())
+		}
+	}
+}
+
+// TestExportParquet tests that the Parquet file can be read back.
+func TestExportParquet(t *testing.T) {
+	data, _ := export(t, FormatParquet, storage.ReadingQuery{IncludeErrors: true},
+		Columns{Faults: true, Device: true, TagKeys: []string{"site"}})
+
+	type row struct {
+		Timestamp time.Time `parquet:"timestamp,timestamp(nanosecond)"`
+		DeviceID  string    `parquet:"device_id"`
+		SensorID  string    `parquet:"sensor_id"`
+		Value     float64   `parquet:"value"`
+		Fault     bool      `parquet:"fault"`
+		TagSite   string    `parquet:"tag_site"`
+	}
+	rows, err := parquet.Read[row](bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Error reading Parquet file: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d", len(rows))
+	}
+	first := rows[0]
+	if !first.Timestamp.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) || first.DeviceID != "device-001" ||
+		first.SensorID != "temp-01" || first.Value != 21.5 || first.Fault || first.TagSite != "lab-1" {
+		t.Errorf("Unexpected first row: %+v", first)
+	}
+	if !rows[2].Fault {
+		t.Errorf("Expected the last row to be labeled as a fault")
+	}
+}
+
+// TestFormatFromPath tests guessing the format from a file name.
+func TestFormatFromPath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"out.csv": FormatCSV, "out.NDJSON": FormatNDJSON, "out.jsonl": FormatNDJSON, "out.parquet": FormatParquet, "out.txt": "",
+	} {
+		if got := FormatFromPath(path); got != expected {
+			t.Errorf("Expected %q for %s, got %q", expected, path, got)
+		}
+	}
+}
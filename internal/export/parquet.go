@@ -0,0 +1,97 @@
+package export
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+
+	"iot-device-simulator/internal/sensor"
+)
+
+// parquetRowGroupSize is the number of readings buffered per row group.
+const parquetRowGroupSize = 10000
+
+// parquetWriter writes readings to a Snappy-compressed Parquet file with one
+// required column per export column. Timestamps are UTC nanoseconds.
+type parquetWriter struct {
+	w       *parquet.Writer
+	cols    []column
+	indexes []int // parquet column index of each export column
+	rows    []parquet.Row
+}
+
+// newParquetWriter returns a Parquet Writer.
+func newParquetWriter(w io.Writer, cols []column) *parquetWriter {
+	group := make(parquet.Group, len(cols))
+	for _, col := range cols {
+		switch col.kind {
+		case kindTime:
+			group[col.name] = parquet.Timestamp(parquet.Nanosecond)
+		case kindFloat:
+			group[col.name] = parquet.Leaf(parquet.DoubleType)
+		case kindBool:
+			group[col.name] = parquet.Leaf(parquet.BooleanType)
+		default:
+			group[col.name] = parquet.String()
+		}
+	}
+	schema := parquet.NewSchema("reading", group)
+
+	// Parquet orders the columns of a group by name; map each export column to its index.
+	indexes := make([]int, len(cols))
+	for i, col := range cols {
+		leaf, _ := schema.Lookup(col.name)
+		indexes[i] = leaf.ColumnIndex
+	}
+
+	return &parquetWriter{
+		w:       parquet.NewWriter(w, schema, parquet.Compression(&parquet.Snappy)),
+		cols:    cols,
+		indexes: indexes,
+	}
+}
+
+// Write buffers a reading, writing a row group when enough readings are buffered.
+func (p *parquetWriter) Write(reading sensor.Reading) error {
+	row := make(parquet.Row, len(p.cols))
+	for i, col := range p.cols {
+		var v parquet.Value
+		switch x := col.value(reading); col.kind {
+		case kindTime:
+			v = parquet.Int64Value(timestampValue(x).UnixNano())
+		case kindFloat:
+			v = parquet.DoubleValue(x.(float64))
+		case kindBool:
+			v = parquet.BooleanValue(x.(bool))
+		default:
+			v = parquet.ByteArrayValue([]byte(x.(string)))
+		}
+		row[p.indexes[i]] = v.Level(0, 0, p.indexes[i])
+	}
+	p.rows = append(p.rows, row)
+
+	if len(p.rows) >= parquetRowGroupSize {
+		return p.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group.
+func (p *parquetWriter) flush() error {
+	if len(p.rows) == 0 {
+		return nil
+	}
+	if _, err := p.w.WriteRows(p.rows); err != nil {
+		return err
+	}
+	p.rows = p.rows[:0]
+	return p.w.Flush()
+}
+
+// Close writes the remaining rows and the file footer.
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return p.w.Close()
+}
@@ -0,0 +1,96 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"iot-device-simulator/internal/sensor"
+)
+
+// csvWriter writes readings as CSV with a header row.
+type csvWriter struct {
+	w    *csv.Writer
+	cols []column
+	row  []string
+}
+
+// newCSVWriter writes the header and returns a CSV Writer.
+func newCSVWriter(w io.Writer, cols []column) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), cols: cols, row: make([]string, len(cols))}
+	for i, col := range cols {
+		cw.row[i] = col.name
+	}
+	if err := cw.w.Write(cw.row); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// Write appends a reading as a CSV row.
+func (c *csvWriter) Write(reading sensor.Reading) error {
+	for i, col := range c.cols {
+		v := col.value(reading)
+		switch col.kind {
+		case kindTime:
+			c.row[i] = timestampValue(v).Format(time.RFC3339Nano)
+		case kindFloat:
+			if f := v.(float64); math.IsNaN(f) {
+				c.row[i] = ""
+			} else {
+				c.row[i] = strconv.FormatFloat(f, 'g', -1, 64)
+			}
+		case kindBool:
+			c.row[i] = strconv.FormatBool(v.(bool))
+		default:
+			c.row[i] = v.(string)
+		}
+	}
+	return c.w.Write(c.row)
+}
+
+// Close flushes the buffered rows.
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonWriter writes one JSON object per reading and line.
+type ndjsonWriter struct {
+	w    *bufio.Writer
+	enc  *json.Encoder
+	cols []column
+}
+
+// newNDJSONWriter returns a newline-delimited JSON Writer.
+func newNDJSONWriter(w io.Writer, cols []column) *ndjsonWriter {
+	bw := bufio.NewWriter(w)
+	return &ndjsonWriter{w: bw, enc: json.NewEncoder(bw), cols: cols}
+}
+
+// Write appends a reading as a JSON object keyed by column name.
+func (n *ndjsonWriter) Write(reading sensor.Reading) error {
+	obj := make(map[string]any, len(n.cols))
+	for _, col := range n.cols {
+		v := col.value(reading)
+		switch col.kind {
+		case kindTime:
+			v = timestampValue(v)
+		case kindFloat:
+			if math.IsNaN(v.(float64)) {
+				v = nil // JSON has no NaN
+			}
+		}
+		obj[col.name] = v
+	}
+	return n.enc.Encode(obj)
+}
+
+// Close flushes the buffered lines.
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
@@ -0,0 +1,446 @@
+// Package expr parses and evaluates the arithmetic expressions of virtual sensors,
+// such as "dewpoint(temp-01, humidity-01)" or "avg(temp-*) + 0.5".
+//
+// Names refer to the latest value of other sensors, and a field of a multi-value sensor
+// is written "sensor.field". Names with a "*" are patterns that match several sensors;
+// they may only be passed to the aggregate functions avg, min, max, sum and count.
+// As sensor IDs may contain hyphens and patterns asterisks, "-" and "*" after a name
+// need a space before them: "temp-01 - temp-02", "temp-01 * 2".
+//
+// Comparisons (<, <=, >, >=, ==, !=) and the logical operators &&, || and ! evaluate
+// to 1 for true and 0 for false.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Env supplies the values of the names in an expression.
+type Env interface {
+	// Value returns the value of the named sensor or sensor field.
+	Value(name string) (float64, error)
+	// Values returns the values of the sensors matching pattern.
+	Values(pattern string) []float64
+}
+
+// Expr is a parsed expression.
+type Expr struct {
+	src  string
+	root node
+	refs []string
+}
+
+// Parse parses an expression.
+func Parse(src string) (*Expr, error) {
+	p := &parser{src: src}
+	p.next()
+	root, err := p.parseOr()
+	if err == nil && p.tok.kind != tokEOF {
+		err = p.errorf("unexpected %s", p.tok)
+	}
+	if p.err != nil {
+		err = p.err // an invalid character, which the grammar then tripped over
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{src: src, root: root, refs: p.refs}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Refs returns the names and patterns the expression refers to, in order of first appearance.
+func (e *Expr) Refs() []string {
+	return e.refs
+}
+
+// Eval evaluates the expression in env. Results that are not finite numbers are errors.
+func (e *Expr) Eval(env Env) (float64, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("%s is not a finite number", strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return v, nil
+}
+
+// IsPattern reports whether name is a pattern matching several sensors.
+func IsPattern(name string) bool {
+	return strings.Contains(name, "*")
+}
+
+// node is an element of the syntax tree.
+type node interface {
+	eval(env Env) (float64, error)
+}
+
+type (
+	number  float64
+	ref     string
+	pattern string
+	unary   struct {
+		op string
+		x  node
+	}
+	binary struct {
+		op   string
+		x, y node
+	}
+	call struct {
+		fn   *function
+		name string
+		args []node
+	}
+)
+
+func (n number) eval(Env) (float64, error) { return float64(n), nil }
+
+func (n ref) eval(env Env) (float64, error) { return env.Value(string(n)) }
+
+func (n pattern) eval(Env) (float64, error) {
+	// The parser only accepts patterns as arguments of aggregates, which expand them.
+	return 0, fmt.Errorf("pattern %s outside an aggregate", string(n))
+}
+
+func (n *unary) eval(env Env) (float64, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if n.op == "!" {
+		return truth(x == 0), nil
+	}
+	return -x, nil
+}
+
+func (n *binary) eval(env Env) (float64, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	// && and || only evaluate their right operand when needed.
+	switch {
+	case n.op == "&&" && x == 0:
+		return 0, nil
+	case n.op == "||" && x != 0:
+		return 1, nil
+	}
+	y, err := n.y.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		if y == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return x / y, nil
+	case "%":
+		if y == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return math.Mod(x, y), nil
+	case "<":
+		return truth(x < y), nil
+	case "<=":
+		return truth(x <= y), nil
+	case ">":
+		return truth(x > y), nil
+	case ">=":
+		return truth(x >= y), nil
+	case "==":
+		return truth(x == y), nil
+	case "!=":
+		return truth(x != y), nil
+	}
+	return truth(y != 0), nil // && and || with a deciding right operand
+}
+
+func (n *call) eval(env Env) (float64, error) {
+	args := make([]float64, 0, len(n.args))
+	for _, arg := range n.args {
+		if p, ok := arg.(pattern); ok {
+			args = append(args, env.Values(string(p))...)
+			continue
+		}
+		v, err := arg.eval(env)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, v)
+	}
+	if n.fn.aggregate && len(args) == 0 && n.name != "count" {
+		return 0, fmt.Errorf("%s: no values", n.name)
+	}
+	return n.fn.apply(args), nil
+}
+
+// truth converts a condition to 1 or 0.
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Token kinds.
+const (
+	tokEOF = iota
+	tokNumber
+	tokName
+	tokOp
+)
+
+// token is a lexical token of an expression.
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+// String describes the token for error messages.
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// parser is a recursive descent parser with one token of lookahead.
+type parser struct {
+	src  string
+	pos  int
+	tok  token
+	err  error
+	refs []string
+}
+
+// errorf returns a parse error at the current token.
+func (p *parser) errorf(format string, args ...any) error {
+	if p.err != nil {
+		return p.err
+	}
+	return fmt.Errorf("at %d: %s", p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+// operators lists the operators, longest first so that "<=" wins over "<".
+var operators = []string{"&&", "||", "<=", ">=", "==", "!=", "+", "-", "*", "/", "%", "<", ">", "!", "(", ")", ","}
+
+// next reads the next token into p.tok.
+func (p *parser) next() {
+	for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos == len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case isDigit(c) || c == '.' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1]):
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+				p.pos++
+			}
+			for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+				p.pos++
+			}
+		}
+		p.tok = token{kind: tokNumber, text: p.src[start:p.pos], pos: start}
+	case isNameStart(c):
+		for p.pos < len(p.src) && isNamePart(p.src[p.pos]) {
+			p.pos++
+		}
+		p.tok = token{kind: tokName, text: p.src[start:p.pos], pos: start}
+	default:
+		for _, op := range operators {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.pos += len(op)
+				p.tok = token{kind: tokOp, text: op, pos: start}
+				return
+			}
+		}
+		p.tok = token{kind: tokOp, text: string(c), pos: start}
+		if p.err == nil {
+			p.err = fmt.Errorf("at %d: unexpected character %q", start+1, c)
+		}
+		p.pos++
+	}
+}
+
+// isDigit reports whether c is a decimal digit.
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// isNameStart reports whether a name can start with c.
+func isNameStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
+
+// isNamePart reports whether c can continue a name.
+func isNamePart(c byte) bool {
+	return isNameStart(c) || isDigit(c) || c == '-' || c == '.' || c == '*'
+}
+
+// accept consumes the operator op if it is the current token.
+func (p *parser) accept(op string) bool {
+	if p.tok.kind == tokOp && p.tok.text == op {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// binaryLevel parses a left-associative chain of the given operators over operands
+// parsed by operand.
+func (p *parser) binaryLevel(operand func() (node, error), ops ...string) (node, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && slices.Contains(ops, p.tok.text) {
+		op := p.tok.text
+		p.next()
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = &binary{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.binaryLevel(p.parseAnd, "||")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.binaryLevel(p.parseComparison, "&&")
+}
+
+func (p *parser) parseComparison() (node, error) {
+	return p.binaryLevel(p.parseSum, "<", "<=", ">", ">=", "==", "!=")
+}
+
+func (p *parser) parseSum() (node, error) {
+	return p.binaryLevel(p.parseProduct, "+", "-")
+}
+
+func (p *parser) parseProduct() (node, error) {
+	return p.binaryLevel(p.parseUnary, "*", "/", "%")
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.tok.kind == tokOp && (p.tok.text == "-" || p.tok.text == "!") {
+		op := p.tok.text
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: op, x: x}, nil
+	}
+	return p.parsePrimary(false)
+}
+
+// parsePrimary parses a number, name, call or parenthesized expression. Patterns are
+// only accepted as arguments of aggregates.
+func (p *parser) parsePrimary(inAggregate bool) (node, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok.text)
+		}
+		p.next()
+		return number(v), nil
+
+	case tok.kind == tokName:
+		p.next()
+		if p.tok.kind == tokOp && p.tok.text == "(" {
+			return p.parseCall(tok)
+		}
+		if !slices.Contains(p.refs, tok.text) {
+			p.refs = append(p.refs, tok.text)
+		}
+		if IsPattern(tok.text) {
+			if !inAggregate {
+				return nil, fmt.Errorf("at %d: pattern %s can only be passed to avg, min, max, sum or count", tok.pos+1, tok.text)
+			}
+			return pattern(tok.text), nil
+		}
+		return ref(tok.text), nil
+
+	case p.accept("("):
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("expected \")\", got %s", p.tok)
+		}
+		return x, nil
+	}
+	return nil, p.errorf("unexpected %s", tok)
+}
+
+// parseCall parses the arguments of a call to the function called name.tok, whose
+// opening parenthesis is the current token.
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("at %d: unknown function %s", name.pos+1, name.text)
+	}
+	p.next()
+
+	c := &call{fn: fn, name: name.text}
+	if !p.accept(")") {
+		for {
+			var arg node
+			var err error
+			if fn.aggregate && p.tok.kind == tokName && IsPattern(p.tok.text) {
+				arg, err = p.parsePrimary(true)
+			} else {
+				arg, err = p.parseOr()
+			}
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, arg)
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, p.errorf("expected \",\" or \")\", got %s", p.tok)
+			}
+		}
+	}
+
+	if len(c.args) < fn.minArgs || fn.maxArgs >= 0 && len(c.args) > fn.maxArgs {
+		return nil, fmt.Errorf("at %d: %s takes %s", name.pos+1, name.text, fn.arity())
+	}
+	return c, nil
+}
@@ -0,0 +1,146 @@
+package expr
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// mapEnv resolves names from a map, matching patterns with path.Match.
+type mapEnv map[string]float64
+
+// Value returns the value of name.
+func (m mapEnv) Value(name string) (float64, error) {
+	v, ok := m[name]
+	if !ok {
+		return 0, fmt.Errorf("%s has no value", name)
+	}
+	return v, nil
+}
+
+// Values returns the values of the names matching pattern, in name order.
+func (m mapEnv) Values(pattern string) []float64 {
+	var values []float64
+	for _, name := range slices.Sorted(maps.Keys(m)) {
+		if ok, _ := path.Match(pattern, name); ok {
+			values = append(values, m[name])
+		}
+	}
+	return values
+}
+
+// TestEval tests operators, precedence, names and functions.
+func TestEval(t *testing.T) {
+	env := mapEnv{"temp-01": 20, "temp-02": 24, "humidity-01": 50, "gps-01.speed": 12.5, "door": 1}
+	tests := []struct {
+		src  string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"-2 * -3", 6},
+		{"10 / 4 - 1.5e0", 1},
+		{"7 % 4", 3},
+		{"temp-01 - temp-02", -4},
+		{"temp-01 * 2", 40},
+		{"avg(temp-*)", 22},
+		{"max(temp-*, 30)", 30},
+		{"count(temp-*, pressure-*)", 2},
+		{"sum(temp-01, temp-02) / 2", 22},
+		{"gps-01.speed * 3.6", 45},
+		{"temp-01 > 19 && !door", 0},
+		{"temp-01 > 19 || missing > 0", 1},
+		{"temp-01 >= 20 == 1", 1},
+		{"if(door, temp-01, temp-02)", 20},
+		{"round(dewpoint(temp-01, humidity-01) * 10) / 10", 9.3},
+		{"pow(2, 10) + abs(-1) + sqrt(16)", 1029},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("%s: unexpected parse error: %v", tt.src, err)
+			continue
+		}
+		got, err := e.Eval(env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.src, err)
+		} else if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", tt.src, tt.want, got)
+		}
+	}
+}
+
+// TestEvalErrors tests errors raised while evaluating.
+func TestEvalErrors(t *testing.T) {
+	env := mapEnv{"temp-01": 20, "zero": 0}
+	for src, want := range map[string]string{
+		"temp-02 + 1":     "temp-02 has no value",
+		"temp-01 / zero":  "division by zero",
+		"avg(pressure-*)": "avg: no values",
+		"sqrt(-1)":        "NaN is not a finite number",
+	} {
+		e, err := Parse(src)
+		if err != nil {
+			t.Fatalf("%s: unexpected parse error: %v", src, err)
+		}
+		if _, err := e.Eval(env); err == nil || err.Error() != want {
+			t.Errorf("%s: expected error %q, got %v", src, want, err)
+		}
+	}
+}
+
+// TestParseErrors tests that malformed expressions are rejected with their position.
+func TestParseErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"":                "at 1: unexpected end of expression",
+		"1 +":             "at 4: unexpected end of expression",
+		"(1 + 2":          "at 7: expected \")\"",
+		"1 2":             "at 3: unexpected \"2\"",
+		"temp-* + 1":      "at 1: pattern temp-* can only be passed",
+		"temp-01*2":       "at 1: pattern temp-01*2 can only be passed",
+		"abs(temp-*)":     "at 5: pattern temp-* can only be passed",
+		"foo(1)":          "at 1: unknown function foo",
+		"dewpoint(1)":     "at 1: dewpoint takes 2 arguments",
+		"avg()":           "at 1: avg takes at least 1 argument",
+		"temp-01 # 2":     "at 9: unexpected character '#'",
+		"max(1, 2":        "at 9: expected \",\" or \")\"",
+		"1.2.3":           "at 1: invalid number 1.2.3",
+		"temp-01 = 2":     "at 9: unexpected character '='",
+		"temp-01 + (2))":  "at 14: unexpected \")\"",
+		"avg(temp-*, , )": "at 13: unexpected \",\"",
+	} {
+		_, err := Parse(src)
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%q: expected error starting with %q, got %v", src, want, err)
+		}
+	}
+}
+
+// TestRefs tests that referenced names and patterns are listed once, in order.
+func TestRefs(t *testing.T) {
+	e, err := Parse("heatindex(temp-01, humidity-01) - avg(temp-*) + temp-01")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if refs := e.Refs(); !slices.Equal(refs, []string{"temp-01", "humidity-01", "temp-*"}) {
+		t.Errorf("Unexpected refs: %v", refs)
+	}
+}
+
+// TestHeatIndex tests the heat index against values of the NWS table.
+func TestHeatIndex(t *testing.T) {
+	tests := []struct{ t, rh, want float64 }{
+		{20, 50, 19.4}, // below the regression, close to the temperature
+		{32.2, 50, 35},
+		{37.8, 40, 42.9},
+	}
+	for _, tt := range tests {
+		if hi := HeatIndex(tt.t, tt.rh); math.Abs(hi-tt.want) > 0.3 {
+			t.Errorf("Heat index of %v °C at %v%%: expected %v, got %v", tt.t, tt.rh, tt.want, hi)
+		}
+	}
+}
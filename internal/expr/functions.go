@@ -0,0 +1,106 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+)
+
+// function is a built-in function.
+type function struct {
+	minArgs, maxArgs int // maxArgs is -1 for variadic functions
+	// aggregate functions accept patterns, expanded to the values of the matching sensors.
+	aggregate bool
+	apply     func(args []float64) float64
+}
+
+// arity describes the number of arguments of fn for error messages.
+func (fn *function) arity() string {
+	noun := "arguments"
+	if fn.minArgs == 1 && fn.maxArgs <= 1 {
+		noun = "argument"
+	}
+	switch {
+	case fn.maxArgs < 0:
+		return fmt.Sprintf("at least %d %s", fn.minArgs, noun)
+	case fn.minArgs == fn.maxArgs:
+		return fmt.Sprintf("%d %s", fn.minArgs, noun)
+	}
+	return fmt.Sprintf("%d to %d %s", fn.minArgs, fn.maxArgs, noun)
+}
+
+// functions holds the built-in functions by name.
+var functions = map[string]*function{
+	"avg": {minArgs: 1, maxArgs: -1, aggregate: true, apply: func(args []float64) float64 {
+		return sum(args) / float64(len(args))
+	}},
+	"sum": {minArgs: 1, maxArgs: -1, aggregate: true, apply: sum},
+	"min": {minArgs: 1, maxArgs: -1, aggregate: true, apply: func(args []float64) float64 {
+		m := args[0]
+		for _, v := range args[1:] {
+			m = math.Min(m, v)
+		}
+		return m
+	}},
+	"max": {minArgs: 1, maxArgs: -1, aggregate: true, apply: func(args []float64) float64 {
+		m := args[0]
+		for _, v := range args[1:] {
+			m = math.Max(m, v)
+		}
+		return m
+	}},
+	"count": {minArgs: 1, maxArgs: -1, aggregate: true, apply: func(args []float64) float64 {
+		return float64(len(args))
+	}},
+
+	"abs":   {minArgs: 1, maxArgs: 1, apply: func(args []float64) float64 { return math.Abs(args[0]) }},
+	"sqrt":  {minArgs: 1, maxArgs: 1, apply: func(args []float64) float64 { return math.Sqrt(args[0]) }},
+	"round": {minArgs: 1, maxArgs: 1, apply: func(args []float64) float64 { return math.Round(args[0]) }},
+	"pow":   {minArgs: 2, maxArgs: 2, apply: func(args []float64) float64 { return math.Pow(args[0], args[1]) }},
+	"if": {minArgs: 3, maxArgs: 3, apply: func(args []float64) float64 {
+		if args[0] != 0 {
+			return args[1]
+		}
+		return args[2]
+	}},
+
+	"dewpoint":  {minArgs: 2, maxArgs: 2, apply: func(args []float64) float64 { return DewPoint(args[0], args[1]) }},
+	"heatindex": {minArgs: 2, maxArgs: 2, apply: func(args []float64) float64 { return HeatIndex(args[0], args[1]) }},
+}
+
+// sum returns the sum of values.
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// DewPoint returns the dew point in °C of air at temperature °C and relative humidity
+// percent, using the Magnus formula.
+func DewPoint(temperature, humidity float64) float64 {
+	const b, c = 17.62, 243.12
+	gamma := math.Log(humidity/100) + b*temperature/(c+temperature)
+	return c * gamma / (b - gamma)
+}
+
+// HeatIndex returns the apparent temperature in °C of air at temperature °C and relative
+// humidity percent, using the regression of the US National Weather Service.
+func HeatIndex(temperature, humidity float64) float64 {
+	t, rh := temperature*9/5+32, humidity
+
+	// The simple formula is used below 80 °F, where the regression does not apply.
+	hi := 0.5 * (t + 61 + (t-68)*1.2 + rh*0.094)
+	if (hi+t)/2 >= 80 {
+		hi = -42.379 + 2.04901523*t + 10.14333127*rh - 0.22475541*t*rh -
+			6.83783e-3*t*t - 5.481717e-2*rh*rh + 1.22874e-3*t*t*rh +
+			8.5282e-4*t*rh*rh - 1.99e-6*t*t*rh*rh
+		switch {
+		case rh < 13 && t >= 80 && t <= 112:
+			hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+		case rh > 85 && t >= 80 && t <= 87:
+			hi += (rh - 85) / 10 * (87 - t) / 5
+		}
+	}
+	return (hi - 32) * 5 / 9
+}
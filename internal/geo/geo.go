@@ -0,0 +1,191 @@
+// Package geo provides the geodesy used to move simulated trackers along a route:
+// distances and bearings on a spherical Earth, routes loaded from GeoJSON or made of
+// random waypoints, and positions at a distance along a route.
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// EarthRadius is the mean radius of the Earth in meters.
+const EarthRadius = 6371008.8
+
+// Point is a WGS 84 position in degrees, with the altitude in meters.
+type Point struct {
+	Lat, Lon, Alt float64
+}
+
+// Distance returns the great-circle distance between a and b in meters, ignoring altitude.
+func Distance(a, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat, dLon := lat2-lat1, radians(b.Lon-a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Bearing returns the initial bearing from a to b in degrees clockwise from north, in [0, 360).
+func Bearing(a, b Point) float64 {
+	lat1, lat2, dLon := radians(a.Lat), radians(b.Lat), radians(b.Lon-a.Lon)
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return math.Mod(degrees(math.Atan2(y, x))+360, 360)
+}
+
+// Offset returns p moved north and east by the given meters. It is accurate for
+// offsets that are small compared to the Earth, such as GPS noise.
+func Offset(p Point, north, east float64) Point {
+	p.Lat += degrees(north / EarthRadius)
+	p.Lon += degrees(east / (EarthRadius * math.Cos(radians(p.Lat))))
+	return p
+}
+
+// radians converts degrees to radians.
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
+
+// degrees converts radians to degrees.
+func degrees(rad float64) float64 { return rad * 180 / math.Pi }
+
+// Route is a polyline of at least two points.
+type Route struct {
+	Points []Point
+	// cumulative[i] is the distance along the route from the first point to point i.
+	cumulative []float64
+}
+
+// NewRoute returns the route through points.
+func NewRoute(points []Point) (*Route, error) {
+	if len(points) < 2 {
+		return nil, errors.New("a route needs at least two points")
+	}
+	cumulative := make([]float64, len(points))
+	for i := 1; i < len(points); i++ {
+		cumulative[i] = cumulative[i-1] + Distance(points[i-1], points[i])
+	}
+	return &Route{Points: points, cumulative: cumulative}, nil
+}
+
+// Length returns the length of the route in meters.
+func (r *Route) Length() float64 {
+	return r.cumulative[len(r.cumulative)-1]
+}
+
+// At returns the position at distance meters along the route, clamped to its ends, and
+// the heading of the segment it lies on. Latitude, longitude and altitude are linearly
+// interpolated between the points of the segment.
+func (r *Route) At(distance float64) (Point, float64) {
+	distance = math.Max(0, math.Min(distance, r.Length()))
+
+	// The segment [i-1, i] holding distance, skipping zero-length segments.
+	i := sort.SearchFloat64s(r.cumulative, distance)
+	i = max(1, min(i, len(r.Points)-1))
+	for i < len(r.Points)-1 && r.cumulative[i] == r.cumulative[i-1] {
+		i++
+	}
+	a, b := r.Points[i-1], r.Points[i]
+	heading := Bearing(a, b)
+
+	span := r.cumulative[i] - r.cumulative[i-1]
+	if span == 0 {
+		return b, heading
+	}
+	f := (distance - r.cumulative[i-1]) / span
+	return Point{
+		Lat: a.Lat + f*(b.Lat-a.Lat),
+		Lon: a.Lon + f*(b.Lon-a.Lon),
+		Alt: a.Alt + f*(b.Alt-a.Alt),
+	}, heading
+}
+
+// RandomRoute returns a closed route through n random waypoints within radius meters
+// of center, ending back at the first waypoint. rnd returns uniform values in [0, 1).
+func RandomRoute(center Point, radius float64, n int, rnd func() float64) (*Route, error) {
+	points := make([]Point, 0, n+1)
+	for range n {
+		// The square root spreads waypoints uniformly over the disc.
+		r, theta := radius*math.Sqrt(rnd()), 2*math.Pi*rnd()
+		points = append(points, Offset(center, r*math.Cos(theta), r*math.Sin(theta)))
+	}
+	if n > 0 {
+		points = append(points, points[0])
+	}
+	return NewRoute(points)
+}
+
+// LoadGeoJSON reads the route of the first LineString in a GeoJSON file. The file may
+// hold a LineString geometry, a Feature or a FeatureCollection.
+func LoadGeoJSON(path string) (*Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	points, err := parseGeoJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	route, err := NewRoute(points)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return route, nil
+}
+
+// geoJSON holds the members of the GeoJSON objects that can lead to a LineString.
+type geoJSON struct {
+	Type string `json:"type"`
+	// Coordinates is only decoded for a LineString; other geometries have other shapes.
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Features    []geoJSON       `json:"features"`
+}
+
+// parseGeoJSON returns the points of the first LineString in a GeoJSON document.
+func parseGeoJSON(data []byte) ([]Point, error) {
+	var doc geoJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("decoding GeoJSON: %w", err)
+	}
+	line := findLineString(&doc)
+	if line == nil {
+		return nil, errors.New("no LineString found")
+	}
+	var coordinates [][]float64
+	if err := json.Unmarshal(line.Coordinates, &coordinates); err != nil {
+		return nil, fmt.Errorf("decoding LineString coordinates: %w", err)
+	}
+
+	points := make([]Point, len(coordinates))
+	for i, c := range coordinates {
+		if len(c) < 2 {
+			return nil, fmt.Errorf("coordinate %d: want [lon, lat] or [lon, lat, alt]", i)
+		}
+		points[i] = Point{Lon: c[0], Lat: c[1]}
+		if len(c) > 2 {
+			points[i].Alt = c[2]
+		}
+	}
+	return points, nil
+}
+
+// findLineString returns the first LineString geometry in doc, or nil.
+func findLineString(doc *geoJSON) *geoJSON {
+	switch doc.Type {
+	case "LineString":
+		return doc
+	case "Feature":
+		if doc.Geometry != nil {
+			return findLineString(doc.Geometry)
+		}
+	case "FeatureCollection":
+		for i := range doc.Features {
+			if line := findLineString(&doc.Features[i]); line != nil {
+				return line
+			}
+		}
+	}
+	return nil
+}
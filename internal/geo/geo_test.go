@@ -0,0 +1,143 @@
+package geo
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// near reports whether a and b differ by at most tolerance.
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+// TestDistanceAndBearing tests great-circle distances and bearings between known points.
+func TestDistanceAndBearing(t *testing.T) {
+	origin := Point{}
+	east := Point{Lon: 1}
+	north := Point{Lat: 1}
+
+	// One degree of arc on the mean Earth radius.
+	if d := Distance(origin, east); !near(d, 111195, 1) {
+		t.Errorf("Expected one degree of longitude at the equator to be 111195 m, got %v", d)
+	}
+	if d := Distance(Point{Lat: 52.5200, Lon: 13.4050}, Point{Lat: 48.8566, Lon: 2.3522}); !near(d, 877500, 1000) {
+		t.Errorf("Expected Berlin to Paris to be about 877.5 km, got %v", d)
+	}
+
+	for _, tt := range []struct {
+		to   Point
+		want float64
+	}{{north, 0}, {east, 90}, {Point{Lat: -1}, 180}, {Point{Lon: -1}, 270}} {
+		if b := Bearing(origin, tt.to); !near(b, tt.want, 1e-9) {
+			t.Errorf("Expected bearing %v to %+v, got %v", tt.want, tt.to, b)
+		}
+	}
+
+	p := Offset(origin, 1000, 0)
+	if d := Distance(origin, p); !near(d, 1000, 1e-6) || p.Lon != 0 {
+		t.Errorf("Expected an offset 1000 m north, got %+v (%v m)", p, d)
+	}
+}
+
+// TestRouteAt tests positions and headings along a route, clamped to its ends.
+func TestRouteAt(t *testing.T) {
+	route, err := NewRoute([]Point{{Lat: 0, Lon: 0, Alt: 0}, {Lat: 0, Lon: 1, Alt: 100}, {Lat: 0, Lon: 1, Alt: 100}, {Lat: 1, Lon: 1, Alt: 0}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	leg := Distance(Point{}, Point{Lon: 1})
+	if !near(route.Length(), 2*leg, 1) {
+		t.Errorf("Expected length %v, got %v", 2*leg, route.Length())
+	}
+
+	tests := []struct {
+		distance      float64
+		lat, lon, alt float64
+		heading       float64
+	}{
+		{-10, 0, 0, 0, 90},
+		{leg / 2, 0, 0.5, 50, 90},
+		{1.5 * leg, 0.5, 1, 50, 0}, // past the zero-length segment
+		{3 * leg, 1, 1, 0, 0},
+	}
+	for _, tt := range tests {
+		p, heading := route.At(tt.distance)
+		if !near(p.Lat, tt.lat, 1e-3) || !near(p.Lon, tt.lon, 1e-3) || !near(p.Alt, tt.alt, 0.1) || !near(heading, tt.heading, 0.1) {
+			t.Errorf("At %v: expected %v,%v,%v heading %v, got %+v heading %v", tt.distance, tt.lat, tt.lon, tt.alt, tt.heading, p, heading)
+		}
+	}
+
+	if _, err := NewRoute([]Point{{}}); err == nil {
+		t.Errorf("Expected a single point to be rejected")
+	}
+}
+
+// TestRandomRoute tests that random waypoints lie within the radius and the route is closed.
+func TestRandomRoute(t *testing.T) {
+	center := Point{Lat: 52.52, Lon: 13.405, Alt: 34}
+	values := []float64{1, 0, 0.25, 0.25, 0.5, 0.5}
+	rnd := func() float64 {
+		v := values[0]
+		values = append(values[1:], v)
+		return v
+	}
+
+	route, err := RandomRoute(center, 1000, 3, rnd)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(route.Points) != 4 || route.Points[0] != route.Points[3] {
+		t.Fatalf("Expected a closed route of 3 waypoints, got %+v", route.Points)
+	}
+	if d := Distance(center, route.Points[0]); !near(d, 1000, 0.01) || !near(Bearing(center, route.Points[0]), 0, 0.01) {
+		t.Errorf("Expected the first waypoint 1000 m north, got %v m at %v", d, Bearing(center, route.Points[0]))
+	}
+	for i, p := range route.Points {
+		if Distance(center, p) > 1000.01 || p.Alt != center.Alt {
+			t.Errorf("Waypoint %d outside the radius: %+v", i, p)
+		}
+	}
+}
+
+// TestLoadGeoJSON tests loading a LineString from a geometry, a Feature and a FeatureCollection.
+func TestLoadGeoJSON(t *testing.T) {
+	docs := map[string]string{
+		"geometry": `{"type": "LineString", "coordinates": [[13.4, 52.5, 30], [13.5, 52.6, 40]]}`,
+		"feature":  `{"type": "Feature", "properties": {}, "geometry": {"type": "LineString", "coordinates": [[13.4, 52.5, 30], [13.5, 52.6, 40]]}}`,
+		"collection": `{"type": "FeatureCollection", "features": [
+			{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}},
+			{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[13.4, 52.5, 30], [13.5, 52.6, 40]]}}]}`,
+	}
+	dir := t.TempDir()
+	for name, doc := range docs {
+		path := filepath.Join(dir, name+".geojson")
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		route, err := LoadGeoJSON(path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if want := (Point{Lat: 52.6, Lon: 13.5, Alt: 40}); len(route.Points) != 2 || route.Points[1] != want {
+			t.Errorf("%s: expected the end point %+v, got %+v", name, want, route.Points)
+		}
+	}
+
+	for name, doc := range map[string]string{
+		"point":        `{"type": "Point", "coordinates": [13.4, 52.5]}`,
+		"short":        `{"type": "LineString", "coordinates": [[13.4, 52.5]]}`,
+		"no latitude":  `{"type": "LineString", "coordinates": [[13.4], [13.5]]}`,
+		"invalid JSON": `{`,
+	} {
+		path := filepath.Join(dir, "bad.geojson")
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		if _, err := LoadGeoJSON(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Package replay loads recorded sensor values so they can be played back as a sensor source.
+// Recordings are CSV or newline-delimited JSON files with a timestamp and a value per
+// sample, such as the files written by the export subcommand.
+package replay
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported recording formats.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Sample is one recorded value. A sample with an Error replays a failed reading.
+type Sample struct {
+	Timestamp time.Time
+	Value     float64
+	Error     string
+}
+
+// Recording is a non-empty series of samples ordered by timestamp.
+type Recording struct {
+	Samples []Sample
+}
+
+// Load reads the recording at path. An empty format is guessed from the file
+// extension: ".ndjson" and ".jsonl" are NDJSON, anything else is CSV.
+func Load(path, format string) (*Recording, error) {
+	if format == "" {
+		format = FormatFromPath(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []Sample
+	switch format {
+	case FormatCSV:
+		samples, err = readCSV(f)
+	case FormatNDJSON:
+		samples, err = readNDJSON(f)
+	default:
+		return nil, fmt.Errorf("unknown recording format %q (want csv or ndjson)", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("reading %s: no samples", path)
+	}
+
+	slices.SortStableFunc(samples, func(a, b Sample) int { return a.Timestamp.Compare(b.Timestamp) })
+	return &Recording{Samples: samples}, nil
+}
+
+// FormatFromPath guesses the recording format from the file extension of path.
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	}
+	return FormatCSV
+}
+
+// Duration returns the time between the first and the last sample.
+func (r *Recording) Duration() time.Duration {
+	return r.Samples[len(r.Samples)-1].Timestamp.Sub(r.Samples[0].Timestamp)
+}
+
+// Offset returns the time of sample i relative to the first sample.
+func (r *Recording) Offset(i int) time.Duration {
+	return r.Samples[i].Timestamp.Sub(r.Samples[0].Timestamp)
+}
+
+// At returns the sample at offset from the first sample, linearly interpolating
+// the value between the samples around it. Offsets outside the recording are
+// clamped to its ends. If either of the two samples failed, the earlier one is
+// held as is, so recorded faults are replayed rather than smoothed over.
+func (r *Recording) At(offset time.Duration) Sample {
+	at := r.Samples[0].Timestamp.Add(offset)
+
+	// Index of the first sample after at.
+	next, _ := slices.BinarySearchFunc(r.Samples, at, func(s Sample, t time.Time) int {
+		if s.Timestamp.After(t) {
+			return 1
+		}
+		return -1
+	})
+	switch {
+	case next == 0:
+		return r.Samples[0]
+	case next == len(r.Samples):
+		return r.Samples[len(r.Samples)-1]
+	}
+
+	prev, following := r.Samples[next-1], r.Samples[next]
+	if prev.Error != "" || following.Error != "" {
+		prev.Timestamp = at
+		return prev
+	}
+	frac := float64(at.Sub(prev.Timestamp)) / float64(following.Timestamp.Sub(prev.Timestamp))
+	return Sample{Timestamp: at, Value: prev.Value + frac*(following.Value-prev.Value)}
+}
+
+// parseTimestamp accepts RFC 3339 times and Unix times in (fractional) seconds.
+func parseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))).UTC(), nil
+}
+
+// readCSV reads a CSV recording. The header names the columns; "timestamp" and
+// "value" are required, and a non-empty "error" column marks failed samples.
+func readCSV(r io.Reader) ([]Sample, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	tsCol, ok := columns["timestamp"]
+	if !ok {
+		return nil, errors.New("header: missing timestamp column")
+	}
+	valueCol, ok := columns["value"]
+	if !ok {
+		return nil, errors.New("header: missing value column")
+	}
+	errorCol, hasErrors := columns["error"]
+
+	var samples []Sample
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tsCol >= len(record) || valueCol >= len(record) {
+			return nil, fmt.Errorf("line %d: too few fields", line)
+		}
+
+		var sample Sample
+		if sample.Timestamp, err = parseTimestamp(record[tsCol]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if hasErrors && errorCol < len(record) {
+			sample.Error = record[errorCol]
+		}
+		if sample.Error == "" {
+			if sample.Value, err = strconv.ParseFloat(strings.TrimSpace(record[valueCol]), 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid value %q", line, record[valueCol])
+			}
+		}
+		samples = append(samples, sample)
+	}
+}
+
+// readNDJSON reads a recording with one JSON object per line. The timestamp is an
+// RFC 3339 string or Unix seconds; a non-empty "error" marks failed samples.
+func readNDJSON(r io.Reader) ([]Sample, error) {
+	var samples []Sample
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var obj struct {
+			Timestamp json.RawMessage `json:"timestamp"`
+			Value     float64         `json:"value"`
+			Error     string          `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &obj); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if obj.Timestamp == nil {
+			return nil, fmt.Errorf("line %d: missing timestamp", line)
+		}
+
+		// Accept both "2025-01-01T00:00:00Z" and 1735689600.
+		ts := strings.Trim(string(obj.Timestamp), `"`)
+		timestamp, err := parseTimestamp(ts)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		samples = append(samples, Sample{Timestamp: timestamp, Value: obj.Value, Error: obj.Error})
+	}
+	return samples, scanner.Err()
+}
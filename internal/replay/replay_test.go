@@ -0,0 +1,114 @@
+package replay
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFile writes content to a file in a temporary directory and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Error writing %s: %v", name, err)
+	}
+	return path
+}
+
+// TestLoadCSV tests loading an exported CSV file, with extra columns, faults and unsorted rows.
+func TestLoadCSV(t *testing.T) {
+	path := writeFile(t, "rec.csv", `timestamp,sensor_id,value,unit,fault,error
+2025-01-01T00:00:10Z,temp-01,30,°C,false,
+2025-01-01T00:00:00Z,temp-01,20,°C,false,
+2025-01-01T00:00:05Z,temp-01,0,°C,true,sensor communication error
+`)
+
+	rec, err := Load(path, "")
+	if err != nil {
+		t.Fatalf("Error loading recording: %v", err)
+	}
+	if len(rec.Samples) != 3 {
+		t.Fatalf("Expected 3 samples, got %d", len(rec.Samples))
+	}
+	if rec.Samples[0].Value != 20 || rec.Samples[2].Value != 30 {
+		t.Errorf("Expected samples sorted by timestamp, got %+v", rec.Samples)
+	}
+	if rec.Samples[1].Error == "" {
+		t.Errorf("Expected the second sample to be a fault")
+	}
+	if rec.Duration() != 10*time.Second {
+		t.Errorf("Expected a 10s recording, got %v", rec.Duration())
+	}
+}
+
+// TestLoadNDJSON tests loading NDJSON with RFC 3339 and Unix timestamps.
+func TestLoadNDJSON(t *testing.T) {
+	path := writeFile(t, "rec.ndjson", `{"timestamp": "2025-01-01T00:00:00Z", "value": 1.5}
+
+{"timestamp": 1735689602.5, "value": 2.5}
+`)
+
+	rec, err := Load(path, "")
+	if err != nil {
+		t.Fatalf("Error loading recording: %v", err)
+	}
+	if len(rec.Samples) != 2 || rec.Samples[1].Value != 2.5 {
+		t.Fatalf("Unexpected samples: %+v", rec.Samples)
+	}
+	if rec.Duration() != 2500*time.Millisecond {
+		t.Errorf("Expected a 2.5s recording, got %v", rec.Duration())
+	}
+}
+
+// TestLoadErrors tests that malformed recordings are rejected.
+func TestLoadErrors(t *testing.T) {
+	tests := map[string]string{
+		"no-value.csv":   "timestamp,temp\n2025-01-01T00:00:00Z,1\n",
+		"bad-time.csv":   "timestamp,value\nyesterday,1\n",
+		"bad-value.csv":  "timestamp,value\n2025-01-01T00:00:00Z,warm\n",
+		"empty.csv":      "timestamp,value\n",
+		"no-time.ndjson": `{"value": 1}`,
+	}
+	for name, content := range tests {
+		if _, err := Load(writeFile(t, name, content), ""); err == nil {
+			t.Errorf("Expected an error loading %s", name)
+		}
+	}
+}
+
+// TestAt tests interpolation between samples and clamping at the ends.
+func TestAt(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rec := &Recording{Samples: []Sample{
+		{Timestamp: start, Value: 10},
+		{Timestamp: start.Add(10 * time.Second), Value: 20},
+		{Timestamp: start.Add(20 * time.Second), Error: "sensor communication error"},
+		{Timestamp: start.Add(30 * time.Second), Value: 40},
+	}}
+
+	tests := []struct {
+		offset time.Duration
+		value  float64
+		failed bool
+	}{
+		{-time.Second, 10, false},
+		{0, 10, false},
+		{2500 * time.Millisecond, 12.5, false},
+		{10 * time.Second, 20, false},
+		{15 * time.Second, 20, false}, // next sample failed: hold the last value
+		{25 * time.Second, 0, true},   // previous sample failed
+		{time.Minute, 40, false},
+	}
+	for _, tt := range tests {
+		sample := rec.At(tt.offset)
+		if sample.Value != tt.value || (sample.Error != "") != tt.failed {
+			t.Errorf("At(%v): expected value %v (failed %v), got %+v", tt.offset, tt.value, tt.failed, sample)
+		}
+	}
+
+	if got := rec.At(5 * time.Second).Timestamp; !got.Equal(start.Add(5 * time.Second)) {
+		t.Errorf("Expected the interpolated timestamp, got %v", got)
+	}
+}
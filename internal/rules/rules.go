@@ -0,0 +1,248 @@
+// Package rules runs the edge rules of a device, simulating the logic an edge gateway runs
+// next to its sensors. A rule watches the latest readings of the sensors its condition
+// refers to; once the condition has held for the rule's window, its actions publish an
+// event, change the configuration of a sensor or command an actuator, and when the
+// condition stops holding, its on_clear actions undo them.
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/expr"
+	"iot-device-simulator/internal/sensor"
+	"iot-device-simulator/internal/telemetry"
+)
+
+var tracer = otel.Tracer("iot-device-simulator/internal/rules")
+
+// Event states.
+const (
+	StateTriggered = "triggered"
+	StateCleared   = "cleared"
+)
+
+// Event is published by the publish actions of a rule.
+type Event struct {
+	DeviceID  string `json:"device_id"`
+	Rule      string `json:"rule"`
+	Condition string `json:"condition"`
+	State     string `json:"state"`
+	Message   string `json:"message,omitempty"`
+	// Values are the latest values of the sensors and fields the condition names,
+	// leaving out patterns.
+	Values    map[string]float64 `json:"values,omitempty"`
+	Timestamp time.Time          `json:"timestamp"`
+}
+
+// Subject returns the default subject of the events of a rule.
+func Subject(deviceID, ruleID string) string {
+	return fmt.Sprintf("iot.%s.rules.%s", deviceID, ruleID)
+}
+
+// Device carries out the actions of rules that act on the device's sensors.
+type Device interface {
+	// ConfigureSensor applies the changes of action to its sensor.
+	ConfigureSensor(ctx context.Context, action config.ConfigureAction) error
+	// SetActuator commands the actuator with the given ID into state.
+	SetActuator(ctx context.Context, sensorID, state string) error
+}
+
+// Engine runs the rules of a device.
+type Engine struct {
+	deviceID string
+	rules    []config.RuleConfig
+	nc       *nats.Conn
+	latest   *sensor.Latest
+	device   Device
+}
+
+// New returns an engine running rules against the latest readings of the device's
+// sensors, publishing events on nc and acting on device.
+func New(deviceID string, rules []config.RuleConfig, nc *nats.Conn, latest *sensor.Latest, device Device) *Engine {
+	return &Engine{
+		deviceID: deviceID,
+		rules:    rules,
+		nc:       nc,
+		latest:   latest,
+		device:   device,
+	}
+}
+
+// Start runs every rule in its own goroutine until ctx is canceled. Rules start armed:
+// a condition that already holds triggers once its window has passed.
+func (e *Engine) Start(ctx context.Context) {
+	for _, cfg := range e.rules {
+		cond, err := expr.Parse(cfg.Condition)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Ignoring rule %s with an invalid condition: %v", cfg.ID, err))
+			continue
+		}
+		go e.run(ctx, &rule{cfg: cfg, cond: cond})
+	}
+}
+
+// run evaluates r whenever one of its inputs reports, or its window passes, until ctx
+// is canceled.
+func (e *Engine) run(ctx context.Context, r *rule) {
+	changed, stop := e.latest.Watch(sensor.IsInput(r.cond, ""))
+	defer stop()
+	log.Printf("Starting rule %s on %s", r.cfg.ID, r.cond)
+
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	var due <-chan time.Time
+
+	evaluate := func(now time.Time) {
+		state, wait := r.update(r.holds(e.latest.Env()), now)
+		if timer != nil {
+			timer.Stop()
+		}
+		due = nil
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+		switch state {
+		case StateTriggered:
+			e.carryOut(ctx, r, state, r.cfg.Actions, now)
+		case StateCleared:
+			e.carryOut(ctx, r, state, r.cfg.OnClear, now)
+		}
+	}
+
+	evaluate(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping rule %s", r.cfg.ID)
+			return
+		case <-changed:
+			evaluate(time.Now())
+		case now := <-due:
+			evaluate(now)
+		}
+	}
+}
+
+// rule is the state of a running rule.
+type rule struct {
+	cfg  config.RuleConfig
+	cond *expr.Expr
+	// since is when the condition started to hold, or zero while it does not.
+	since time.Time
+	// triggered is set once the actions are carried out, until the condition stops holding.
+	triggered bool
+}
+
+// holds reports whether the condition of r holds in env. A condition that cannot be
+// evaluated, such as one whose inputs have not reported yet, does not hold.
+func (r *rule) holds(env expr.Env) bool {
+	v, err := r.cond.Eval(env)
+	return err == nil && v != 0 && !math.IsNaN(v)
+}
+
+// update advances r to now, when the condition holds or not. It returns StateTriggered
+// when the actions are due, StateCleared when the on_clear actions are, and otherwise an
+// empty state and, while the window is running, how long until it passes.
+func (r *rule) update(holds bool, now time.Time) (state string, wait time.Duration) {
+	if !holds {
+		r.since = time.Time{}
+		if r.triggered {
+			r.triggered = false
+			return StateCleared, 0
+		}
+		return "", 0
+	}
+
+	if r.since.IsZero() {
+		r.since = now
+	}
+	if r.triggered {
+		return "", 0
+	}
+	if wait := r.cfg.Window - now.Sub(r.since); wait > 0 {
+		return "", wait
+	}
+	r.triggered = true
+	return StateTriggered, 0
+}
+
+// carryOut carries out the actions of r when it is triggered or cleared, traced as a
+// "rules.triggered" or "rules.cleared" span. A failed action is logged and does not stop
+// the others.
+func (e *Engine) carryOut(ctx context.Context, r *rule, state string, actions []config.RuleAction, now time.Time) {
+	ctx, span := tracer.Start(ctx, "rules."+state,
+		trace.WithAttributes(
+			attribute.String("device.id", e.deviceID),
+			attribute.String("rule.id", r.cfg.ID),
+		))
+	defer span.End()
+	log.Printf("Rule %s %s: %s", r.cfg.ID, state, r.cond)
+
+	for _, action := range actions {
+		var err error
+		switch {
+		case action.Publish != nil:
+			err = e.publish(ctx, r, state, *action.Publish, now)
+		case action.Configure != nil:
+			err = e.device.ConfigureSensor(ctx, *action.Configure)
+		case action.SetActuator != nil:
+			err = e.device.SetActuator(ctx, action.SetActuator.Sensor, action.SetActuator.State)
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "action failed")
+			slog.Error(fmt.Sprintf("Error carrying out an action of rule %s: %v", r.cfg.ID, err))
+		}
+	}
+}
+
+// publish publishes the event of a publish action of r.
+func (e *Engine) publish(ctx context.Context, r *rule, state string, action config.PublishAction, now time.Time) error {
+	event := Event{
+		DeviceID:  e.deviceID,
+		Rule:      r.cfg.ID,
+		Condition: r.cfg.Condition,
+		State:     state,
+		Message:   action.Message,
+		Timestamp: now,
+	}
+	env := e.latest.Env()
+	for _, name := range r.cond.Refs() {
+		if expr.IsPattern(name) {
+			continue
+		}
+		if v, err := env.Value(name); err == nil {
+			if event.Values == nil {
+				event.Values = make(map[string]float64)
+			}
+			event.Values[name] = v
+		}
+	}
+
+	subject := action.Subject
+	if subject == "" {
+		subject = Subject(e.deviceID, r.cfg.ID)
+	}
+	data, _ := json.Marshal(event)
+	msg := &nats.Msg{Subject: subject, Data: data}
+	telemetry.Inject(ctx, msg)
+	return e.nc.PublishMsg(msg)
+}
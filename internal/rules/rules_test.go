@@ -0,0 +1,102 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/sensor"
+)
+
+// TestUpdate tests that a rule triggers once its condition has held for the window, and
+// clears and rearms when it stops holding.
+func TestUpdate(t *testing.T) {
+	r := &rule{cfg: config.RuleConfig{ID: "cooling", Window: 2 * time.Second}}
+	start := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+
+	var changes []string
+	for i, holds := range []bool{false, true, true, false, true, true, true, true, false, false} {
+		state, wait := r.update(holds, start.Add(time.Duration(i)*time.Second))
+		switch {
+		case state != "":
+			changes = append(changes, fmt.Sprintf("%s@%d", state, i))
+		case wait > 0:
+			changes = append(changes, fmt.Sprintf("wait %v@%d", wait, i))
+		}
+	}
+	got := strings.Join(changes, ", ")
+	want := "wait 2s@1, wait 1s@2, wait 2s@4, wait 1s@5, triggered@6, cleared@8"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+// recordingDevice records the actions carried out on it.
+type recordingDevice struct {
+	mu      sync.Mutex
+	actions []string
+}
+
+// ConfigureSensor records the configured sensor.
+func (d *recordingDevice) ConfigureSensor(ctx context.Context, action config.ConfigureAction) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.actions = append(d.actions, "configure "+action.Sensor)
+	return nil
+}
+
+// SetActuator records the commanded state.
+func (d *recordingDevice) SetActuator(ctx context.Context, sensorID, state string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.actions = append(d.actions, sensorID+" "+state)
+	return nil
+}
+
+// waitFor waits up to a second for the device to have recorded the given actions.
+func (d *recordingDevice) waitFor(t *testing.T, want ...string) {
+	t.Helper()
+	var got string
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		d.mu.Lock()
+		got = strings.Join(d.actions, ", ")
+		d.mu.Unlock()
+		if got == strings.Join(want, ", ") {
+			return
+		}
+	}
+	t.Fatalf("Expected actions %v, got %s", want, got)
+}
+
+// TestEngine tests that the engine carries out the actions of a rule as the readings of
+// its inputs arrive, and its on_clear actions when the condition stops holding.
+func TestEngine(t *testing.T) {
+	latest := sensor.NewLatest()
+	device := &recordingDevice{}
+	engine := New("device-001", []config.RuleConfig{{
+		ID:        "cooling",
+		Condition: "temp-01 > 30 && fan-01 == 0",
+		Window:    20 * time.Millisecond,
+		Actions: []config.RuleAction{
+			{SetActuator: &config.SetActuatorAction{Sensor: "fan-01", State: "on"}},
+			{Publish: &config.PublishAction{Message: "fan on"}}, // fails without a connection
+			{Configure: &config.ConfigureAction{Sensor: "temp-01", Frequency: time.Second}},
+		},
+		OnClear: []config.RuleAction{{SetActuator: &config.SetActuatorAction{Sensor: "fan-01", State: "off"}}},
+	}}, nil, latest, device)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	latest.Record(sensor.Reading{SensorID: "fan-01", Value: 0})
+	engine.Start(ctx)
+
+	latest.Record(sensor.Reading{SensorID: "temp-01", Value: 35})
+	device.waitFor(t, "fan-01 on", "configure temp-01")
+
+	latest.Record(sensor.Reading{SensorID: "fan-01", Value: 1, State: "on"})
+	device.waitFor(t, "fan-01 on", "configure temp-01", "fan-01 off")
+}
@@ -0,0 +1,74 @@
+package sensor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"iot-device-simulator/internal/alarm"
+	"iot-device-simulator/internal/telemetry"
+)
+
+// ActiveAlarms returns the active alarms of the sensor, sorted by name.
+func (s *Sensor) ActiveAlarms() []alarm.Alarm {
+	return s.alarms.Active()
+}
+
+// ListAlarms returns the alarms of the sensor that are not normal, sorted by name.
+func (s *Sensor) ListAlarms() []alarm.Alarm {
+	return s.alarms.List()
+}
+
+// AckAlarm acknowledges the named alarm of the sensor.
+func (s *Sensor) AckAlarm(name string) (alarm.Alarm, error) {
+	return s.alarms.Ack(name)
+}
+
+// ShelveAlarm suppresses the events of the named alarm of the sensor until the given time.
+func (s *Sensor) ShelveAlarm(name string, until time.Time) (alarm.Alarm, error) {
+	return s.alarms.Shelve(name, until)
+}
+
+// UnshelveAlarm ends the shelving of the named alarm of the sensor, or only the shelving
+// until expiry if it is not zero, and publishes the alarm again if it is active.
+func (s *Sensor) UnshelveAlarm(ctx context.Context, deviceID, name string, expiry time.Time) (alarm.Alarm, error) {
+	a, events, err := s.alarms.Unshelve(name, expiry, time.Now())
+	for _, event := range events {
+		s.publishAlarm(ctx, deviceID, event)
+	}
+	return a, err
+}
+
+// publishAlarm publishes an alarm event on alarm.Subject, traced as a "sensor.alarm" span.
+func (s *Sensor) publishAlarm(ctx context.Context, deviceID string, event alarm.Event) {
+	ctx, span := tracer.Start(ctx, "sensor.alarm",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("device.id", deviceID),
+			attribute.String("sensor.id", event.SensorID),
+			attribute.String("alarm.name", event.Alarm),
+			attribute.String("alarm.state", event.State),
+		))
+	defer span.End()
+
+	event.DeviceID = deviceID
+	event.Tags = s.getTags()
+	log.Printf("Alarm %s of sensor %s %s at %v (limit %v)", event.Alarm, event.SensorID, event.State, event.Value, event.Limit)
+
+	data, _ := json.Marshal(event)
+	msg := &nats.Msg{Subject: alarm.Subject(deviceID, event.SensorID), Data: data}
+	telemetry.Inject(ctx, msg)
+	if err := s.nc.PublishMsg(msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+		slog.Error(fmt.Sprintf("Error publishing alarm %s of %s: %v", event.Alarm, event.SensorID, err))
+	}
+}
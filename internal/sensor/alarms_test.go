@@ -0,0 +1,39 @@
+package sensor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/config"
+)
+
+// TestPublishEvaluatesAlarms tests that published readings drive the alarms of the
+// sensor, that error readings are ignored, and that new alarm settings apply at once.
+func TestPublishEvaluatesAlarms(t *testing.T) {
+	sensor := New(config.SensorConfig{
+		ID:     "temp-01",
+		Type:   "temperature",
+		Alarms: []config.AlarmConfig{{Type: config.AlarmHigh, Limit: 30}},
+	}, nil, nil)
+	ctx := context.Background()
+	now := time.Now()
+
+	sensor.publish(ctx, Reading{SensorID: "temp-01", Value: 35, Timestamp: now}, "device-001")
+	if active := sensor.ActiveAlarms(); len(active) != 1 || active[0].Alarm != "high" {
+		t.Fatalf("Expected the high alarm to be active, got %+v", active)
+	}
+
+	sensor.publish(ctx, Reading{SensorID: "temp-01", Error: "sensor communication error", Timestamp: now}, "device-001")
+	if active := sensor.ActiveAlarms(); len(active) != 1 {
+		t.Errorf("Expected an error reading to leave the alarm active, got %+v", active)
+	}
+
+	cfg := sensor.GetConfig()
+	cfg.Alarms = []config.AlarmConfig{{Type: config.AlarmHigh, Limit: 40}}
+	sensor.ApplyConfig(cfg)
+	sensor.publish(ctx, Reading{SensorID: "temp-01", Value: 35, Timestamp: now}, "device-001")
+	if active := sensor.ActiveAlarms(); len(active) != 0 {
+		t.Errorf("Expected the alarm to clear below the new limit, got %+v", active)
+	}
+}
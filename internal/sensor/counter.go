@@ -0,0 +1,50 @@
+package sensor
+
+import (
+	"log"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// counterState is the running state of a counter sensor.
+type counterState struct {
+	total   float64
+	pending float64 // increase not yet published as a delta
+	rate    float64 // last rate, for random walks
+	at      time.Time
+}
+
+// advanceCounter integrates the rate of the counter sensor up to now, applying
+// resets and rollovers. The first call counts one frequency from the initial value.
+// The caller must hold s.mu.
+func (s *Sensor) advanceCounter(now time.Time) {
+	cc := s.config.Counter
+	c := s.counter
+	first := c == nil
+	if first {
+		c = &counterState{total: cc.Initial, at: now.Add(-s.config.Frequency)}
+		s.counter = c
+	}
+
+	c.rate = nextValue(cc.Rate, c.rate, !first, now)
+	increase := c.rate * now.Sub(c.at).Seconds()
+	c.at = now
+
+	if cc.ResetProbability > 0 && rand.Float64() < cc.ResetProbability {
+		log.Printf("Sensor %s counter reset at %v", s.config.ID, c.total)
+		c.total = 0
+	}
+	c.total = math.Mod(c.total+increase, cc.Rollover())
+	c.pending += increase
+}
+
+// counterReading fills reading with the counter total and, if configured, the
+// increase since the last published reading. The caller must hold s.mu.
+func (s *Sensor) counterReading(reading *Reading) {
+	reading.Value = s.counter.total
+	if s.config.Counter.Delta {
+		reading.Values = map[string]float64{"delta": s.counter.pending}
+	}
+	s.counter.pending = 0
+}
@@ -0,0 +1,118 @@
+package sensor
+
+import (
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/config"
+)
+
+// newCounter returns a counter sensor counting a constant rate per second.
+func newCounter(rate float64, counter config.CounterConfig) *Sensor {
+	counter.Rate = config.ValueConfig{Min: rate, Max: rate}
+	return New(config.SensorConfig{ID: "meter-01", Type: "energy", Frequency: time.Second, Unit: "Wh", Counter: &counter}, nil, &mockStorage{})
+}
+
+// TestCounterRollover tests that the total integrates the rate and wraps at the counter width,
+// while the delta keeps the true increase.
+func TestCounterRollover(t *testing.T) {
+	s := newCounter(10, config.CounterConfig{Initial: 5, Width: 4, Delta: true})
+	now := time.Now()
+
+	steps := []struct {
+		after time.Duration
+		total float64
+	}{
+		{0, 15},               // 5 + 10 for the first frequency
+		{time.Second, 9},      // 25 wraps at 16
+		{2 * time.Second, 13}, // 29 + 20 = 45, wrapped twice
+	}
+	for i, step := range steps {
+		now = now.Add(step.after)
+		s.advanceCounter(now)
+		var reading Reading
+		s.counterReading(&reading)
+		if reading.Value != step.total {
+			t.Errorf("Step %d: expected total %v, got %v", i, step.total, reading.Value)
+		}
+		if want := 10 * max(step.after.Seconds(), 1); reading.Values["delta"] != want {
+			t.Errorf("Step %d: expected delta %v, got %v", i, want, reading.Values["delta"])
+		}
+	}
+}
+
+// TestCounterDeltaAcrossErrors tests that increases counted while readings failed
+// are included in the next delta.
+func TestCounterDeltaAcrossErrors(t *testing.T) {
+	s := newCounter(1, config.CounterConfig{Delta: true})
+	now := time.Now()
+
+	s.advanceCounter(now)
+	s.advanceCounter(now.Add(time.Second)) // failed reading, not published
+	s.advanceCounter(now.Add(2 * time.Second))
+
+	var reading Reading
+	s.counterReading(&reading)
+	if reading.Value != 3 || reading.Values["delta"] != 3 {
+		t.Errorf("Expected total 3 and delta 3, got %v and %v", reading.Value, reading.Values["delta"])
+	}
+}
+
+// TestCounterReset tests that a reset counter restarts from zero.
+func TestCounterReset(t *testing.T) {
+	s := newCounter(2, config.CounterConfig{Initial: 100, ResetProbability: 1})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		s.advanceCounter(now.Add(time.Duration(i) * time.Second))
+		var reading Reading
+		s.counterReading(&reading)
+		if reading.Value != 2 || reading.Values != nil {
+			t.Errorf("Step %d: expected a reset total of 2 and no delta, got %v and %v", i, reading.Value, reading.Values)
+		}
+	}
+}
+
+// TestCounterApplyConfig tests that a changed counter config restarts the count from its
+// initial value, while other changes keep it.
+func TestCounterApplyConfig(t *testing.T) {
+	s := newCounter(1, config.CounterConfig{Initial: 10})
+	now := time.Now()
+	s.advanceCounter(now)
+
+	cfg := s.GetConfig()
+	cfg.Unit = "kWh"
+	s.ApplyConfig(cfg)
+	s.advanceCounter(now.Add(time.Second))
+	var reading Reading
+	s.counterReading(&reading)
+	if reading.Value != 12 {
+		t.Errorf("Expected the total to carry over as 12, got %v", reading.Value)
+	}
+
+	cfg.Counter = &config.CounterConfig{Initial: 100, Rate: cfg.Counter.Rate}
+	s.ApplyConfig(cfg)
+	s.advanceCounter(now.Add(2 * time.Second))
+	s.counterReading(&reading)
+	if reading.Value != 101 {
+		t.Errorf("Expected the total to restart as 101, got %v", reading.Value)
+	}
+}
+
+// TestGenerateCounter tests that generated counter readings never decrease without a width or resets.
+func TestGenerateCounter(t *testing.T) {
+	s := newCounter(0, config.CounterConfig{})
+	s.config.Counter.Rate = config.ValueConfig{Min: 0, Max: 5, Model: config.ModelRandomWalk}
+
+	prev := 0.0
+	for i := 0; i < 100; i++ {
+		reading := s.generateReading()
+		if reading.Error != "" {
+			continue
+		}
+		if reading.Value < prev {
+			t.Fatalf("Counter decreased from %v to %v", prev, reading.Value)
+		}
+		prev = reading.Value
+	}
+}
@@ -0,0 +1,245 @@
+package sensor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/telemetry"
+)
+
+// StateChange is the event published when a discrete sensor changes state.
+type StateChange struct {
+	DeviceID string `json:"device_id"`
+	SensorID string `json:"sensor_id"`
+	Type     string `json:"type"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	// Duration is how long the sensor was in From, as a duration string (e.g. "1m30s").
+	Duration  string            `json:"duration"`
+	Timestamp time.Time         `json:"timestamp"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// StateChangeSubject returns the subject of the state change events of a sensor.
+func StateChangeSubject(deviceID, sensorType, sensorID string) string {
+	return fmt.Sprintf("iot.%s.events.state.%s.%s", deviceID, sensorType, sensorID)
+}
+
+// SetState commands a discrete sensor, acting as an actuator, into state. The running
+// sensor enters the state and reports it, unless it is in it already; a command sent
+// while the sensor is stopped takes effect when it starts. It returns an error if the
+// sensor is not discrete, has no such state, or has not taken its previous command yet.
+func (s *Sensor) SetState(state string) error {
+	cfg := s.GetConfig()
+	switch {
+	case cfg.Discrete == nil:
+		return fmt.Errorf("sensor %s is not discrete", cfg.ID)
+	case !slices.Contains(cfg.Discrete.StateNames(), state):
+		return fmt.Errorf("sensor %s has no state %q", cfg.ID, state)
+	}
+
+	select {
+	case s.commands <- state:
+		return nil
+	default:
+		return fmt.Errorf("sensor %s has a command pending", cfg.ID)
+	}
+}
+
+// runDiscrete runs a discrete sensor until ctx is canceled. The model is stepped every
+// frequency, and commanded states are entered as they arrive; the state is reported when
+// the sensor starts, when it changes, and when it has not been reported for the keepalive
+// interval. Every change is also published as a StateChange event.
+func (s *Sensor) runDiscrete(ctx context.Context, deviceID string, cfg config.SensorConfig) {
+	m := newStateMachine(*cfg.Discrete, time.Now(), rand.Float64)
+	log.Printf("Starting discrete sensor %s in state %s, stepping every %v", cfg.ID, m.state, cfg.Frequency)
+
+	ticker := time.NewTicker(cfg.Frequency)
+	defer ticker.Stop()
+
+	s.publish(ctx, s.stateReading(m, m.since), deviceID)
+	reported := m.since
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping sensor %s", cfg.ID)
+			return
+		case <-s.updated:
+			ticker.Reset(s.GetConfig().Frequency)
+		case state := <-s.commands:
+			if state == m.state || !slices.Contains(m.states, state) {
+				continue
+			}
+			now := time.Now()
+			from, since := m.state, m.since
+			m.enter(state, now)
+			s.publish(ctx, s.stateReading(m, now), deviceID)
+			s.publishStateChange(ctx, deviceID, from, m.state, now.Sub(since), now)
+			reported = now
+		case now := <-ticker.C:
+			since := m.since
+			if from, changed := m.step(now); changed {
+				s.publish(ctx, s.stateReading(m, now), deviceID)
+				s.publishStateChange(ctx, deviceID, from, m.state, now.Sub(since), now)
+				reported = now
+			} else if m.cfg.Keepalive > 0 && now.Sub(reported) >= m.cfg.Keepalive {
+				s.publish(ctx, s.stateReading(m, now), deviceID)
+				reported = now
+			}
+		}
+	}
+}
+
+// stateReading returns a reading of the current state of m.
+func (s *Sensor) stateReading(m *stateMachine, now time.Time) Reading {
+	cfg := s.GetConfig()
+	return Reading{
+		SensorID:  cfg.ID,
+		Type:      cfg.Type,
+		Value:     float64(slices.Index(m.states, m.state)),
+		State:     m.state,
+		Unit:      cfg.Unit,
+		Timestamp: now,
+	}
+}
+
+// publishStateChange publishes a StateChange event, traced as a "sensor.state_change" span.
+func (s *Sensor) publishStateChange(ctx context.Context, deviceID, from, to string, duration time.Duration, now time.Time) {
+	cfg := s.GetConfig()
+	ctx, span := tracer.Start(ctx, "sensor.state_change",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("device.id", deviceID),
+			attribute.String("sensor.id", cfg.ID),
+			attribute.String("sensor.state", to),
+		))
+	defer span.End()
+
+	data, _ := json.Marshal(StateChange{
+		DeviceID:  deviceID,
+		SensorID:  cfg.ID,
+		Type:      cfg.Type,
+		From:      from,
+		To:        to,
+		Duration:  duration.String(),
+		Timestamp: now,
+		Tags:      s.getTags(),
+	})
+	msg := &nats.Msg{Subject: StateChangeSubject(deviceID, cfg.Type, cfg.ID), Data: data}
+	telemetry.Inject(ctx, msg)
+	if err := s.nc.PublishMsg(msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+		slog.Error(fmt.Sprintf("Error publishing state change of %s: %v", cfg.ID, err))
+	}
+}
+
+// stateMachine steps the Markov-chain or dwell-time model of a discrete sensor.
+type stateMachine struct {
+	cfg    config.DiscreteConfig
+	states []string
+	rnd    func() float64 // uniform in [0, 1)
+
+	state string
+	since time.Time // when state was entered
+	until time.Time // when state ends, with the dwell model
+}
+
+// newStateMachine returns a state machine in the initial state, entered at now.
+func newStateMachine(cfg config.DiscreteConfig, now time.Time, rnd func() float64) *stateMachine {
+	m := &stateMachine{cfg: cfg, states: cfg.StateNames(), rnd: rnd}
+	m.enter(cfg.InitialState(), now)
+	return m
+}
+
+// enter moves to state at now, drawing how long it lasts with the dwell model.
+func (m *stateMachine) enter(state string, now time.Time) {
+	m.state, m.since = state, now
+	if m.cfg.Model == config.ModelDwell {
+		d := m.cfg.Dwell[state]
+		m.until = now.Add(d.Min + time.Duration(m.rnd()*float64(d.Max-d.Min)))
+	}
+}
+
+// step advances the model to now. It returns the previous state and whether it changed.
+func (m *stateMachine) step(now time.Time) (from string, changed bool) {
+	var next string
+	if m.cfg.Model == config.ModelDwell {
+		if now.Before(m.until) {
+			return m.state, false
+		}
+		next = m.nextAfterDwell()
+	} else {
+		next = m.nextMarkov()
+	}
+	if next == "" || next == m.state {
+		return m.state, false
+	}
+
+	from = m.state
+	m.enter(next, now)
+	return from, true
+}
+
+// nextMarkov draws the next state from the transition probabilities of the current
+// state. It returns an empty string to stay in the current state.
+func (m *stateMachine) nextMarkov() string {
+	r := m.rnd()
+	for _, to := range m.states {
+		p := m.cfg.Transitions[m.state][to]
+		if to == m.state || p <= 0 {
+			continue
+		}
+		if r < p {
+			return to
+		}
+		r -= p
+	}
+	return ""
+}
+
+// nextAfterDwell chooses the state that follows an expired dwell time: weighted by the
+// transitions of the current state if it has any, uniformly among the others otherwise.
+func (m *stateMachine) nextAfterDwell() string {
+	weights := m.cfg.Transitions[m.state]
+	weight := func(to string) float64 {
+		if len(weights) == 0 {
+			return 1
+		}
+		return weights[to]
+	}
+
+	var candidates []string
+	total := 0.0
+	for _, to := range m.states {
+		if to != m.state && weight(to) > 0 {
+			candidates = append(candidates, to)
+			total += weight(to)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	r := m.rnd() * total
+	for _, to := range candidates {
+		if r < weight(to) {
+			return to
+		}
+		r -= weight(to)
+	}
+	return candidates[len(candidates)-1] // rounding
+}
@@ -0,0 +1,174 @@
+package sensor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/config"
+)
+
+// sequence returns a random source that yields values in order, repeating the last one.
+func sequence(values ...float64) func() float64 {
+	return func() float64 {
+		v := values[0]
+		if len(values) > 1 {
+			values = values[1:]
+		}
+		return v
+	}
+}
+
+// TestStateMachineMarkov tests transitions drawn from the per-step probabilities.
+func TestStateMachineMarkov(t *testing.T) {
+	cfg := config.DiscreteConfig{Transitions: map[string]map[string]float64{
+		"false": {"true": 0.3},
+		"true":  {"false": 0.5},
+	}}
+	now := time.Now()
+	m := newStateMachine(cfg, now, sequence(0.5, 0.1, 0.7, 0.4))
+
+	steps := []struct {
+		state   string
+		changed bool
+	}{
+		{"false", false}, // 0.5 >= 0.3
+		{"true", true},   // 0.1 < 0.3
+		{"true", false},  // 0.7 >= 0.5
+		{"false", true},  // 0.4 < 0.5
+	}
+	for i, want := range steps {
+		now = now.Add(time.Second)
+		from, changed := m.step(now)
+		if m.state != want.state || changed != want.changed {
+			t.Errorf("Step %d: expected %s (changed %v), got %s (changed %v, from %s)", i, want.state, want.changed, m.state, changed, from)
+		}
+	}
+	if !m.since.Equal(now) {
+		t.Errorf("Expected the last state to be entered at %v, got %v", now, m.since)
+	}
+}
+
+// TestStateMachineDwell tests that states last their dwell time and are followed by weighted or uniform picks.
+func TestStateMachineDwell(t *testing.T) {
+	cfg := config.DiscreteConfig{
+		Kind:   config.KindEnum,
+		States: []string{"idle", "running", "fault"},
+		Model:  config.ModelDwell,
+		Dwell: map[string]config.DwellConfig{
+			"idle":    {Min: 10 * time.Second, Max: 20 * time.Second},
+			"running": {Min: time.Minute, Max: time.Minute},
+			"fault":   {Min: time.Second, Max: time.Second},
+		},
+		Transitions: map[string]map[string]float64{"running": {"fault": 1}},
+	}
+	start := time.Now()
+	m := newStateMachine(cfg, start, sequence(0.5, 0))
+
+	// idle lasts 10s + 0.5*10s; then running and fault are equally likely and 0 picks running.
+	if _, changed := m.step(start.Add(14 * time.Second)); changed {
+		t.Errorf("Expected idle to last 15s, left it for %s", m.state)
+	}
+	if from, changed := m.step(start.Add(15 * time.Second)); !changed || from != "idle" || m.state != "running" {
+		t.Errorf("Expected idle -> running after 15s, got %s -> %s", from, m.state)
+	}
+	// running only moves to fault.
+	if from, changed := m.step(start.Add(75 * time.Second)); !changed || from != "running" || m.state != "fault" {
+		t.Errorf("Expected running -> fault after a minute, got %s -> %s", from, m.state)
+	}
+}
+
+// TestRunDiscrete tests that a discrete sensor reports its initial state and every change.
+func TestRunDiscrete(t *testing.T) {
+	store := &recordingStorage{}
+	sensor := New(config.SensorConfig{
+		ID:        "door-01",
+		Type:      "door",
+		Enabled:   true,
+		Frequency: time.Millisecond,
+		Discrete: &config.DiscreteConfig{
+			States: []string{"closed", "open"},
+			Model:  config.ModelDwell,
+			Dwell: map[string]config.DwellConfig{
+				"closed": {Min: 10 * time.Millisecond, Max: 10 * time.Millisecond},
+				"open":   {Min: 10 * time.Millisecond, Max: 10 * time.Millisecond},
+			},
+		},
+	}, nil, store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	sensor.StartSensor(ctx, "device-001")
+
+	if len(store.readings) < 3 {
+		t.Fatalf("Expected the initial state and at least 2 changes, got %d readings", len(store.readings))
+	}
+	for i, reading := range store.readings {
+		want, value := "closed", 0.0
+		if i%2 == 1 {
+			want, value = "open", 1
+		}
+		if reading.State != want || reading.Value != value {
+			t.Errorf("Reading %d: expected %s (%v), got %s (%v)", i, want, value, reading.State, reading.Value)
+		}
+	}
+}
+
+// TestRunDiscreteKeepalive tests that an unchanged state is republished every keepalive.
+func TestRunDiscreteKeepalive(t *testing.T) {
+	store := &recordingStorage{}
+	sensor := New(config.SensorConfig{
+		ID:        "alarm-01",
+		Type:      "alarm",
+		Enabled:   true,
+		Frequency: time.Millisecond,
+		Discrete:  &config.DiscreteConfig{Keepalive: 20 * time.Millisecond},
+	}, nil, store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	sensor.StartSensor(ctx, "device-001")
+
+	if len(store.readings) < 2 {
+		t.Fatalf("Expected keepalive readings, got %d", len(store.readings))
+	}
+	for _, reading := range store.readings {
+		if reading.State != "false" {
+			t.Errorf("Expected the state to stay false, got %s", reading.State)
+		}
+	}
+}
+
+// TestSetState tests that a discrete sensor enters a commanded state, and that invalid
+// commands are rejected.
+func TestSetState(t *testing.T) {
+	store := &recordingStorage{}
+	sensor := New(config.SensorConfig{
+		ID:        "fan-01",
+		Type:      "fan",
+		Enabled:   true,
+		Frequency: time.Millisecond,
+		Discrete:  &config.DiscreteConfig{States: []string{"off", "on"}},
+	}, nil, store)
+
+	if err := sensor.SetState("fast"); err == nil {
+		t.Errorf("Expected an unknown state to be rejected")
+	}
+	if err := sensor.SetState("on"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := sensor.SetState("off"); err == nil {
+		t.Errorf("Expected a second command to be rejected while the first is pending")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sensor.StartSensor(ctx, "device-001")
+
+	if len(store.readings) != 2 || store.readings[0].State != "off" || store.readings[1].State != "on" {
+		t.Fatalf("Expected the initial state off and the commanded state on, got %+v", store.readings)
+	}
+	if err := New(config.SensorConfig{ID: "temp-01"}, nil, nil).SetState("on"); err == nil {
+		t.Errorf("Expected a sensor that is not discrete to be rejected")
+	}
+}
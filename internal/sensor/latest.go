@@ -0,0 +1,164 @@
+package sensor
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"iot-device-simulator/internal/expr"
+)
+
+// Latest holds the latest valid reading of every sensor of a device, the inputs of its
+// virtual sensors, and signals watchers when a watched sensor reports. It is safe for
+// concurrent use.
+type Latest struct {
+	mu       sync.RWMutex
+	readings map[string]Reading
+	// multiValue holds the sensors whose readings only carry named values.
+	multiValue map[string]bool
+	watchers   map[*watcher]struct{}
+}
+
+// watcher is signaled when a sensor it matches reports a reading.
+type watcher struct {
+	match func(sensorID string) bool
+	ch    chan struct{}
+}
+
+// NewLatest returns an empty Latest.
+func NewLatest() *Latest {
+	return &Latest{
+		readings:   make(map[string]Reading),
+		multiValue: make(map[string]bool),
+		watchers:   make(map[*watcher]struct{}),
+	}
+}
+
+// Record stores the reading of a single-value sensor as the latest of its sensor and
+// signals the watchers of that sensor. Error readings are ignored, so the previous
+// valid reading remains.
+func (l *Latest) Record(reading Reading) {
+	l.record(reading, false)
+}
+
+// record stores reading like Record. multiValue tells whether the sensor only reports
+// named values, so that its zero Value is not taken for a reading.
+func (l *Latest) record(reading Reading, multiValue bool) {
+	if reading.Error != "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.readings[reading.SensorID] = reading
+	if multiValue {
+		l.multiValue[reading.SensorID] = true
+	} else {
+		delete(l.multiValue, reading.SensorID)
+	}
+	for w := range l.watchers {
+		if w.match(reading.SensorID) {
+			select {
+			case w.ch <- struct{}{}:
+			default: // already signaled
+			}
+		}
+	}
+}
+
+// Forget drops the reading of a removed sensor.
+func (l *Latest) Forget(sensorID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.readings, sensorID)
+	delete(l.multiValue, sensorID)
+}
+
+// Get returns the latest valid reading of a sensor.
+func (l *Latest) Get(sensorID string) (Reading, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	reading, ok := l.readings[sensorID]
+	return reading, ok
+}
+
+// Watch returns a channel signaled when a sensor for which match returns true reports
+// a reading, and a function that stops watching. Signals are coalesced.
+func (l *Latest) Watch(match func(sensorID string) bool) (<-chan struct{}, func()) {
+	w := &watcher{match: match, ch: make(chan struct{}, 1)}
+	l.mu.Lock()
+	l.watchers[w] = struct{}{}
+	l.mu.Unlock()
+
+	return w.ch, func() {
+		l.mu.Lock()
+		delete(l.watchers, w)
+		l.mu.Unlock()
+	}
+}
+
+// Env returns an expression environment resolving names against the latest readings,
+// for expressions evaluated outside of a virtual sensor, such as the conditions of rules.
+func (l *Latest) Env() expr.Env {
+	return l.env("")
+}
+
+// env returns the expression environment of the virtual sensor called self, resolving
+// names against the latest readings.
+func (l *Latest) env(self string) latestEnv {
+	return latestEnv{l: l, self: self}
+}
+
+// latestEnv resolves the names of an expression to the latest readings of a device.
+type latestEnv struct {
+	l    *Latest
+	self string
+}
+
+// Value returns the value of a sensor, or of the field of a multi-value sensor
+// written "sensor.field".
+func (e latestEnv) Value(name string) (float64, error) {
+	e.l.mu.RLock()
+	reading, ok := e.l.readings[name]
+	multiValue := e.l.multiValue[name]
+	e.l.mu.RUnlock()
+	if ok {
+		if multiValue {
+			return 0, fmt.Errorf("sensor %s has several values, name one as %s.<field>", name, name)
+		}
+		return reading.Value, nil
+	}
+	if i := strings.LastIndex(name, "."); i > 0 {
+		if reading, ok := e.l.Get(name[:i]); ok {
+			v, ok := reading.Values[name[i+1:]]
+			if !ok {
+				return 0, fmt.Errorf("sensor %s has no field %s", name[:i], name[i+1:])
+			}
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("no valid reading of %s", name)
+}
+
+// Values returns the values of the single-value sensors matching pattern other than the
+// virtual sensor itself, in sensor ID order.
+func (e latestEnv) Values(pattern string) []float64 {
+	e.l.mu.RLock()
+	defer e.l.mu.RUnlock()
+
+	var ids []string
+	for id := range e.l.readings {
+		if ok, _ := path.Match(pattern, id); ok && id != e.self && !e.l.multiValue[id] {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	values := make([]float64, len(ids))
+	for i, id := range ids {
+		values[i] = e.l.readings[id].Value
+	}
+	return values
+}
@@ -0,0 +1,132 @@
+package sensor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/geo"
+)
+
+// LocationFields are the values of the readings of a location sensor: latitude and
+// longitude in degrees, altitude in meters, speed in meters per second, heading in
+// degrees clockwise from north, and the horizontal dilution of precision.
+var LocationFields = []string{"lat", "lon", "alt", "speed", "heading", "hdop"}
+
+// runLocation moves a location sensor along its route until ctx is canceled, reporting
+// its position every frequency. Location sensors lose their fix instead of failing to
+// communicate.
+func (s *Sensor) runLocation(ctx context.Context, deviceID string, cfg config.SensorConfig) {
+	route, err := loadRoute(*cfg.Location)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Sensor %s has no route: %v", cfg.ID, err))
+		return
+	}
+	t := newTracker(*cfg.Location, route, time.Now(), rand.Float64, rand.NormFloat64)
+	log.Printf("Starting location sensor %s on a %.0f m route at %g m/s, reporting every %v", cfg.ID, route.Length(), t.cfg.Speed, cfg.Frequency)
+
+	ticker := time.NewTicker(cfg.Frequency)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping sensor %s", cfg.ID)
+			return
+		case <-s.updated:
+			ticker.Reset(s.GetConfig().Frequency)
+		case now := <-ticker.C:
+			s.publish(ctx, s.locationReading(t, now), deviceID)
+		}
+	}
+}
+
+// loadRoute loads the GeoJSON route of lc, or draws its random waypoints.
+func loadRoute(lc config.LocationConfig) (*geo.Route, error) {
+	if lc.Route != "" {
+		return geo.LoadGeoJSON(lc.Route)
+	}
+	center := geo.Point{Lat: lc.Center.Lat, Lon: lc.Center.Lon, Alt: lc.Center.Alt}
+	return geo.RandomRoute(center, lc.Radius, lc.Waypoints, rand.Float64)
+}
+
+// locationReading returns a reading of the position of t at now.
+func (s *Sensor) locationReading(t *tracker, now time.Time) Reading {
+	cfg := s.GetConfig()
+	reading := Reading{
+		SensorID:  cfg.ID,
+		Type:      cfg.Type,
+		Unit:      cfg.Unit,
+		Timestamp: now,
+	}
+	if values, fix := t.sample(now); fix {
+		reading.Values = values
+	} else {
+		reading.Error = "no GPS fix"
+	}
+	return reading
+}
+
+// tracker moves along a route at a constant speed and samples noisy GPS fixes.
+type tracker struct {
+	cfg   config.LocationConfig
+	route *geo.Route
+	loop  bool
+	start time.Time
+	rnd   func() float64 // uniform in [0, 1)
+	norm  func() float64 // standard normal
+
+	hdop       float64
+	hasHDOP    bool
+	noFixUntil time.Time
+}
+
+// newTracker returns a tracker at the start of route at now.
+func newTracker(lc config.LocationConfig, route *geo.Route, now time.Time, rnd, norm func() float64) *tracker {
+	if lc.HDOP.Min == 0 && lc.HDOP.Max == 0 {
+		lc.HDOP.Min, lc.HDOP.Max = 1, 1
+	}
+	return &tracker{cfg: lc, route: route, loop: lc.Loop || lc.Route == "", start: now, rnd: rnd, norm: norm}
+}
+
+// sample returns the values of a fix at now, or false if there is no fix.
+func (t *tracker) sample(now time.Time) (map[string]float64, bool) {
+	if now.Before(t.noFixUntil) {
+		return nil, false
+	}
+	if t.cfg.FixLoss > 0 && t.rnd() < t.cfg.FixLoss {
+		d := t.cfg.FixLossDuration
+		t.noFixUntil = now.Add(d.Min + time.Duration(t.rnd()*float64(d.Max-d.Min)))
+		return nil, false
+	}
+
+	distance, speed := t.cfg.Speed*now.Sub(t.start).Seconds(), t.cfg.Speed
+	switch length := t.route.Length(); {
+	case t.loop && length > 0:
+		distance = math.Mod(distance, length)
+	case distance >= length:
+		speed = 0 // arrived
+	}
+	p, heading := t.route.At(distance)
+
+	t.hdop = nextValue(t.cfg.HDOP, t.hdop, t.hasHDOP, now)
+	t.hasHDOP = true
+	if sigma := t.cfg.Noise * t.hdop; sigma > 0 {
+		p = geo.Offset(p, sigma*t.norm(), sigma*t.norm())
+		p.Alt += 1.5 * sigma * t.norm()
+	}
+
+	return map[string]float64{
+		"lat":     p.Lat,
+		"lon":     p.Lon,
+		"alt":     p.Alt,
+		"speed":   speed,
+		"heading": heading,
+		"hdop":    t.hdop,
+	}, true
+}
@@ -0,0 +1,114 @@
+package sensor
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/geo"
+)
+
+// straightRoute returns a route 1000 m due east along the equator, and its end.
+func straightRoute(t *testing.T) (*geo.Route, geo.Point) {
+	end := geo.Offset(geo.Point{}, 0, 1000)
+	route, err := geo.NewRoute([]geo.Point{{}, end})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return route, end
+}
+
+// TestTrackerMoves tests that a tracker advances at its speed and stops at the end of its route.
+func TestTrackerMoves(t *testing.T) {
+	route, end := straightRoute(t)
+	start := time.Now()
+	tr := newTracker(config.LocationConfig{Route: "route.geojson", Speed: 10}, route, start, sequence(0), sequence(0))
+
+	values, fix := tr.sample(start.Add(50 * time.Second))
+	if !fix {
+		t.Fatalf("Expected a fix")
+	}
+	if d := geo.Distance(geo.Point{}, geo.Point{Lat: values["lat"], Lon: values["lon"]}); math.Abs(d-500) > 0.01 {
+		t.Errorf("Expected to be 500 m along the route, got %v m", d)
+	}
+	if values["speed"] != 10 || math.Abs(values["heading"]-90) > 1e-6 || values["hdop"] != 1 {
+		t.Errorf("Unexpected fix: %v", values)
+	}
+
+	values, _ = tr.sample(start.Add(200 * time.Second))
+	if values["lon"] != end.Lon || values["speed"] != 0 {
+		t.Errorf("Expected to stop at the end of the route, got %v", values)
+	}
+
+	tr = newTracker(config.LocationConfig{Route: "route.geojson", Speed: 10, Loop: true}, route, start, sequence(0), sequence(0))
+	values, _ = tr.sample(start.Add(125 * time.Second))
+	if d := geo.Distance(geo.Point{}, geo.Point{Lat: values["lat"], Lon: values["lon"]}); math.Abs(d-250) > 0.01 || values["speed"] != 10 {
+		t.Errorf("Expected a looping route to restart, got %v m at %v m/s", d, values["speed"])
+	}
+}
+
+// TestTrackerNoiseAndFixLoss tests that noise scales with the HDOP and that a lost fix lasts its duration.
+func TestTrackerNoiseAndFixLoss(t *testing.T) {
+	route, _ := straightRoute(t)
+	start := time.Now()
+	tr := newTracker(config.LocationConfig{
+		Route:           "route.geojson",
+		Noise:           2,
+		HDOP:            config.ValueConfig{Min: 1.5, Max: 1.5},
+		FixLoss:         0.1,
+		FixLossDuration: config.DwellConfig{Min: 10 * time.Second, Max: 20 * time.Second},
+	}, route, start, sequence(0.5, 0.05, 0.5), sequence(1))
+
+	// Standing at the start, one sigma north, east and up.
+	values, fix := tr.sample(start)
+	sigma := 2 * 1.5
+	if !fix || math.Abs(geo.Distance(geo.Point{}, geo.Point{Lat: values["lat"]})-sigma) > 1e-6 || math.Abs(values["alt"]-1.5*sigma) > 1e-9 {
+		t.Errorf("Expected an error of %v m north and %v m up, got %v", sigma, 1.5*sigma, values)
+	}
+
+	// 0.05 < 0.1 loses the fix for 10s + 0.5*10s.
+	if _, fix := tr.sample(start.Add(time.Second)); fix {
+		t.Errorf("Expected the fix to be lost")
+	}
+	if _, fix := tr.sample(start.Add(15 * time.Second)); fix {
+		t.Errorf("Expected the fix to stay lost for 15s")
+	}
+	if _, fix := tr.sample(start.Add(16 * time.Second)); !fix {
+		t.Errorf("Expected the fix to be back after 15s")
+	}
+}
+
+// TestRunLocation tests that a location sensor publishes positions along a GeoJSON route.
+func TestRunLocation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "route.geojson")
+	doc := `{"type": "LineString", "coordinates": [[13.4, 52.5, 30], [13.5, 52.5, 30]]}`
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatalf("Failed to write route: %v", err)
+	}
+
+	store := &recordingStorage{}
+	sensor := New(config.SensorConfig{
+		ID:        "gps-01",
+		Type:      "location",
+		Enabled:   true,
+		Frequency: 10 * time.Millisecond,
+		Location:  &config.LocationConfig{Route: path, Speed: 10},
+	}, nil, store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sensor.StartSensor(ctx, "device-001")
+
+	if len(store.readings) == 0 {
+		t.Fatalf("Expected location readings")
+	}
+	for _, reading := range store.readings {
+		if len(reading.Values) != len(LocationFields) || reading.Values["alt"] != 30 || reading.Values["lat"] != 52.5 {
+			t.Errorf("Unexpected reading: %+v", reading)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package sensor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/replay"
+)
+
+// runReplay plays back the recording of cfg.Replay until it ends or ctx is canceled.
+// Without interpolation every sample is emitted at its original relative time divided
+// by the speed; with interpolation a reading is emitted every frequency. A looping
+// recording restarts one frequency after its last sample, and recorded timestamps keep
+// increasing from one loop to the next.
+func (s *Sensor) runReplay(ctx context.Context, deviceID string, cfg config.SensorConfig) {
+	rc := *cfg.Replay
+	rec, err := replay.Load(rc.File, rc.Format)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Sensor %s cannot replay %s: %v", cfg.ID, rc.File, err))
+		return
+	}
+	speed := rc.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	log.Printf("Starting sensor %s replaying %d samples from %s at %gx speed", cfg.ID, len(rec.Samples), rc.File, speed)
+	p := &player{rec: rec, cfg: rc, speed: speed, gap: cfg.Frequency, start: time.Now()}
+	if rc.Interpolate {
+		s.replayInterpolated(ctx, deviceID, p)
+	} else {
+		s.replaySamples(ctx, deviceID, p)
+	}
+}
+
+// player maps wall-clock time onto a recording.
+type player struct {
+	rec   *replay.Recording
+	cfg   config.ReplayConfig
+	speed float64
+	gap   time.Duration // wall time between the last sample and the next loop
+	start time.Time     // wall time the playback started
+}
+
+// wall converts a recording offset into wall time at the playback speed.
+func (p *player) wall(offset time.Duration) time.Duration {
+	return time.Duration(float64(offset) / p.speed)
+}
+
+// cycle returns the wall time of one loop of the recording.
+func (p *player) cycle() time.Duration {
+	return p.wall(p.rec.Duration()) + p.gap
+}
+
+// reading turns a sample played in the given loop into a reading of s.
+func (p *player) reading(s *Sensor, sample replay.Sample, loop int) Reading {
+	cfg := s.GetConfig()
+	reading := Reading{
+		SensorID:  cfg.ID,
+		Type:      cfg.Type,
+		Value:     sample.Value,
+		Unit:      cfg.Unit,
+		Timestamp: sample.Timestamp.Add(time.Duration(loop) * (p.rec.Duration() + time.Duration(float64(p.gap)*p.speed))),
+		Error:     sample.Error,
+	}
+	if p.cfg.RebaseTimestamps {
+		reading.Timestamp = time.Now()
+	}
+	return reading
+}
+
+// replaySamples emits every sample at its original relative time, scaled by the speed.
+func (s *Sensor) replaySamples(ctx context.Context, deviceID string, p *player) {
+	id := s.GetConfig().ID
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for loop := 0; ; loop++ {
+		loopStart := p.start.Add(time.Duration(loop) * p.cycle())
+		for i, sample := range p.rec.Samples {
+			timer.Reset(time.Until(loopStart.Add(p.wall(p.rec.Offset(i)))))
+		wait:
+			for {
+				select {
+				case <-ctx.Done():
+					log.Printf("Stopping sensor %s", id)
+					return
+				case <-s.updated:
+					// Sample timing comes from the recording, not the frequency.
+					continue
+				case <-timer.C:
+					break wait
+				}
+			}
+			s.publish(ctx, p.reading(s, sample, loop), deviceID)
+		}
+
+		if !p.cfg.Loop {
+			log.Printf("Sensor %s finished replaying %s", id, p.cfg.File)
+			return
+		}
+	}
+}
+
+// replayInterpolated emits a reading every frequency, interpolated at the current
+// position in the recording.
+func (s *Sensor) replayInterpolated(ctx context.Context, deviceID string, p *player) {
+	id := s.GetConfig().ID
+	ticker := time.NewTicker(s.GetConfig().Frequency)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping sensor %s", id)
+			return
+		case <-s.updated:
+			ticker.Reset(s.GetConfig().Frequency)
+		case now := <-ticker.C:
+			elapsed := now.Sub(p.start)
+			loop := 0
+			if p.cfg.Loop {
+				loop = int(elapsed / p.cycle())
+				elapsed -= time.Duration(loop) * p.cycle()
+			}
+
+			offset := time.Duration(float64(elapsed) * p.speed)
+			if offset > p.rec.Duration() {
+				if !p.cfg.Loop {
+					log.Printf("Sensor %s finished replaying %s", id, p.cfg.File)
+					return
+				}
+				offset = p.rec.Duration() // in the gap before the next loop
+			}
+			s.publish(ctx, p.reading(s, p.rec.At(offset), loop), deviceID)
+		}
+	}
+}
@@ -6,30 +6,52 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"math/rand/v2"
+	"reflect"
 	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
+	"iot-device-simulator/internal/alarm"
 	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/correlation"
+	"iot-device-simulator/internal/telemetry"
 )
 
+var tracer = otel.Tracer("iot-device-simulator/internal/sensor")
+
 // Reading represents a single sensor reading.
 // Contains the measured value, timestamp, and possible error information.
+// DeviceID and Tags identify the device that produced it, so readings of
+// equally named sensors on different devices can share a store.
+// Multi-value sensors fill Values, one entry per configured field, and leave Value zero.
+// Discrete sensors set State and the index of the state in their configuration as Value.
+// Counter sensors report their total as Value and, optionally, their increase as Values["delta"].
+// Location sensors report their position as the LocationFields of Values.
+// Virtual sensors report the value of their expression.
 type Reading struct {
-	SensorID  string    `json:"sensor_id" bson:"sensor_id"`
-	Type      string    `json:"type" bson:"type"`
-	Value     float64   `json:"value" bson:"value"`
-	Unit      string    `json:"unit" bson:"unit"`
-	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
-	Error     string    `json:"error,omitempty" bson:"error,omitempty"`
+	DeviceID  string             `json:"device_id" bson:"device_id"`
+	SensorID  string             `json:"sensor_id" bson:"sensor_id"`
+	Type      string             `json:"type" bson:"type"`
+	Value     float64            `json:"value" bson:"value"`
+	Values    map[string]float64 `json:"values,omitempty" bson:"values,omitempty"`
+	State     string             `json:"state,omitempty" bson:"state,omitempty"`
+	Unit      string             `json:"unit" bson:"unit"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
+	Error     string             `json:"error,omitempty" bson:"error,omitempty"`
+	Tags      map[string]string  `json:"tags,omitempty" bson:"tags,omitempty"`
 }
 
 // Storage defines the interface for persistent storage of readings.
 // This allows decoupling the sensor from a specific database implementation.
 type Storage interface {
-	SaveReading(reading Reading) error
+	SaveReading(ctx context.Context, reading Reading) error
 }
 
 // Sensor simulates an IoT sensor. It is responsible for generating periodic readings,
@@ -40,34 +62,92 @@ type Sensor struct {
 	nc      *nats.Conn
 	storage Storage
 	mu      sync.RWMutex
+
+	// tags are the device labels attached to every reading.
+	tags map[string]string
+
+	// last holds the previous value of each field of a multi-value sensor, for random walks.
+	last map[string]float64
+	// counter is the running state of a counter sensor, created by its first reading
+	// and cleared when the sensor starts or its counter config changes.
+	counter *counterState
+
+	// latest collects the readings of the device's sensors for virtual sensors, if set.
+	latest *Latest
+
+	// group correlates the random values of the sensor with other sensors, if set;
+	// member is the index of the sensor in it.
+	group  *correlation.Group
+	member int
+
+	// alarms evaluates the threshold alarms of the sensor on its readings.
+	alarms *alarm.Evaluator
+
+	// updated is signaled when the frequency changes so the running loop resets its ticker.
+	updated chan struct{}
+	// commands carries the states a discrete sensor is commanded into to its running loop.
+	commands chan string
 }
 
 // New creates and returns a new Sensor instance.
 func New(sensorConfig config.SensorConfig, nc *nats.Conn, storage Storage) *Sensor {
-	return &Sensor{config: sensorConfig, nc: nc, storage: storage}
+	return &Sensor{
+		config:   sensorConfig,
+		nc:       nc,
+		storage:  storage,
+		alarms:   alarm.NewEvaluator(sensorConfig.ID, sensorConfig.Alarms),
+		updated:  make(chan struct{}, 1),
+		commands: make(chan string, 1),
+	}
 }
 
 // StartSensor starts the sensor lifecycle in a new goroutine.
-// Generates readings at the frequency specified in its configuration.
+// Generates readings at the frequency specified in its configuration,
+// plays back a recording with the replay generator, reports the state
+// of a discrete sensor when it changes, moves a location sensor along its route,
+// or computes a virtual sensor from the other sensors of its device.
 // Stops when the context is canceled.
 func (s *Sensor) StartSensor(ctx context.Context, deviceID string) {
-	if !s.config.Enabled {
-		log.Printf("Sensor %s is disabled, not starting", s.config.ID)
+	cfg := s.GetConfig()
+	if !cfg.Enabled {
+		log.Printf("Sensor %s is disabled, not starting", cfg.ID)
 		return
 	}
 
-	log.Printf("Starting sensor %s with frequency %v", s.config.ID, s.config.Frequency)
-	ticker := time.NewTicker(s.config.Frequency)
+	switch {
+	case cfg.Generator == config.GeneratorReplay:
+		s.runReplay(ctx, deviceID, cfg)
+		return
+	case cfg.Discrete != nil:
+		s.runDiscrete(ctx, deviceID, cfg)
+		return
+	case cfg.Location != nil:
+		s.runLocation(ctx, deviceID, cfg)
+		return
+	case cfg.Virtual != nil:
+		s.runVirtual(ctx, deviceID, cfg)
+		return
+	}
+
+	// A counter starts from its initial value on every start.
+	s.mu.Lock()
+	s.counter = nil
+	s.mu.Unlock()
+
+	log.Printf("Starting sensor %s with frequency %v", cfg.ID, cfg.Frequency)
+	ticker := time.NewTicker(cfg.Frequency)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("Stopping sensor %s", s.config.ID)
+			log.Printf("Stopping sensor %s", cfg.ID)
 			return
+		case <-s.updated:
+			ticker.Reset(s.GetConfig().Frequency)
 		case <-ticker.C:
 			reading := s.generateReading()
-			s.publish(reading, deviceID)
+			s.publish(ctx, reading, deviceID)
 		}
 	}
 }
@@ -75,8 +155,8 @@ func (s *Sensor) StartSensor(ctx context.Context, deviceID string) {
 // generateReading simulates a sensor reading based on its configuration.
 // Includes a 5% probability of simulating a communication error.
 func (s *Sensor) generateReading() Reading {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	reading := Reading{
 		SensorID:  s.config.ID,
@@ -85,31 +165,86 @@ func (s *Sensor) generateReading() Reading {
 		Timestamp: time.Now(),
 	}
 
+	// Counters keep counting while communication fails.
+	if s.config.Counter != nil {
+		s.advanceCounter(reading.Timestamp)
+	}
+
+	// Members of a correlation group take their share of the draw even when the
+	// reading fails, so they stay paired with the other members.
+	var shared float64
+	if s.group != nil {
+		shared = correlation.Uniform(s.group.Next(s.member))
+	}
+
 	// Simulate occasional error (5%)
 	if rand.Float64() < 0.05 {
 		reading.Error = "sensor communication error"
 		return reading
 	}
 
+	if s.config.Counter != nil {
+		s.counterReading(&reading)
+		return reading
+	}
+	if len(s.config.Values) > 0 {
+		reading.Values = s.generateValues(reading.Timestamp)
+		return reading
+	}
+
 	// Generate random value within configured range
-	reading.Value = s.config.Min + rand.Float64()*(s.config.Max-s.config.Min)
+	u := rand.Float64()
+	if s.group != nil {
+		u = shared
+	}
+	reading.Value = s.config.Min + u*(s.config.Max-s.config.Min)
 	return reading
 }
 
 // publish sends a reading through NATS and saves it to storage if configured.
-func (s *Sensor) publish(reading Reading, deviceID string) {
+// Valid readings are then checked against the alarms of the sensor.
+// Each call is traced as a "sensor.publish" span whose context travels in the NATS headers.
+func (s *Sensor) publish(ctx context.Context, reading Reading, deviceID string) {
+	reading.DeviceID = deviceID
+	reading.Tags = s.getTags()
+
+	ctx, span := tracer.Start(ctx, "sensor.publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("device.id", deviceID),
+			attribute.String("sensor.id", reading.SensorID),
+			attribute.String("sensor.type", reading.Type),
+		))
+	defer span.End()
+
 	// Publish to NATS first
 	data, _ := json.Marshal(reading)
 	// subject := fmt.Sprintf("iot.%s.readings.%s", deviceID, reading.Type)
 	subject := fmt.Sprintf("iot.%s.readings.%s.%s", deviceID, reading.Type, reading.SensorID)
-	if err := s.nc.Publish(subject, data); err != nil {
-		log.Printf("Error publishing reading from %s: %v", reading.SensorID, err)
+	msg := &nats.Msg{Subject: subject, Data: data}
+	telemetry.Inject(ctx, msg)
+	if err := s.nc.PublishMsg(msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+		slog.Error(fmt.Sprintf("Error publishing reading from %s: %v", reading.SensorID, err))
 	}
 
 	// Save to MongoDB if available
 	if s.storage != nil {
-		if err := s.storage.SaveReading(reading); err != nil {
-			log.Printf("Error saving reading to storage: %v", err)
+		if err := s.storage.SaveReading(ctx, reading); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "save failed")
+			slog.Error(fmt.Sprintf("Error saving reading to storage: %v", err))
+		}
+	}
+
+	if latest := s.getLatest(); latest != nil {
+		latest.record(reading, MultiValue(s.GetConfig()))
+	}
+
+	if reading.Error == "" {
+		for _, event := range s.alarms.Evaluate(reading.Value, reading.Values, reading.Timestamp) {
+			s.publishAlarm(ctx, deviceID, event)
 		}
 	}
 }
@@ -121,14 +256,76 @@ func (s *Sensor) GetConfig() config.SensorConfig {
 	return s.config
 }
 
+// SetTags sets the device labels attached to every published reading.
+// The map must not be modified afterwards.
+func (s *Sensor) SetTags(tags map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags = tags
+}
+
+// SetLatest sets where the sensor records its readings for the virtual sensors of its
+// device, and where a virtual sensor finds its inputs.
+func (s *Sensor) SetLatest(latest *Latest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest = latest
+}
+
+// SetCorrelation makes the random values of the sensor member number member of
+// group, or independent with a nil group.
+func (s *Sensor) SetCorrelation(group *correlation.Group, member int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.group, s.member = group, member
+}
+
+// getLatest returns the latest readings of the device, or nil.
+func (s *Sensor) getLatest() *Latest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latest
+}
+
+// getTags returns the device labels attached to published readings.
+func (s *Sensor) getTags() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tags
+}
+
 // UpdateFrequency updates the sensor reading frequency safely.
 func (s *Sensor) UpdateFrequency(frequency time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.config.Frequency = frequency
+	s.notifyUpdated()
 	log.Printf("Sensor %s frequency updated to %v", s.config.ID, frequency)
 }
 
+// ApplyConfig replaces the sensor configuration safely, keeping its ID.
+// A running sensor picks up the new frequency and alarms immediately.
+func (s *Sensor) ApplyConfig(cfg config.SensorConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg.ID = s.config.ID
+	if !reflect.DeepEqual(cfg.Counter, s.config.Counter) {
+		// A reconfigured counter starts again from its initial value.
+		s.counter = nil
+	}
+	s.config = cfg
+	s.alarms.Reconfigure(cfg.Alarms)
+	s.notifyUpdated()
+}
+
+// notifyUpdated wakes the running loop without blocking if a signal is already pending.
+func (s *Sensor) notifyUpdated() {
+	select {
+	case s.updated <- struct{}{}:
+	default:
+	}
+}
+
 // UpdateThresholds updates the sensor thresholds (min/max) safely.
 func (s *Sensor) UpdateThresholds(thresholds map[string]interface{}) {
 	s.mu.Lock()
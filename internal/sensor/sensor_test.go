@@ -2,17 +2,23 @@
 package sensor
 
 import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/correlation"
 )
 
 // mockStorage is a no-op implementation of the Storage interface for testing purposes.
 type mockStorage struct{}
 
 // SaveReading does nothing and returns nil, satisfying the Storage interface.
-func (m *mockStorage) SaveReading(reading Reading) error {
+func (m *mockStorage) SaveReading(ctx context.Context, reading Reading) error {
 	return nil
 }
 
@@ -108,3 +114,182 @@ func TestUpdateThresholds(t *testing.T) {
 		t.Errorf("Expected max 40.0, got %.2f", config.Max)
 	}
 }
+
+// recordingStorage keeps every saved reading.
+type recordingStorage struct {
+	readings []Reading
+}
+
+// SaveReading records the reading.
+func (r *recordingStorage) SaveReading(ctx context.Context, reading Reading) error {
+	r.readings = append(r.readings, reading)
+	return nil
+}
+
+// TestPublishSetsDevice tests that published readings carry the device ID and tags.
+func TestPublishSetsDevice(t *testing.T) {
+	store := &recordingStorage{}
+	sensor := New(config.SensorConfig{ID: "temp-01", Type: "temperature"}, nil, store)
+	sensor.SetTags(map[string]string{"site": "lab"})
+
+	sensor.publish(context.Background(), sensor.generateReading(), "device-001")
+
+	if len(store.readings) != 1 {
+		t.Fatalf("Expected 1 saved reading, got %d", len(store.readings))
+	}
+	reading := store.readings[0]
+	if reading.DeviceID != "device-001" {
+		t.Errorf("Expected device ID 'device-001', got '%s'", reading.DeviceID)
+	}
+	if reading.Tags["site"] != "lab" {
+		t.Errorf("Expected tag site=lab, got %v", reading.Tags)
+	}
+}
+
+// TestReplay tests that a replay sensor emits every recorded sample and stops at the end.
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "temp.csv")
+	recording := "timestamp,value,error\n" +
+		"2025-01-01T00:00:00Z,20.5,\n" +
+		"2025-01-01T00:00:01Z,0,sensor communication error\n" +
+		"2025-01-01T00:00:02Z,21.5,\n"
+	if err := os.WriteFile(path, []byte(recording), 0o644); err != nil {
+		t.Fatalf("Error writing recording: %v", err)
+	}
+
+	store := &recordingStorage{}
+	sensor := New(config.SensorConfig{
+		ID:        "temp-01",
+		Type:      "temperature",
+		Enabled:   true,
+		Frequency: time.Millisecond,
+		Unit:      "°C",
+		Generator: config.GeneratorReplay,
+		Replay:    &config.ReplayConfig{File: path, Speed: 100},
+	}, nil, store)
+
+	done := make(chan struct{})
+	go func() {
+		sensor.StartSensor(context.Background(), "device-001")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the replay to finish")
+	}
+
+	if len(store.readings) != 3 {
+		t.Fatalf("Expected 3 readings, got %d", len(store.readings))
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, want := range []float64{20.5, 0, 21.5} {
+		reading := store.readings[i]
+		if reading.Value != want {
+			t.Errorf("Reading %d: expected value %v, got %v", i, want, reading.Value)
+		}
+		if !reading.Timestamp.Equal(start.Add(time.Duration(i) * time.Second)) {
+			t.Errorf("Reading %d: expected the recorded timestamp, got %v", i, reading.Timestamp)
+		}
+	}
+	if store.readings[1].Error == "" {
+		t.Errorf("Expected the second reading to replay the recorded error")
+	}
+}
+
+// TestGenerateValues tests that multi-value readings follow the model of every field.
+func TestGenerateValues(t *testing.T) {
+	sensor := New(config.SensorConfig{
+		ID:        "accel-01",
+		Type:      "acceleration",
+		Frequency: time.Second,
+		Unit:      "g",
+		Values: map[string]config.ValueConfig{
+			"x": {Min: -2, Max: 2},
+			"y": {Min: -1, Max: 1, Model: config.ModelRandomWalk, Step: 0.1},
+			"z": {Min: 0.9, Max: 1.1, Model: config.ModelSine, Period: time.Minute},
+		},
+	}, nil, &mockStorage{})
+
+	var prevY float64
+	valid := 0
+	for i := 0; i < 200; i++ {
+		reading := sensor.generateReading()
+		if reading.Error != "" {
+			if reading.Values != nil {
+				t.Errorf("Expected no values in a failed reading, got %v", reading.Values)
+			}
+			continue
+		}
+
+		if len(reading.Values) != 3 || reading.Value != 0 {
+			t.Fatalf("Expected 3 values and no scalar value, got %+v", reading)
+		}
+		for name, vc := range sensor.GetConfig().Values {
+			if v := reading.Values[name]; v < vc.Min || v > vc.Max {
+				t.Errorf("Value %s = %v out of range [%v, %v]", name, v, vc.Min, vc.Max)
+			}
+		}
+		if y := reading.Values["y"]; valid > 0 && math.Abs(y-prevY) > 0.1+1e-9 {
+			t.Errorf("Random walk moved %v, more than its step 0.1", y-prevY)
+		}
+		prevY = reading.Values["y"]
+		valid++
+	}
+	if valid == 0 {
+		t.Fatal("Expected some valid readings")
+	}
+}
+
+// TestGenerateCorrelated tests that members of a correlation group draw correlated
+// values within their ranges, and stay paired across failed readings.
+func TestGenerateCorrelated(t *testing.T) {
+	group, err := correlation.NewGroup([][]float64{{1, 0.9}, {0.9, 1}}, rand.NormFloat64)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	temp := New(config.SensorConfig{ID: "temp-01", Type: "temperature", Frequency: time.Second, Min: 10, Max: 30}, nil, nil)
+	humidity := New(config.SensorConfig{ID: "humidity-01", Type: "humidity", Frequency: time.Second, Min: 0, Max: 100}, nil, nil)
+	temp.SetCorrelation(group, 0)
+	humidity.SetCorrelation(group, 1)
+
+	var xs, ys []float64
+	for range 5000 {
+		a, b := temp.generateReading(), humidity.generateReading()
+		if a.Error != "" || b.Error != "" {
+			continue
+		}
+		if a.Value < 10 || a.Value > 30 || b.Value < 0 || b.Value > 100 {
+			t.Fatalf("Values out of range: %v, %v", a.Value, b.Value)
+		}
+		xs, ys = append(xs, a.Value), append(ys, b.Value)
+	}
+
+	// Uniform marginals of a Gaussian copula with ρ = 0.9 correlate by (6/π)·asin(0.45) ≈ 0.89.
+	if r := pearson(xs, ys); math.Abs(r-0.89) > 0.03 {
+		t.Errorf("Expected a correlation of about 0.89, got %v", r)
+	}
+}
+
+// pearson returns the Pearson correlation coefficient of xs and ys.
+func pearson(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	var sx, sy, sxx, syy, sxy float64
+	for i := range xs {
+		sx, sy = sx+xs[i], sy+ys[i]
+		sxx, syy, sxy = sxx+xs[i]*xs[i], syy+ys[i]*ys[i], sxy+xs[i]*ys[i]
+	}
+	return (sxy/n - sx/n*sy/n) / math.Sqrt((sxx/n-sx/n*sx/n)*(syy/n-sy/n*sy/n))
+}
+
+// TestNextValueSine tests that the sine model spans its range over a period.
+func TestNextValueSine(t *testing.T) {
+	vc := config.ValueConfig{Min: 0, Max: 10, Model: config.ModelSine, Period: 4 * time.Second}
+	start := time.Unix(0, 0)
+	for i, want := range []float64{5, 10, 5, 0} {
+		got := nextValue(vc, 0, false, start.Add(time.Duration(i)*time.Second))
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("After %ds: expected %v, got %v", i, want, got)
+		}
+	}
+}
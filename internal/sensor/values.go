@@ -0,0 +1,67 @@
+package sensor
+
+import (
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"iot-device-simulator/internal/config"
+)
+
+// MultiValue reports whether the readings of a sensor configured with cfg only carry
+// named Values, leaving Value at zero: those of multi-value and location sensors.
+func MultiValue(cfg config.SensorConfig) bool {
+	return len(cfg.Values) > 0 || cfg.Location != nil
+}
+
+// Fields returns the sorted names of the Values of the readings of a sensor configured
+// with cfg, including the delta of counters publishing it, or nil if there are none.
+func Fields(cfg config.SensorConfig) []string {
+	switch {
+	case len(cfg.Values) > 0:
+		return slices.Sorted(maps.Keys(cfg.Values))
+	case cfg.Location != nil:
+		return slices.Sorted(slices.Values(LocationFields))
+	case cfg.Counter != nil && cfg.Counter.Delta:
+		return []string{"delta"}
+	}
+	return nil
+}
+
+// generateValues returns the next value of every field of a multi-value sensor.
+// The caller must hold s.mu. The returned map is never modified afterwards.
+func (s *Sensor) generateValues(now time.Time) map[string]float64 {
+	last := make(map[string]float64, len(s.config.Values))
+	for name, vc := range s.config.Values {
+		prev, ok := s.last[name]
+		last[name] = nextValue(vc, prev, ok, now)
+	}
+	s.last = last
+	return last
+}
+
+// nextValue returns the value of a field at now following its model. prev is the
+// previous value of the field, if ok.
+func nextValue(vc config.ValueConfig, prev float64, ok bool, now time.Time) float64 {
+	switch vc.Model {
+	case config.ModelRandomWalk:
+		if !ok {
+			break
+		}
+		step := vc.Step
+		if step == 0 {
+			step = (vc.Max - vc.Min) / 10
+		}
+		return min(max(prev+(2*rand.Float64()-1)*step, vc.Min), vc.Max)
+	case config.ModelSine:
+		if vc.Period <= 0 {
+			break
+		}
+		mid, amplitude := (vc.Min+vc.Max)/2, (vc.Max-vc.Min)/2
+		phase := float64(now.UnixNano()%int64(vc.Period)) / float64(vc.Period)
+		return mid + amplitude*math.Sin(2*math.Pi*phase)
+	}
+	return vc.Min + rand.Float64()*(vc.Max-vc.Min)
+}
@@ -0,0 +1,106 @@
+package sensor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/expr"
+)
+
+// runVirtual computes a virtual sensor until ctx is canceled: every frequency or, with
+// OnChange, whenever one of its inputs reports, at most once per frequency. A value
+// that cannot be computed, such as one whose inputs have not reported yet, is
+// published as an error reading.
+func (s *Sensor) runVirtual(ctx context.Context, deviceID string, cfg config.SensorConfig) {
+	e, err := expr.Parse(cfg.Virtual.Expression)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Sensor %s has an invalid expression: %v", cfg.ID, err))
+		return
+	}
+	latest := s.getLatest()
+	if latest == nil {
+		slog.Error(fmt.Sprintf("Sensor %s has no device sensors to compute %s from", cfg.ID, e))
+		return
+	}
+
+	ticker := time.NewTicker(cfg.Frequency)
+	defer ticker.Stop()
+	var changed <-chan struct{}
+	if cfg.Virtual.OnChange {
+		ticker.Stop()
+		var stop func()
+		changed, stop = latest.Watch(IsInput(e, cfg.ID))
+		defer stop()
+		log.Printf("Starting virtual sensor %s computing %s when its inputs change", cfg.ID, e)
+	} else {
+		log.Printf("Starting virtual sensor %s computing %s every %v", cfg.ID, e, cfg.Frequency)
+	}
+
+	var last time.Time
+	var pending <-chan time.Time // set while a change waits for the frequency to pass
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping sensor %s", cfg.ID)
+			return
+		case <-s.updated:
+			if !cfg.Virtual.OnChange {
+				ticker.Reset(s.GetConfig().Frequency)
+			}
+		case now := <-ticker.C:
+			s.publish(ctx, s.virtualReading(e, latest, now), deviceID)
+		case <-changed:
+			if pending != nil {
+				continue
+			}
+			if wait := time.Until(last.Add(s.GetConfig().Frequency)); wait > 0 {
+				pending = time.After(wait)
+				continue
+			}
+			last = time.Now()
+			s.publish(ctx, s.virtualReading(e, latest, last), deviceID)
+		case last = <-pending:
+			pending = nil
+			s.publish(ctx, s.virtualReading(e, latest, last), deviceID)
+		}
+	}
+}
+
+// virtualReading returns a reading of the value of e at now.
+func (s *Sensor) virtualReading(e *expr.Expr, latest *Latest, now time.Time) Reading {
+	cfg := s.GetConfig()
+	reading := Reading{
+		SensorID:  cfg.ID,
+		Type:      cfg.Type,
+		Unit:      cfg.Unit,
+		Timestamp: now,
+	}
+	v, err := e.Eval(latest.env(cfg.ID))
+	if err != nil {
+		reading.Error = err.Error()
+	} else {
+		reading.Value = v
+	}
+	return reading
+}
+
+// IsInput returns a function reporting whether a sensor is an input of e. self is the
+// virtual sensor computing e, which is not an input of its own expression, or empty.
+func IsInput(e *expr.Expr, self string) func(sensorID string) bool {
+	return func(sensorID string) bool {
+		return sensorID != self && slices.ContainsFunc(e.Refs(), func(name string) bool {
+			if expr.IsPattern(name) {
+				ok, _ := path.Match(name, sensorID)
+				return ok
+			}
+			return name == sensorID || strings.HasPrefix(name, sensorID+".")
+		})
+	}
+}
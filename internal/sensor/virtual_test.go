@@ -0,0 +1,121 @@
+package sensor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/expr"
+)
+
+// TestLatestEnv tests resolving sensors, fields and patterns against the latest readings,
+// leaving multi-value sensors out of patterns.
+func TestLatestEnv(t *testing.T) {
+	latest := NewLatest()
+	latest.Record(Reading{SensorID: "temp-01", Value: 20})
+	latest.Record(Reading{SensorID: "temp-02", Value: 24})
+	latest.Record(Reading{SensorID: "temp-02", Value: 99, Error: "sensor communication error"})
+	latest.Record(Reading{SensorID: "temp-avg", Value: 1000})
+	latest.record(Reading{SensorID: "gps-01", Values: map[string]float64{"speed": 10}}, true)
+	latest.record(Reading{SensorID: "temp-03", Values: map[string]float64{"inner": 30}}, true)
+
+	env := latest.env("temp-avg")
+	for _, tt := range []struct {
+		src  string
+		want float64
+	}{
+		{"temp-02", 24},     // the error reading keeps the previous value
+		{"avg(temp-*)", 22}, // leaving out the multi-value temp-03
+		{"gps-01.speed * 3.6", 36},
+	} {
+		e, err := expr.Parse(tt.src)
+		if err != nil {
+			t.Fatalf("%s: unexpected parse error: %v", tt.src, err)
+		}
+		if v, err := e.Eval(env); err != nil || v != tt.want {
+			t.Errorf("%s: expected %v, got %v (%v)", tt.src, tt.want, v, err)
+		}
+	}
+
+	if _, err := env.Value("gps-01.heading"); err == nil {
+		t.Errorf("Expected a missing field to be an error")
+	}
+	if _, err := env.Value("gps-01"); err == nil {
+		t.Errorf("Expected a multi-value sensor without a field to be an error")
+	}
+	latest.Forget("temp-01")
+	if _, err := env.Value("temp-01"); err == nil {
+		t.Errorf("Expected a forgotten sensor to have no value")
+	}
+}
+
+// TestRunVirtual tests that a virtual sensor publishes its value every frequency,
+// and an error until its inputs have reported.
+func TestRunVirtual(t *testing.T) {
+	latest := NewLatest()
+	store := &recordingStorage{}
+	sensor := New(config.SensorConfig{
+		ID:        "temp-diff",
+		Type:      "temperature",
+		Enabled:   true,
+		Frequency: 10 * time.Millisecond,
+		Virtual:   &config.VirtualConfig{Expression: "temp-01 - temp-02"},
+	}, nil, store)
+	sensor.SetLatest(latest)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	go func() {
+		time.Sleep(35 * time.Millisecond)
+		latest.Record(Reading{SensorID: "temp-01", Value: 25})
+		latest.Record(Reading{SensorID: "temp-02", Value: 21.5})
+	}()
+	sensor.StartSensor(ctx, "device-001")
+
+	if len(store.readings) < 5 {
+		t.Fatalf("Expected a reading every 10ms, got %d", len(store.readings))
+	}
+	if first := store.readings[0]; first.Error != "no valid reading of temp-01" {
+		t.Errorf("Expected the first reading to lack inputs, got %+v", first)
+	}
+	if last := store.readings[len(store.readings)-1]; last.Error != "" || last.Value != 3.5 {
+		t.Errorf("Expected the difference 3.5, got %+v", last)
+	}
+}
+
+// TestRunVirtualOnChange tests that an on-change virtual sensor publishes when its
+// inputs report, at most once per frequency.
+func TestRunVirtualOnChange(t *testing.T) {
+	latest := NewLatest()
+	store := &recordingStorage{}
+	sensor := New(config.SensorConfig{
+		ID:        "temp-max",
+		Type:      "temperature",
+		Enabled:   true,
+		Frequency: 30 * time.Millisecond,
+		Virtual:   &config.VirtualConfig{Expression: "max(temp-*)", OnChange: true},
+	}, nil, store)
+	sensor.SetLatest(latest)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		latest.Record(Reading{SensorID: "humidity-01", Value: 50}) // not an input
+		latest.Record(Reading{SensorID: "temp-01", Value: 20})
+		time.Sleep(5 * time.Millisecond)
+		latest.Record(Reading{SensorID: "temp-02", Value: 22}) // within the frequency
+	}()
+	sensor.StartSensor(ctx, "device-001")
+
+	if len(store.readings) != 2 {
+		t.Fatalf("Expected 2 readings, got %d: %+v", len(store.readings), store.readings)
+	}
+	if store.readings[0].Value != 20 || store.readings[1].Value != 22 {
+		t.Errorf("Expected 20 then 22, got %v then %v", store.readings[0].Value, store.readings[1].Value)
+	}
+	if gap := store.readings[1].Timestamp.Sub(store.readings[0].Timestamp); gap < 30*time.Millisecond {
+		t.Errorf("Expected the second reading a frequency after the first, got %v", gap)
+	}
+}
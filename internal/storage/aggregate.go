@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// MaxAggregateBuckets bounds the number of buckets an aggregation may span.
+const MaxAggregateBuckets = 10000
+
+// AggregateQuery summarizes the readings of one sensor in fixed time buckets.
+// Buckets are aligned to the Unix epoch, so a 1h bucket always starts on the hour.
+type AggregateQuery struct {
+	DeviceID string
+	SensorID string
+	// From (inclusive) and To (exclusive) bound the timestamps.
+	From time.Time
+	To   time.Time
+	// Bucket is the width of each bucket, a whole number of milliseconds.
+	Bucket time.Duration
+	// Percentiles lists the percentiles (0-100) computed for each bucket.
+	Percentiles []float64
+	// Field summarizes the named value of multi-value readings instead of the scalar
+	// value. Valid readings without the field are left out.
+	Field string
+}
+
+// AggregateBucket holds the statistics of the readings in one bucket.
+// Readings carrying an error are counted in Errors and excluded from the
+// statistics, which are zero when Count is zero.
+type AggregateBucket struct {
+	Start       time.Time          `json:"start"`
+	Count       int                `json:"count"`
+	Errors      int                `json:"errors"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Avg         float64            `json:"avg"`
+	StdDev      float64            `json:"stddev"`
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+}
+
+// PercentileKey names percentile p in AggregateBucket.Percentiles, e.g. "p95" or "p99.9".
+func PercentileKey(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// bucketStart returns the start of the bucket t falls into.
+func bucketStart(t time.Time, bucket time.Duration) time.Time {
+	ns := t.UnixNano()
+	return time.Unix(0, ns-ns%int64(bucket)).UTC()
+}
+
+// aggregateByQuery computes an aggregation in Go by paging through QueryReadings.
+// It is used by backends without a native aggregation engine.
+func aggregateByQuery(ctx context.Context, s Storage, q AggregateQuery) ([]AggregateBucket, error) {
+	query := ReadingQuery{
+		DeviceID:      q.DeviceID,
+		SensorIDs:     []string{q.SensorID},
+		From:          q.From,
+		To:            q.To,
+		Limit:         MaxQueryLimit,
+		IncludeErrors: true,
+	}
+
+	var (
+		buckets []*accumulator
+		current *accumulator
+	)
+	for {
+		page, err := s.QueryReadings(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		// Readings come in timestamp order, so each bucket is filled before the next starts.
+		for _, r := range page.Readings {
+			start := bucketStart(r.Timestamp, q.Bucket)
+			if current == nil || !current.start.Equal(start) {
+				current = &accumulator{start: start, keepValues: len(q.Percentiles) > 0}
+				buckets = append(buckets, current)
+			}
+			if r.Error != "" {
+				current.errors++
+				continue
+			}
+			if q.Field == "" {
+				current.add(r.Value)
+			} else if v, ok := r.Values[q.Field]; ok {
+				current.add(v)
+			}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	result := make([]AggregateBucket, len(buckets))
+	for i, acc := range buckets {
+		result[i] = acc.bucket(q.Percentiles)
+	}
+	return result, nil
+}
+
+// accumulator collects the statistics of one bucket, using Welford's algorithm
+// for a numerically stable standard deviation.
+type accumulator struct {
+	start      time.Time
+	count      int
+	errors     int
+	min, max   float64
+	mean, m2   float64
+	keepValues bool
+	values     []float64
+}
+
+// add adds a value to the bucket.
+func (a *accumulator) add(v float64) {
+	a.count++
+	if a.count == 1 || v < a.min {
+		a.min = v
+	}
+	if a.count == 1 || v > a.max {
+		a.max = v
+	}
+	delta := v - a.mean
+	a.mean += delta / float64(a.count)
+	a.m2 += delta * (v - a.mean)
+	if a.keepValues {
+		a.values = append(a.values, v)
+	}
+}
+
+// bucket returns the statistics of the bucket, with the given percentiles.
+func (a *accumulator) bucket(percentiles []float64) AggregateBucket {
+	b := AggregateBucket{Start: a.start, Count: a.count, Errors: a.errors}
+	if a.count == 0 {
+		return b
+	}
+	b.Min, b.Max, b.Avg = a.min, a.max, a.mean
+	b.StdDev = math.Sqrt(a.m2 / float64(a.count)) // population standard deviation
+
+	if len(percentiles) > 0 {
+		slices.Sort(a.values)
+		b.Percentiles = make(map[string]float64, len(percentiles))
+		for _, p := range percentiles {
+			b.Percentiles[PercentileKey(p)] = percentile(a.values, p)
+		}
+	}
+	return b
+}
+
+// percentile returns the p-th percentile (0-100) of sorted values using the
+// nearest-rank method, so the result is always one of the values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
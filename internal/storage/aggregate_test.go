@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/sensor"
+)
+
+// testAggregateReadings checks per-bucket statistics against any backend.
+func testAggregateReadings(t *testing.T, s Storage) {
+	ctx := context.Background()
+	start := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// Bucket 10:00 holds 2, 4, 4, 4, 5, 5, 7, 9 and an error; bucket 10:02 holds 10.
+	values := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	for i, v := range values {
+		s.SaveReading(ctx, sensor.Reading{DeviceID: testDeviceID, SensorID: "temp-01", Value: v, Timestamp: start.Add(time.Duration(i) * time.Second)})
+	}
+	s.SaveReading(ctx, sensor.Reading{DeviceID: testDeviceID, SensorID: "temp-01", Error: "sensor communication error", Timestamp: start.Add(30 * time.Second)})
+	s.SaveReading(ctx, sensor.Reading{DeviceID: testDeviceID, SensorID: "temp-01", Value: 10, Timestamp: start.Add(2 * time.Minute)})
+	s.SaveReading(ctx, sensor.Reading{DeviceID: testDeviceID, SensorID: "temp-02", Value: 100, Timestamp: start})
+
+	buckets, err := s.AggregateReadings(ctx, AggregateQuery{
+		DeviceID:    testDeviceID,
+		SensorID:    "temp-01",
+		From:        start,
+		To:          start.Add(time.Hour),
+		Bucket:      time.Minute,
+		Percentiles: []float64{50, 90},
+	})
+	if err != nil {
+		t.Fatalf("Error aggregating readings: %v", err)
+	}
+
+	if len(buckets) != 2 {
+		t.Fatalf("Expected 2 non-empty buckets, got %d: %+v", len(buckets), buckets)
+	}
+
+	b := buckets[0]
+	if !b.Start.Equal(start) {
+		t.Errorf("Expected the first bucket to start at %v, got %v", start, b.Start)
+	}
+	if b.Count != 8 || b.Errors != 1 {
+		t.Errorf("Expected 8 readings and 1 error, got %d and %d", b.Count, b.Errors)
+	}
+	if b.Min != 2 || b.Max != 9 || b.Avg != 5 {
+		t.Errorf("Expected min 2, max 9, avg 5, got %v, %v, %v", b.Min, b.Max, b.Avg)
+	}
+	if math.Abs(b.StdDev-2) > 1e-9 {
+		t.Errorf("Expected stddev 2, got %v", b.StdDev)
+	}
+	if b.Percentiles["p50"] != 4 || b.Percentiles["p90"] != 9 {
+		t.Errorf("Expected p50 4 and p90 9, got %v", b.Percentiles)
+	}
+
+	if b := buckets[1]; !b.Start.Equal(start.Add(2*time.Minute)) || b.Count != 1 || b.Avg != 10 || b.StdDev != 0 {
+		t.Errorf("Unexpected second bucket: %+v", b)
+	}
+	// A field aggregates the named values and leaves out the readings without it.
+	for i, v := range []float64{1, 3} {
+		s.SaveReading(ctx, sensor.Reading{DeviceID: testDeviceID, SensorID: "accel-01", Values: map[string]float64{"x": v, "y": 10 * v}, Timestamp: start.Add(time.Duration(i) * time.Second)})
+	}
+	s.SaveReading(ctx, sensor.Reading{DeviceID: testDeviceID, SensorID: "accel-01", Values: map[string]float64{"y": 50}, Timestamp: start.Add(2 * time.Second)})
+	buckets, err = s.AggregateReadings(ctx, AggregateQuery{
+		DeviceID:    testDeviceID,
+		SensorID:    "accel-01",
+		From:        start,
+		To:          start.Add(time.Hour),
+		Bucket:      time.Minute,
+		Percentiles: []float64{100},
+		Field:       "x",
+	})
+	if err != nil {
+		t.Fatalf("Error aggregating readings: %v", err)
+	}
+	if len(buckets) != 1 {
+		t.Fatalf("Expected 1 bucket, got %d: %+v", len(buckets), buckets)
+	}
+	if b := buckets[0]; b.Count != 2 || b.Min != 1 || b.Max != 3 || b.Avg != 2 || b.Percentiles["p100"] != 3 {
+		t.Errorf("Unexpected field bucket: %+v", b)
+	}
+}
+
+// TestMemory_AggregateReadings tests aggregations on the in-memory backend.
+func TestMemory_AggregateReadings(t *testing.T) {
+	testAggregateReadings(t, NewMemory(0))
+}
+
+// TestSQLite_AggregateReadings tests aggregations on the SQLite backend.
+func TestSQLite_AggregateReadings(t *testing.T) {
+	testAggregateReadings(t, newTestSQLite(t))
+}
+
+// TestMongoDB_AggregateReadings tests that the aggregation pipeline computes the same
+// statistics, including nearest-rank percentiles, as the other backends. It skips the
+// test if MongoDB is not available.
+func TestMongoDB_AggregateReadings(t *testing.T) {
+	mongodb, err := NewMongoDB("mongodb://localhost:27017", "test_iot_aggregate")
+	if err != nil {
+		t.Skip("MongoDB not available, skipping test")
+	}
+	defer mongodb.Close()
+
+	ctx := context.Background()
+	mongodb.database.Drop(ctx)
+	defer mongodb.database.Drop(ctx)
+	if err := mongodb.EnsureSchema(ctx, ReadingsOptions{}); err != nil {
+		t.Fatalf("Error ensuring schema: %v", err)
+	}
+	testAggregateReadings(t, mongodb)
+}
+
+// TestPercentile tests the nearest-rank percentile.
+func TestPercentile(t *testing.T) {
+	sorted := []float64{15, 20, 35, 40, 50}
+	for p, expected := range map[float64]float64{0: 15, 5: 15, 30: 20, 40: 20, 50: 35, 100: 50} {
+		if got := percentile(sorted, p); got != expected {
+			t.Errorf("Expected p%v to be %v, got %v", p, expected, got)
+		}
+	}
+}
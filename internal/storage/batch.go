@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/sensor"
+)
+
+// Default batching settings used when the configuration leaves them at zero.
+const (
+	DefaultBatchSize          = 100
+	DefaultBatchFlushInterval = time.Second
+	DefaultBatchQueueSize     = 10000
+)
+
+// batchRetries is how many times a BatchWriter retries the readings it could not write,
+// waiting batchRetryBackoff before the first retry and twice as long before each next one.
+const batchRetries = 3
+
+var batchRetryBackoff = 500 * time.Millisecond
+
+// ErrQueueFull is returned by BatchWriter.SaveReading when a reading is dropped
+// because the write queue is full.
+var ErrQueueFull = errors.New("storage: write queue full, reading dropped")
+
+// ErrNotWritten marks the errors of writes known to have stored none of their readings,
+// such as a write that never reached the server. A BatchWriter only retries those and
+// the failed readings of a PartialWriteError: other failed writes may have been applied,
+// and retrying them could store their readings twice.
+var ErrNotWritten = errors.New("storage: readings not written")
+
+// notWritten marks err, if any, as the error of a write that stored nothing.
+func notWritten(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrNotWritten, err)
+}
+
+// BatchSaver is implemented by backends that can store many readings in one operation.
+// SaveReadings returns a *PartialWriteError if only some of the readings were stored.
+type BatchSaver interface {
+	SaveReadings(ctx context.Context, readings []sensor.Reading) error
+}
+
+// PartialWriteError is returned by SaveReadings when some of the readings were stored
+// and the others were not.
+type PartialWriteError struct {
+	// Failed holds the indexes of the readings that were not stored.
+	Failed []int
+	Err    error
+}
+
+// Error implements error.
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("%d readings not stored: %v", len(e.Failed), e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
+
+// BatchStats reports the state of a BatchWriter.
+type BatchStats struct {
+	QueueDepth int   `json:"queue_depth"`
+	Written    int64 `json:"written"`
+	Dropped    int64 `json:"dropped"`
+	Failed     int64 `json:"failed"`
+}
+
+// BatchWriter queues readings and writes them to its backend asynchronously in batches,
+// flushing when a batch is full or the flush interval elapses. Readings that do not fit
+// in the queue are dropped. Every other operation is passed straight to the backend.
+// It implements Storage and is safe for concurrent use.
+type BatchWriter struct {
+	Storage
+
+	size     int
+	interval time.Duration
+	queue    chan sensor.Reading
+	done     chan struct{}
+
+	mu     sync.RWMutex // guards closed against concurrent sends
+	closed bool
+
+	written atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+}
+
+// NewBatchWriter starts a BatchWriter in front of backend.
+func NewBatchWriter(backend Storage, cfg config.BatchConfig) *BatchWriter {
+	w := &BatchWriter{
+		Storage:  backend,
+		size:     cfg.Size,
+		interval: cfg.FlushInterval,
+		done:     make(chan struct{}),
+	}
+	if w.size <= 0 {
+		w.size = DefaultBatchSize
+	}
+	if w.interval <= 0 {
+		w.interval = DefaultBatchFlushInterval
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultBatchQueueSize
+	}
+	w.queue = make(chan sensor.Reading, queueSize)
+
+	go w.run()
+	return w
+}
+
+// SaveReading queues a reading without blocking. It returns ErrQueueFull if the
+// reading had to be dropped.
+func (w *BatchWriter) SaveReading(ctx context.Context, reading sensor.Reading) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		w.dropped.Add(1)
+		return ErrQueueFull
+	}
+
+	select {
+	case w.queue <- reading:
+		return nil
+	default:
+		w.dropped.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// Stats returns the current queue depth and write counters.
+func (w *BatchWriter) Stats() BatchStats {
+	return BatchStats{
+		QueueDepth: len(w.queue),
+		Written:    w.written.Load(),
+		Dropped:    w.dropped.Load(),
+		Failed:     w.failed.Load(),
+	}
+}
+
+// Unwrap returns the backend.
+func (w *BatchWriter) Unwrap() Storage {
+	return w.Storage
+}
+
+// Close stops accepting readings, flushes everything still queued and closes the backend.
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+	return w.Storage.Close()
+}
+
+// run collects queued readings into batches until the queue is closed.
+func (w *BatchWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make([]sensor.Reading, 0, w.size)
+	for {
+		select {
+		case reading, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, reading)
+			if len(batch) >= w.size {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush writes a batch to the backend, retrying the readings known not to be written
+// with an exponential backoff. Readings still not written after batchRetries retries,
+// and those of failed writes that may have been applied, are counted as failed.
+func (w *BatchWriter) flush(batch []sensor.Reading) {
+	pending := batch
+	backoff := batchRetryBackoff
+	for retry := 0; len(pending) > 0; retry++ {
+		var (
+			dropped int
+			err     error
+		)
+		pending, dropped, err = w.save(pending)
+		if dropped > 0 {
+			w.failed.Add(int64(dropped))
+			slog.Error(fmt.Sprintf("Error writing %d readings, not retrying them as they may be stored: %v", dropped, err))
+		}
+		if len(pending) == 0 {
+			return
+		}
+		if retry == batchRetries {
+			w.failed.Add(int64(len(pending)))
+			slog.Error(fmt.Sprintf("Error writing %d readings, dropping them after %d retries: %v", len(pending), batchRetries, err))
+			return
+		}
+		slog.Warn(fmt.Sprintf("Writing %d readings failed, retrying in %v: %v", len(pending), backoff, err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// save writes readings to the backend, in one operation if it can save batches and one
+// by one otherwise. It returns the readings known not to be written, which may be retried,
+// the number of readings whose write failed but may have been applied, and the last error.
+func (w *BatchWriter) save(readings []sensor.Reading) (unwritten []sensor.Reading, dropped int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if saver, ok := w.Storage.(BatchSaver); ok {
+		err := saver.SaveReadings(ctx, readings)
+		var partial *PartialWriteError
+		switch {
+		case err == nil:
+			w.written.Add(int64(len(readings)))
+			return nil, 0, nil
+		case errors.As(err, &partial):
+			for _, i := range partial.Failed {
+				unwritten = append(unwritten, readings[i])
+			}
+			w.written.Add(int64(len(readings) - len(unwritten)))
+			return unwritten, 0, err
+		case errors.Is(err, ErrNotWritten):
+			return readings, 0, err
+		}
+		return nil, len(readings), err
+	}
+
+	for _, reading := range readings {
+		saveErr := w.Storage.SaveReading(ctx, reading)
+		switch {
+		case saveErr == nil:
+			w.written.Add(1)
+			continue
+		case errors.Is(saveErr, ErrNotWritten):
+			unwritten = append(unwritten, reading)
+		default:
+			dropped++
+		}
+		err = saveErr
+	}
+	return unwritten, dropped, err
+}
+
+// Unwrapper is implemented by stores that wrap another Storage.
+type Unwrapper interface {
+	Unwrap() Storage
+}
+
+// FindBatchStats walks the chain of wrapped stores and returns the stats of the first
+// BatchWriter found.
+func FindBatchStats(s Storage) (BatchStats, bool) {
+	for s != nil {
+		if w, ok := s.(*BatchWriter); ok {
+			return w.Stats(), true
+		}
+		u, ok := s.(Unwrapper)
+		if !ok {
+			break
+		}
+		s = u.Unwrap()
+	}
+	return BatchStats{}, false
+}
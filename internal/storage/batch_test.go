@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/sensor"
+)
+
+// recordingStorage is a Memory store that records the size of every batch it saves.
+// When block is set, SaveReadings waits on it before returning.
+type recordingStorage struct {
+	*Memory
+
+	mu      sync.Mutex
+	batches []int
+	block   chan struct{}
+}
+
+func (r *recordingStorage) SaveReadings(ctx context.Context, readings []sensor.Reading) error {
+	if r.block != nil {
+		<-r.block
+	}
+	r.mu.Lock()
+	r.batches = append(r.batches, len(readings))
+	r.mu.Unlock()
+	for _, reading := range readings {
+		r.Memory.SaveReading(ctx, reading)
+	}
+	return nil
+}
+
+func (r *recordingStorage) batchSizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.batches...)
+}
+
+// TestBatchWriter_FlushBySize tests that a full batch is written in one operation,
+// and that Close flushes the remainder.
+func TestBatchWriter_FlushBySize(t *testing.T) {
+	backend := &recordingStorage{Memory: NewMemory(0)}
+	w := NewBatchWriter(backend, config.BatchConfig{Size: 3, FlushInterval: time.Hour})
+
+	saveValues(t, w, "temp-01", 1, 2, 3, 4)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(backend.batchSizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Error closing writer: %v", err)
+	}
+
+	sizes := backend.batchSizes()
+	if len(sizes) != 2 || sizes[0] != 3 || sizes[1] != 1 {
+		t.Errorf("Expected batches [3 1], got %v", sizes)
+	}
+	if stats := w.Stats(); stats.Written != 4 || stats.QueueDepth != 0 {
+		t.Errorf("Unexpected stats after close: %+v", stats)
+	}
+}
+
+// TestBatchWriter_FlushByInterval tests that a partial batch is written after the flush interval.
+func TestBatchWriter_FlushByInterval(t *testing.T) {
+	backend := &recordingStorage{Memory: NewMemory(0)}
+	w := NewBatchWriter(backend, config.BatchConfig{Size: 100, FlushInterval: 10 * time.Millisecond})
+	defer w.Close()
+
+	saveValues(t, w, "temp-01", 1)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(backend.batchSizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	readings, _ := w.GetLatestReadings(context.Background(), testDeviceID, "temp-01", 1)
+	if len(readings) != 1 {
+		t.Errorf("Expected the reading to be flushed to the backend, got %v", readings)
+	}
+}
+
+// TestBatchWriter_Drops tests that readings are dropped and counted when the queue is full.
+func TestBatchWriter_Drops(t *testing.T) {
+	backend := &recordingStorage{Memory: NewMemory(0), block: make(chan struct{})}
+	w := NewBatchWriter(backend, config.BatchConfig{Size: 1, FlushInterval: time.Hour, QueueSize: 1})
+
+	var dropped int
+	for i := 0; i < 10; i++ {
+		err := w.SaveReading(context.Background(), sensor.Reading{SensorID: "temp-01", Value: float64(i)})
+		if errors.Is(err, ErrQueueFull) {
+			dropped++
+		}
+	}
+
+	if dropped == 0 {
+		t.Errorf("Expected some readings to be dropped")
+	}
+	if stats := w.Stats(); stats.Dropped != int64(dropped) {
+		t.Errorf("Expected %d dropped readings in stats, got %d", dropped, stats.Dropped)
+	}
+
+	close(backend.block)
+	w.Close()
+}
+
+// flakyStorage is a Memory store whose SaveReadings fails to store the readings with
+// negative values the first fails times, reporting them in a PartialWriteError.
+type flakyStorage struct {
+	*Memory
+
+	mu      sync.Mutex
+	fails   int
+	batches []int
+}
+
+func (f *flakyStorage) SaveReadings(ctx context.Context, readings []sensor.Reading) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, len(readings))
+
+	partial := &PartialWriteError{Err: errors.New("write error")}
+	for i, reading := range readings {
+		if reading.Value < 0 && f.fails > 0 {
+			partial.Failed = append(partial.Failed, i)
+			continue
+		}
+		f.Memory.SaveReading(ctx, reading)
+	}
+	if len(partial.Failed) == 0 {
+		return nil
+	}
+	f.fails--
+	return partial
+}
+
+// TestBatchWriter_Retries tests that the readings of a partially written batch are
+// counted as written, and that the others are retried until written or retries run out.
+func TestBatchWriter_Retries(t *testing.T) {
+	defer func(backoff time.Duration) { batchRetryBackoff = backoff }(batchRetryBackoff)
+	batchRetryBackoff = time.Millisecond
+
+	tests := []struct {
+		name    string
+		fails   int
+		batches []int
+		written int64
+		failed  int64
+	}{
+		{"recovers", 2, []int{4, 2, 2}, 4, 0},
+		{"gives up", 10, []int{4, 2, 2, 2}, 2, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &flakyStorage{Memory: NewMemory(0), fails: tt.fails}
+			w := NewBatchWriter(backend, config.BatchConfig{Size: 4, FlushInterval: time.Hour})
+			saveValues(t, w, "temp-01", 1, -2, 3, -4)
+			if err := w.Close(); err != nil {
+				t.Fatalf("Error closing writer: %v", err)
+			}
+
+			if got := fmt.Sprint(backend.batches); got != fmt.Sprint(tt.batches) {
+				t.Errorf("Expected batches %v, got %s", tt.batches, got)
+			}
+			if stats := w.Stats(); stats.Written != tt.written || stats.Failed != tt.failed {
+				t.Errorf("Expected %d written and %d failed readings, got %+v", tt.written, tt.failed, stats)
+			}
+		})
+	}
+}
+
+// erroringBatchStorage is a Memory store whose SaveReadings always fails with err.
+type erroringBatchStorage struct {
+	*Memory
+	err   error
+	calls atomic.Int32
+}
+
+func (f *erroringBatchStorage) SaveReadings(ctx context.Context, readings []sensor.Reading) error {
+	f.calls.Add(1)
+	return f.err
+}
+
+// TestBatchWriter_RetriesOnlyUnwritten tests that only writes known to have stored nothing
+// are retried, while the readings of other failed writes are counted as failed at once.
+func TestBatchWriter_RetriesOnlyUnwritten(t *testing.T) {
+	defer func(backoff time.Duration) { batchRetryBackoff = backoff }(batchRetryBackoff)
+	batchRetryBackoff = time.Millisecond
+
+	tests := []struct {
+		name  string
+		err   error
+		calls int32
+	}{
+		{"not written", notWritten(errors.New("no server")), batchRetries + 1},
+		{"maybe written", errors.New("timeout waiting for the reply"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &erroringBatchStorage{Memory: NewMemory(0), err: tt.err}
+			w := NewBatchWriter(backend, config.BatchConfig{Size: 4, FlushInterval: time.Hour})
+			saveValues(t, w, "temp-01", 1, 2, 3, 4)
+			if err := w.Close(); err != nil {
+				t.Fatalf("Error closing writer: %v", err)
+			}
+
+			if calls := backend.calls.Load(); calls != tt.calls {
+				t.Errorf("Expected %d writes, got %d", tt.calls, calls)
+			}
+			if stats := w.Stats(); stats.Written != 0 || stats.Failed != 4 {
+				t.Errorf("Expected 4 failed readings, got %+v", stats)
+			}
+		})
+	}
+}
+
+// TestFindBatchStats tests that stats are found through a cache wrapper.
+func TestFindBatchStats(t *testing.T) {
+	w := NewBatchWriter(NewMemory(0), config.BatchConfig{})
+	defer w.Close()
+
+	if _, ok := FindBatchStats(NewCached(w, 10)); !ok {
+		t.Errorf("Expected to find batch stats behind the cache")
+	}
+	if _, ok := FindBatchStats(NewMemory(0)); ok {
+		t.Errorf("Expected no batch stats for a plain memory store")
+	}
+}
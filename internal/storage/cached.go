@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"iot-device-simulator/internal/alarm"
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/sensor"
+)
+
+// Cached is a read-through cache that keeps recent readings in memory in front of
+// another backend. Writes go to both; reads are answered from memory first and only
+// reach the backend when memory cannot satisfy them. It implements Storage.
+type Cached struct {
+	cache   *Memory
+	backend Storage
+}
+
+// NewCached wraps backend with an in-memory cache of capacity readings per sensor.
+func NewCached(backend Storage, capacity int) *Cached {
+	return &Cached{cache: NewMemory(capacity), backend: backend}
+}
+
+// SaveReading caches the reading and saves it to the backend.
+func (c *Cached) SaveReading(ctx context.Context, reading sensor.Reading) error {
+	c.cache.SaveReading(ctx, reading)
+	return c.backend.SaveReading(ctx, reading)
+}
+
+// SaveConfig saves the configuration to the backend; configurations are not cached.
+func (c *Cached) SaveConfig(ctx context.Context, deviceID string, configs map[string]config.SensorConfig) error {
+	return c.backend.SaveConfig(ctx, deviceID, configs)
+}
+
+// SaveAlarmAudit saves the audit entry to the backend; the audit trail is not cached.
+func (c *Cached) SaveAlarmAudit(ctx context.Context, entry alarm.AuditEntry) error {
+	return c.backend.SaveAlarmAudit(ctx, entry)
+}
+
+// GetLatestReadings answers from memory when it holds enough readings, and otherwise
+// asks the backend. If the backend fails, whatever memory holds is returned instead.
+func (c *Cached) GetLatestReadings(ctx context.Context, deviceID, sensorID string, limit int) ([]sensor.Reading, error) {
+	cached, _ := c.cache.GetLatestReadings(ctx, deviceID, sensorID, limit)
+	if len(cached) >= limit {
+		return cached, nil
+	}
+
+	readings, err := c.backend.GetLatestReadings(ctx, deviceID, sensorID, limit)
+	if err != nil {
+		if len(cached) > 0 {
+			slog.Warn(fmt.Sprintf("Serving readings of %s/%s from memory, backend failed: %v", deviceID, sensorID, err))
+			return cached, nil
+		}
+		return nil, err
+	}
+	if len(readings) < len(cached) {
+		return cached, nil
+	}
+	return readings, nil
+}
+
+// QueryReadings asks the backend, which holds the full history. If the backend
+// fails, the readings still held in memory are queried instead.
+func (c *Cached) QueryReadings(ctx context.Context, q ReadingQuery) (ReadingPage, error) {
+	page, err := c.backend.QueryReadings(ctx, q)
+	if err != nil && !errors.Is(err, ErrInvalidCursor) {
+		slog.Warn(fmt.Sprintf("Querying readings of %s from memory, backend failed: %v", q.DeviceID, err))
+		return c.cache.QueryReadings(ctx, q)
+	}
+	return page, err
+}
+
+// AggregateReadings asks the backend, falling back to the readings held in memory if it fails.
+func (c *Cached) AggregateReadings(ctx context.Context, q AggregateQuery) ([]AggregateBucket, error) {
+	buckets, err := c.backend.AggregateReadings(ctx, q)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Aggregating readings of %s/%s from memory, backend failed: %v", q.DeviceID, q.SensorID, err))
+		return c.cache.AggregateReadings(ctx, q)
+	}
+	return buckets, nil
+}
+
+// Unwrap returns the backend.
+func (c *Cached) Unwrap() Storage {
+	return c.backend
+}
+
+// Close closes the backend.
+func (c *Cached) Close() error {
+	return c.backend.Close()
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+
+	"iot-device-simulator/internal/alarm"
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/sensor"
+)
+
+// DefaultMemoryCapacity is the number of readings kept per sensor when none is configured.
+const DefaultMemoryCapacity = 1000
+
+// Memory keeps the last readings of every sensor of every device in fixed-size ring buffers,
+// and as many alarm audit entries per device. Nothing survives a restart.
+// It implements Storage and is safe for concurrent use.
+type Memory struct {
+	capacity int
+
+	mu       sync.RWMutex
+	readings map[readingKey]*ring
+	configs  map[string]map[string]config.SensorConfig
+	audit    map[string][]alarm.AuditEntry
+}
+
+// NewMemory creates an in-memory store that keeps up to capacity readings per sensor.
+func NewMemory(capacity int) *Memory {
+	if capacity <= 0 {
+		capacity = DefaultMemoryCapacity
+	}
+	return &Memory{
+		capacity: capacity,
+		readings: make(map[readingKey]*ring),
+		configs:  make(map[string]map[string]config.SensorConfig),
+		audit:    make(map[string][]alarm.AuditEntry),
+	}
+}
+
+// readingKey identifies the ring buffer of a sensor on a device.
+type readingKey struct {
+	deviceID string
+	sensorID string
+}
+
+// SaveReading appends a reading to its sensor's ring buffer, evicting the oldest one when full.
+func (m *Memory) SaveReading(ctx context.Context, reading sensor.Reading) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := readingKey{reading.DeviceID, reading.SensorID}
+	r, ok := m.readings[key]
+	if !ok {
+		r = &ring{buf: make([]sensor.Reading, m.capacity)}
+		m.readings[key] = r
+	}
+	r.push(reading)
+	return nil
+}
+
+// SaveConfig stores a copy of the device configuration.
+func (m *Memory) SaveConfig(ctx context.Context, deviceID string, configs map[string]config.SensorConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.configs[deviceID] = maps.Clone(configs)
+	return nil
+}
+
+// GetLatestReadings returns up to limit readings of a device's sensor, newest first.
+func (m *Memory) GetLatestReadings(ctx context.Context, deviceID, sensorID string, limit int) ([]sensor.Reading, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.readings[readingKey{deviceID, sensorID}]
+	if !ok {
+		return nil, nil
+	}
+	return r.latest(limit), nil
+}
+
+// QueryReadings returns a page of the readings still held in memory that match q.
+func (m *Memory) QueryReadings(ctx context.Context, q ReadingQuery) (ReadingPage, error) {
+	cursor, hasCursor, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return ReadingPage{}, err
+	}
+
+	m.mu.RLock()
+	var readings []keyedReading
+	for key, r := range m.readings {
+		if key.deviceID != q.DeviceID {
+			continue
+		}
+		for i, reading := range r.latest(0) {
+			keyed := keyedReading{reading, fmt.Sprintf("%016x", r.pushed-1-uint64(i))}
+			if q.matches(reading) && (!hasCursor || keyed.position().compare(cursor) > 0) {
+				readings = append(readings, keyed)
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	sortReadings(readings)
+	limit := q.pageLimit()
+	if len(readings) > limit+1 {
+		readings = readings[:limit+1]
+	}
+	return newPage(readings, limit), nil
+}
+
+// AggregateReadings summarizes the readings still held in memory.
+func (m *Memory) AggregateReadings(ctx context.Context, q AggregateQuery) ([]AggregateBucket, error) {
+	return aggregateByQuery(ctx, m, q)
+}
+
+// SaveAlarmAudit appends an entry to the audit trail of its device, dropping the oldest
+// entry when full.
+func (m *Memory) SaveAlarmAudit(ctx context.Context, entry alarm.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	audit := append(m.audit[entry.DeviceID], entry)
+	if len(audit) > m.capacity {
+		audit = audit[len(audit)-m.capacity:]
+	}
+	m.audit[entry.DeviceID] = audit
+	return nil
+}
+
+// Close does nothing; it satisfies the Storage interface.
+func (m *Memory) Close() error {
+	return nil
+}
+
+// ring is a fixed-size circular buffer of readings.
+type ring struct {
+	buf    []sensor.Reading
+	next   int    // index the next reading is written to
+	count  int    // number of valid readings, at most len(buf)
+	pushed uint64 // number of readings ever pushed; the newest is number pushed-1
+}
+
+// push stores a reading, overwriting the oldest one when the buffer is full.
+func (r *ring) push(reading sensor.Reading) {
+	r.buf[r.next] = reading
+	r.pushed++
+	r.next = (r.next + 1) % len(r.buf)
+	if r.count < len(r.buf) {
+		r.count++
+	}
+}
+
+// latest returns up to limit readings, newest first.
+func (r *ring) latest(limit int) []sensor.Reading {
+	if limit <= 0 || limit > r.count {
+		limit = r.count
+	}
+
+	readings := make([]sensor.Reading, limit)
+	for i := range readings {
+		readings[i] = r.buf[(r.next-1-i+len(r.buf))%len(r.buf)]
+	}
+	return readings
+}
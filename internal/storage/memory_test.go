@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/alarm"
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/sensor"
+)
+
+// testDeviceID is the device the readings of saveValues belong to.
+const testDeviceID = "device-001"
+
+// saveValues stores one reading per value for sensorID of testDeviceID, one second apart.
+func saveValues(t *testing.T, s sensor.Storage, sensorID string, values ...float64) {
+	t.Helper()
+	start := time.Now()
+	for i, v := range values {
+		reading := sensor.Reading{DeviceID: testDeviceID, SensorID: sensorID, Value: v, Timestamp: start.Add(time.Duration(i) * time.Second)}
+		if err := s.SaveReading(context.Background(), reading); err != nil {
+			t.Fatalf("Error saving reading: %v", err)
+		}
+	}
+}
+
+// TestMemory_RingBuffer tests that only the newest readings are kept, newest first.
+func TestMemory_RingBuffer(t *testing.T) {
+	m := NewMemory(3)
+	saveValues(t, m, "temp-01", 1, 2, 3, 4, 5)
+
+	readings, err := m.GetLatestReadings(context.Background(), testDeviceID, "temp-01", 10)
+	if err != nil {
+		t.Fatalf("Error getting readings: %v", err)
+	}
+
+	if len(readings) != 3 {
+		t.Fatalf("Expected 3 readings, got %d", len(readings))
+	}
+	for i, expected := range []float64{5, 4, 3} {
+		if readings[i].Value != expected {
+			t.Errorf("Expected reading %d to be %.0f, got %.0f", i, expected, readings[i].Value)
+		}
+	}
+
+	readings, _ = m.GetLatestReadings(context.Background(), testDeviceID, "temp-01", 1)
+	if len(readings) != 1 || readings[0].Value != 5 {
+		t.Errorf("Expected only the latest reading, got %v", readings)
+	}
+
+	if readings, _ := m.GetLatestReadings(context.Background(), testDeviceID, "unknown", 1); len(readings) != 0 {
+		t.Errorf("Expected no readings for an unknown sensor, got %v", readings)
+	}
+}
+
+// TestMemory_Devices tests that equally named sensors of different devices do not collide.
+func TestMemory_Devices(t *testing.T) {
+	m := NewMemory(0)
+	saveValues(t, m, "temp-01", 1)
+	m.SaveReading(context.Background(), sensor.Reading{DeviceID: "device-002", SensorID: "temp-01", Value: 2, Timestamp: time.Now()})
+
+	readings, _ := m.GetLatestReadings(context.Background(), testDeviceID, "temp-01", 10)
+	if len(readings) != 1 || readings[0].Value != 1 {
+		t.Errorf("Expected only the reading of %s, got %v", testDeviceID, readings)
+	}
+	readings, _ = m.GetLatestReadings(context.Background(), "device-002", "temp-01", 10)
+	if len(readings) != 1 || readings[0].Value != 2 {
+		t.Errorf("Expected only the reading of device-002, got %v", readings)
+	}
+}
+
+// TestMemory_Config tests that a saved device configuration is a copy.
+func TestMemory_Config(t *testing.T) {
+	m := NewMemory(0)
+	configs := map[string]config.SensorConfig{"temp-01": {ID: "temp-01", Type: "temperature"}}
+	m.SaveConfig(context.Background(), "device-001", configs)
+	delete(configs, "temp-01")
+
+	if saved := m.configs["device-001"]; saved["temp-01"].Type != "temperature" {
+		t.Errorf("Expected saved config, got %v", saved)
+	}
+}
+
+// TestMemory_AlarmAudit tests that the audit trail of a device keeps its newest entries.
+func TestMemory_AlarmAudit(t *testing.T) {
+	m := NewMemory(2)
+	for _, action := range []string{alarm.ActionShelve, alarm.ActionExpire, alarm.ActionAck} {
+		m.SaveAlarmAudit(context.Background(), alarm.AuditEntry{DeviceID: testDeviceID, SensorID: "temp-01", Alarm: "high", Action: action})
+	}
+
+	audit := m.audit[testDeviceID]
+	if len(audit) != 2 || audit[0].Action != alarm.ActionExpire || audit[1].Action != alarm.ActionAck {
+		t.Errorf("Expected the expire and ack entries, got %+v", audit)
+	}
+}
+
+// failingStorage is a Storage whose every operation fails, simulating a database outage.
+type failingStorage struct{ *Memory }
+
+var errOutage = errors.New("backend down")
+
+func (f failingStorage) SaveReading(ctx context.Context, reading sensor.Reading) error {
+	return errOutage
+}
+
+func (f failingStorage) GetLatestReadings(ctx context.Context, deviceID, sensorID string, limit int) ([]sensor.Reading, error) {
+	return nil, errOutage
+}
+
+// TestCached_BackendDown tests that the cache keeps answering while the backend fails.
+func TestCached_BackendDown(t *testing.T) {
+	c := NewCached(failingStorage{NewMemory(0)}, 10)
+
+	reading := sensor.Reading{DeviceID: testDeviceID, SensorID: "temp-01", Value: 21.5, Timestamp: time.Now()}
+	if err := c.SaveReading(context.Background(), reading); !errors.Is(err, errOutage) {
+		t.Errorf("Expected the backend error to be reported, got %v", err)
+	}
+
+	readings, err := c.GetLatestReadings(context.Background(), testDeviceID, "temp-01", 1)
+	if err != nil {
+		t.Fatalf("Expected the cache to answer, got %v", err)
+	}
+	if len(readings) != 1 || readings[0].Value != 21.5 {
+		t.Errorf("Expected the cached reading, got %v", readings)
+	}
+
+	if _, err := c.GetLatestReadings(context.Background(), testDeviceID, "temp-02", 1); !errors.Is(err, errOutage) {
+		t.Errorf("Expected the backend error on a cache miss, got %v", err)
+	}
+}
+
+// TestCached_ReadThrough tests that reads the cache cannot satisfy go to the backend.
+func TestCached_ReadThrough(t *testing.T) {
+	backend := NewMemory(0)
+	saveValues(t, backend, "temp-01", 1, 2, 3)
+
+	c := NewCached(backend, 10)
+	saveValues(t, c, "temp-01", 4)
+
+	readings, _ := c.GetLatestReadings(context.Background(), testDeviceID, "temp-01", 1)
+	if len(readings) != 1 || readings[0].Value != 4 {
+		t.Errorf("Expected the latest reading from memory, got %v", readings)
+	}
+
+	readings, _ = c.GetLatestReadings(context.Background(), testDeviceID, "temp-01", 3)
+	if len(readings) != 3 || readings[0].Value != 4 || readings[2].Value != 2 {
+		t.Errorf("Expected history from the backend, got %v", readings)
+	}
+}
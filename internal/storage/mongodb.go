@@ -1,19 +1,29 @@
-// Package storage provides functionality for interacting with the MongoDB database.
+// Package storage provides the persistence backends for readings and configurations.
 package storage
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"iot-device-simulator/internal/alarm"
+	"iot-device-simulator/internal/config"
 	"iot-device-simulator/internal/sensor"
 )
 
-// MongoDB represents a database client for storing readings and configurations.
+// MongoDB represents a database client for storing readings, configurations and the
+// alarm audit trail.
+// It implements Storage.
 type MongoDB struct {
 	client   *mongo.Client
 	database *mongo.Database
@@ -40,22 +50,147 @@ func NewMongoDB(uri, dbName string) (*MongoDB, error) {
 	}, nil
 }
 
+// startSpan opens a client span for an operation on the given collection.
+func (m *MongoDB) startSpan(ctx context.Context, operation, collection string) (context.Context, trace.Span) {
+	return startSpan(ctx, "mongodb", m.database.Name(), operation, collection)
+}
+
+// readingMeta is the metaField of the time-series 'readings' collection: the series a
+// reading belongs to.
+type readingMeta struct {
+	DeviceID string `bson:"device_id"`
+	SensorID string `bson:"sensor_id"`
+}
+
+// readingDoc is a reading as stored in the 'readings' collection, with its device and
+// sensor IDs in meta.
+type readingDoc struct {
+	// ID is the key of the reading in the cursors of QueryReadings.
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Meta      readingMeta        `bson:"meta"`
+	Type      string             `bson:"type"`
+	Value     float64            `bson:"value"`
+	Values    map[string]float64 `bson:"values,omitempty"`
+	State     string             `bson:"state,omitempty"`
+	Unit      string             `bson:"unit"`
+	Timestamp time.Time          `bson:"timestamp"`
+	Error     string             `bson:"error,omitempty"`
+	Tags      map[string]string  `bson:"tags,omitempty"`
+}
+
+// newReadingDoc returns the document storing reading.
+func newReadingDoc(reading sensor.Reading) readingDoc {
+	return readingDoc{
+		Meta:      readingMeta{DeviceID: reading.DeviceID, SensorID: reading.SensorID},
+		Type:      reading.Type,
+		Value:     reading.Value,
+		Values:    reading.Values,
+		State:     reading.State,
+		Unit:      reading.Unit,
+		Timestamp: reading.Timestamp,
+		Error:     reading.Error,
+		Tags:      reading.Tags,
+	}
+}
+
+// reading returns the reading stored in d.
+func (d readingDoc) reading() sensor.Reading {
+	return sensor.Reading{
+		DeviceID:  d.Meta.DeviceID,
+		SensorID:  d.Meta.SensorID,
+		Type:      d.Type,
+		Value:     d.Value,
+		Values:    d.Values,
+		State:     d.State,
+		Unit:      d.Unit,
+		Timestamp: d.Timestamp,
+		Error:     d.Error,
+		Tags:      d.Tags,
+	}
+}
+
+// readingsOf decodes every reading document of cursor.
+func readingsOf(ctx context.Context, cursor *mongo.Cursor) ([]sensor.Reading, error) {
+	var docs []readingDoc
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	readings := make([]sensor.Reading, len(docs))
+	for i, doc := range docs {
+		readings[i] = doc.reading()
+	}
+	return readings, nil
+}
+
 // SaveReading saves a single sensor reading to the 'readings' collection.
-func (m *MongoDB) SaveReading(reading sensor.Reading) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (m *MongoDB) SaveReading(ctx context.Context, reading sensor.Reading) (err error) {
+	ctx, span := m.startSpan(ctx, "SaveReading", "readings")
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err = m.database.Collection("readings").InsertOne(ctx, newReadingDoc(reading))
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error saving reading to MongoDB: %v", err))
+	}
+	if writeNotSent(err) {
+		return notWritten(err)
+	}
+	return err
+}
+
+// SaveReadings saves many readings to the 'readings' collection in a single InsertMany.
+// If only some of them are inserted, it returns a *PartialWriteError.
+func (m *MongoDB) SaveReadings(ctx context.Context, readings []sensor.Reading) (err error) {
+	ctx, span := m.startSpan(ctx, "SaveReadings", "readings")
+	span.SetAttributes(attribute.Int("db.operation.batch.size", len(readings)))
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	_, err := m.database.Collection("readings").InsertOne(ctx, reading)
+	docs := make([]any, len(readings))
+	for i, reading := range readings {
+		docs[i] = newReadingDoc(reading)
+	}
+
+	_, err = m.database.Collection("readings").InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
 	if err != nil {
-		log.Printf("Error saving reading to MongoDB: %v", err)
+		slog.Error(fmt.Sprintf("Error saving %d readings to MongoDB: %v", len(readings), err))
+	}
+
+	// An unordered InsertMany inserts every document it can; the write errors name the
+	// ones it could not. Without a write concern error the others are known to be stored.
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil && len(bulkErr.WriteErrors) > 0 {
+		partial := &PartialWriteError{Err: err}
+		for _, writeErr := range bulkErr.WriteErrors {
+			partial.Failed = append(partial.Failed, writeErr.Index)
+		}
+		return partial
+	}
+	if writeNotSent(err) {
+		return notWritten(err)
 	}
 	return err
 }
 
+// writeNotSent reports whether a failed write never reached the server, because no
+// server could be selected or the client is disconnected. Other errors, such as a
+// timeout waiting for the reply, may come after the server applied the write.
+func writeNotSent(err error) bool {
+	var selectErr topology.ServerSelectionError
+	return errors.As(err, &selectErr) || errors.Is(err, mongo.ErrClientDisconnected)
+}
+
 // SaveConfig saves the complete configuration of a device to the 'configurations' collection.
 // It uses an upsert operation to either create a new document or replace an existing one.
-func (m *MongoDB) SaveConfig(deviceID string, configs map[string]any) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (m *MongoDB) SaveConfig(ctx context.Context, deviceID string, configs map[string]config.SensorConfig) (err error) {
+	ctx, span := m.startSpan(ctx, "SaveConfig", "configurations")
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	doc := map[string]any{
@@ -65,7 +200,7 @@ func (m *MongoDB) SaveConfig(deviceID string, configs map[string]any) error {
 	}
 
 	opts := options.Replace().SetUpsert(true)
-	_, err := m.database.Collection("configurations").ReplaceOne(
+	_, err = m.database.Collection("configurations").ReplaceOne(
 		ctx,
 		map[string]any{"device_id": deviceID},
 		doc,
@@ -73,18 +208,37 @@ func (m *MongoDB) SaveConfig(deviceID string, configs map[string]any) error {
 	)
 
 	if err != nil {
-		log.Printf("Error saving config to MongoDB: %v", err)
+		slog.Error(fmt.Sprintf("Error saving config to MongoDB: %v", err))
 	}
 	return err
 }
 
-// GetLatestReadings retrieves the last 'limit' readings for a specific sensorID,
+// SaveAlarmAudit appends an entry to the 'alarm_audit' collection.
+func (m *MongoDB) SaveAlarmAudit(ctx context.Context, entry alarm.AuditEntry) (err error) {
+	ctx, span := m.startSpan(ctx, "SaveAlarmAudit", "alarm_audit")
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err = m.database.Collection("alarm_audit").InsertOne(ctx, entry)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error saving alarm audit entry to MongoDB: %v", err))
+	}
+	return err
+}
+
+// GetLatestReadings retrieves the last 'limit' readings for a specific sensorID of a device,
 // ordered by timestamp in descending order.
-func (m *MongoDB) GetLatestReadings(sensorID string, limit int) ([]sensor.Reading, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (m *MongoDB) GetLatestReadings(ctx context.Context, deviceID, sensorID string, limit int) (readings []sensor.Reading, err error) {
+	ctx, span := m.startSpan(ctx, "GetLatestReadings", "readings")
+	span.SetAttributes(attribute.String("device.id", deviceID), attribute.String("sensor.id", sensorID))
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"sensor_id": sensorID}
+	filter := bson.M{"meta.device_id": deviceID, "meta.sensor_id": sensorID}
 	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(limit))
 
 	cursor, err := m.database.Collection("readings").Find(ctx, filter, opts)
@@ -93,12 +247,72 @@ func (m *MongoDB) GetLatestReadings(sensorID string, limit int) ([]sensor.Readin
 	}
 	defer cursor.Close(ctx)
 
-	var readings []sensor.Reading
-	if err := cursor.All(ctx, &readings); err != nil {
-		return nil, err
+	return readingsOf(ctx, cursor)
+}
+
+// QueryReadings returns one page of the readings matching q.
+func (m *MongoDB) QueryReadings(ctx context.Context, q ReadingQuery) (page ReadingPage, err error) {
+	ctx, span := m.startSpan(ctx, "QueryReadings", "readings")
+	span.SetAttributes(attribute.String("device.id", q.DeviceID))
+	defer func() { endSpan(span, err) }()
+
+	cursor, hasCursor, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return ReadingPage{}, err
 	}
 
-	return readings, nil
+	filter := bson.D{{Key: "meta.device_id", Value: q.DeviceID}}
+	if len(q.SensorIDs) > 0 {
+		filter = append(filter, bson.E{Key: "meta.sensor_id", Value: bson.M{"$in": q.SensorIDs}})
+	}
+	timestamp := bson.M{}
+	if !q.From.IsZero() {
+		timestamp["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		timestamp["$lt"] = q.To
+	}
+	if len(timestamp) > 0 {
+		filter = append(filter, bson.E{Key: "timestamp", Value: timestamp})
+	}
+	if !q.IncludeErrors {
+		filter = append(filter, bson.E{Key: "error", Value: bson.M{"$in": bson.A{nil, ""}}})
+	}
+	if hasCursor {
+		id, err := primitive.ObjectIDFromHex(cursor.key)
+		if err != nil {
+			return ReadingPage{}, ErrInvalidCursor
+		}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"timestamp": bson.M{"$gt": cursor.timestamp}},
+			bson.M{"timestamp": cursor.timestamp, "meta.sensor_id": bson.M{"$gt": cursor.sensorID}},
+			bson.M{"timestamp": cursor.timestamp, "meta.sensor_id": cursor.sensorID, "_id": bson.M{"$gt": id}},
+		}})
+	}
+
+	limit := q.pageLimit()
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "meta.sensor_id", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit + 1))
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cur, err := m.database.Collection("readings").Find(ctx, filter, opts)
+	if err != nil {
+		return ReadingPage{}, err
+	}
+	defer cur.Close(ctx)
+
+	var docs []readingDoc
+	if err := cur.All(ctx, &docs); err != nil {
+		return ReadingPage{}, err
+	}
+	readings := make([]keyedReading, len(docs))
+	for i, doc := range docs {
+		readings[i] = keyedReading{doc.reading(), doc.ID.Hex()}
+	}
+	return newPage(readings, limit), nil
 }
 
 // Close disconnects the client from the MongoDB server.
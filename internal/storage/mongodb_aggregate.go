@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Server error codes returned when the $sortArray operator (MongoDB 5.2+) is unknown.
+var unknownOperatorCodes = []int32{
+	15952, // unknown group operator
+	168,   // InvalidPipelineOperator
+}
+
+// AggregateReadings summarizes the readings of a sensor with an aggregation pipeline.
+// Buckets are computed from the epoch milliseconds of each timestamp, and error
+// readings are counted separately and left out of the statistics. Percentiles are
+// picked from the sorted values of each bucket with the nearest-rank method, like the
+// other backends; on servers older than MongoDB 5.2, which lack $sortArray, the
+// aggregation falls back to computing the statistics in Go.
+func (m *MongoDB) AggregateReadings(ctx context.Context, q AggregateQuery) (buckets []AggregateBucket, err error) {
+	ctx, span := m.startSpan(ctx, "AggregateReadings", "readings")
+	span.SetAttributes(attribute.String("device.id", q.DeviceID), attribute.String("sensor.id", q.SensorID))
+	defer func() { endSpan(span, err) }()
+
+	aggCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	cursor, err := m.database.Collection("readings").Aggregate(aggCtx, aggregatePipeline(q))
+	if err != nil {
+		var cmdErr mongo.CommandError
+		if len(q.Percentiles) > 0 && errors.As(err, &cmdErr) && slices.Contains(unknownOperatorCodes, cmdErr.Code) {
+			slog.Warn(fmt.Sprintf("MongoDB does not support $sortArray, aggregating in Go: %v", err))
+			return aggregateByQuery(ctx, m, q)
+		}
+		return nil, err
+	}
+	defer cursor.Close(aggCtx)
+
+	var docs []struct {
+		Start       time.Time `bson:"_id"`
+		Count       int       `bson:"count"`
+		Errors      int       `bson:"errors"`
+		Min         *float64  `bson:"min"`
+		Max         *float64  `bson:"max"`
+		Avg         *float64  `bson:"avg"`
+		StdDev      *float64  `bson:"stddev"`
+		Percentiles []float64 `bson:"percentiles"`
+	}
+	if err := cursor.All(aggCtx, &docs); err != nil {
+		return nil, err
+	}
+
+	buckets = make([]AggregateBucket, len(docs))
+	for i, doc := range docs {
+		b := AggregateBucket{Start: doc.Start.UTC(), Count: doc.Count, Errors: doc.Errors}
+		if doc.Count > 0 {
+			b.Min, b.Max, b.Avg, b.StdDev = deref(doc.Min), deref(doc.Max), deref(doc.Avg), deref(doc.StdDev)
+			if len(doc.Percentiles) == len(q.Percentiles) && len(q.Percentiles) > 0 {
+				b.Percentiles = make(map[string]float64, len(q.Percentiles))
+				for j, p := range q.Percentiles {
+					b.Percentiles[PercentileKey(p)] = doc.Percentiles[j]
+				}
+			}
+		}
+		buckets[i] = b
+	}
+	return buckets, nil
+}
+
+// aggregatePipeline builds the pipeline of an aggregation.
+func aggregatePipeline(q AggregateQuery) mongo.Pipeline {
+	match := bson.D{{Key: "meta.device_id", Value: q.DeviceID}, {Key: "meta.sensor_id", Value: q.SensorID}}
+	timestamp := bson.M{}
+	if !q.From.IsZero() {
+		timestamp["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		timestamp["$lt"] = q.To
+	}
+	if len(timestamp) > 0 {
+		match = append(match, bson.E{Key: "timestamp", Value: timestamp})
+	}
+
+	// epoch - epoch % bucket, as a date
+	epoch := bson.M{"$toLong": "$timestamp"}
+	start := bson.M{"$toDate": bson.M{"$subtract": bson.A{epoch, bson.M{"$mod": bson.A{epoch, q.Bucket.Milliseconds()}}}}}
+
+	// The value of a valid reading, or null for an error reading; accumulators skip nulls.
+	// A field is missing from the readings that lack it, which accumulators skip too.
+	path := "$value"
+	if q.Field != "" {
+		path = "$values." + q.Field
+	}
+	failed := bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{"$error", ""}}, ""}}
+	present := bson.M{"$ne": bson.A{bson.M{"$type": path}, "missing"}}
+	value := bson.M{"$cond": bson.A{failed, nil, path}}
+
+	group := bson.D{
+		{Key: "_id", Value: start},
+		{Key: "count", Value: bson.M{"$sum": bson.M{"$cond": bson.A{failed, 0, bson.M{"$cond": bson.A{present, 1, 0}}}}}},
+		{Key: "errors", Value: bson.M{"$sum": bson.M{"$cond": bson.A{failed, 1, 0}}}},
+		{Key: "min", Value: bson.M{"$min": value}},
+		{Key: "max", Value: bson.M{"$max": value}},
+		{Key: "avg", Value: bson.M{"$avg": value}},
+		{Key: "stddev", Value: bson.M{"$stdDevPop": value}},
+	}
+	if len(q.Percentiles) > 0 {
+		// The valid values of the bucket; $$REMOVE leaves error readings out of $push.
+		group = append(group, bson.E{Key: "values", Value: bson.M{"$push": bson.M{"$cond": bson.A{failed, "$$REMOVE", path}}}})
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: group}},
+	}
+
+	if len(q.Percentiles) > 0 {
+		// Pick the nearest-rank percentiles from the sorted values, as aggregateByQuery does.
+		sorted := bson.M{"$sortArray": bson.M{"input": "$values", "sortBy": 1}}
+		n := bson.M{"$size": "$values"}
+		p := make(bson.A, len(q.Percentiles))
+		for i, percent := range q.Percentiles {
+			rank := bson.M{"$max": bson.A{bson.M{"$ceil": bson.M{"$multiply": bson.A{percent / 100, n}}}, 1}}
+			p[i] = bson.M{"$arrayElemAt": bson.A{sorted, bson.M{"$toInt": bson.M{"$subtract": bson.A{rank, 1}}}}}
+		}
+		pipeline = append(pipeline,
+			bson.D{{Key: "$set", Value: bson.M{"percentiles": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{n, 0}}, p, nil}}}}},
+			bson.D{{Key: "$unset", Value: "values"}},
+		)
+	}
+
+	return append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}})
+}
+
+// deref returns the value of p, or zero if p is nil.
+func deref(p *float64) float64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
@@ -0,0 +1,453 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ttlIndexName names the TTL index used for retention on a plain 'readings' collection.
+const ttlIndexName = "timestamp_ttl"
+
+// ErrMigrationRequired is returned by EnsureSchema when 'readings' is a time-series collection
+// of an older version, or one whose migration was interrupted. Writing to it would mix
+// layouts, so it must be migrated with MigrateReadings first.
+var ErrMigrationRequired = errors.New("storage: readings collection must be migrated")
+
+// Collections holding the readings while a time-series 'readings' collection is migrated:
+// they are copied into migratingCollection, which is renamed to migratedCollection once
+// the copy is complete.
+const (
+	migratingCollection = "readings_migrating"
+	migratedCollection  = "readings_migrated"
+)
+
+// migrationBatchSize is the number of documents inserted at once while migrating.
+const migrationBatchSize = 1000
+
+// legacyIndexNames are the indexes on the top-level device_id and sensor_id of readings
+// written before they moved into 'meta'.
+var legacyIndexNames = []string{"device_id_sensor_id_timestamp", "device_id_timestamp_sensor_id"}
+
+// ReadingsOptions describes how the 'readings' collection is created and retained.
+type ReadingsOptions struct {
+	// Retention is how long readings are kept; zero keeps them forever.
+	Retention time.Duration
+	// Granularity is the time-series bucket granularity: "seconds", "minutes" or "hours".
+	Granularity string
+	// DeviceID is given to migrated readings written before readings carried a device ID.
+	DeviceID string
+}
+
+// EnsureSchema prepares the collections on startup. If 'readings' does not exist it is
+// created as a time-series collection with 'timestamp' as timeField and 'meta', holding
+// the device and sensor IDs, as metaField. The retention policy is applied with
+// expireAfterSeconds on a time-series collection, or with a TTL index on an existing plain
+// collection. Readings of a plain collection written by older versions, with top-level
+// device and sensor IDs, are migrated in place first; a time-series collection of an older
+// version returns ErrMigrationRequired. Supporting indexes are created if missing.
+func (m *MongoDB) EnsureSchema(ctx context.Context, opts ReadingsOptions) (err error) {
+	ctx, span := m.startSpan(ctx, "EnsureSchema", "readings")
+	defer func() { endSpan(span, err) }()
+
+	if err := m.migrateReadings(ctx, opts); err != nil {
+		return fmt.Errorf("migrating readings: %w", err)
+	}
+
+	specs, err := m.database.ListCollectionSpecifications(ctx, bson.M{"name": "readings"})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(specs) == 0:
+		if err := m.createReadingsCollection(ctx, opts); err != nil {
+			return fmt.Errorf("creating readings collection: %w", err)
+		}
+		log.Printf("Created time-series collection 'readings' (retention %v)", retentionString(opts.Retention))
+	case specs[0].Type == "timeseries":
+		if err := m.setTimeSeriesRetention(ctx, opts.Retention); err != nil {
+			return fmt.Errorf("setting readings retention: %w", err)
+		}
+	default:
+		log.Printf("Collection 'readings' is not a time-series collection; using a TTL index for retention")
+		if err := m.setTTLIndex(ctx, opts.Retention); err != nil {
+			return fmt.Errorf("setting readings TTL index: %w", err)
+		}
+	}
+
+	_, err = m.database.Collection("readings").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// readings.latest and single-sensor queries
+			Keys:    bson.D{{Key: "meta.device_id", Value: 1}, {Key: "meta.sensor_id", Value: 1}, {Key: "timestamp", Value: -1}},
+			Options: options.Index().SetName("meta_device_id_sensor_id_timestamp"),
+		},
+		{
+			// readings.query pagination across sensors
+			Keys:    bson.D{{Key: "meta.device_id", Value: 1}, {Key: "timestamp", Value: 1}, {Key: "meta.sensor_id", Value: 1}},
+			Options: options.Index().SetName("meta_device_id_timestamp_sensor_id"),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating readings indexes: %w", err)
+	}
+
+	_, err = m.database.Collection("configurations").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "device_id", Value: 1}},
+		Options: options.Index().SetName("device_id").SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("creating configurations index: %w", err)
+	}
+
+	_, err = m.database.Collection("alarm_audit").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "device_id", Value: 1}, {Key: "sensor_id", Value: 1}, {Key: "timestamp", Value: -1}},
+		Options: options.Index().SetName("device_id_sensor_id_timestamp"),
+	})
+	if err != nil {
+		return fmt.Errorf("creating alarm_audit index: %w", err)
+	}
+
+	return nil
+}
+
+// migrateReadings moves the top-level device_id and sensor_id of the readings of a plain
+// collection written by older versions into 'meta', giving readings without a device_id
+// opts.DeviceID. The metaField of a time-series collection cannot be changed in place, so
+// an older one returns ErrMigrationRequired.
+func (m *MongoDB) migrateReadings(ctx context.Context, opts ReadingsOptions) error {
+	readings, migrated, err := m.readingsSpecs(ctx)
+	switch {
+	case err != nil:
+		return err
+	case migrated:
+		return fmt.Errorf("%w: the migration of collection 'readings' was interrupted", ErrMigrationRequired)
+	case readings == nil:
+		return nil
+	case readings.Type != "timeseries":
+		return m.migratePlainReadings(ctx, opts.DeviceID)
+	case metaField(readings) != "meta":
+		return fmt.Errorf("%w: time-series collection 'readings' keeps the sensor ID in its metaField", ErrMigrationRequired)
+	}
+	return nil
+}
+
+// MigrateReadings migrates a time-series 'readings' collection of an older version, whose
+// metaField is not 'meta', to a new time-series collection: the readings are copied aside,
+// the collection is dropped and recreated, and the readings are moved back. Every simulator
+// writing to the collection must be stopped first; if the collection gained readings
+// during the copy, it is left alone and an error is returned. A migration interrupted
+// after the copy aside was complete resumes where it stopped, and never drops a
+// collection created by the migration.
+func (m *MongoDB) MigrateReadings(ctx context.Context, opts ReadingsOptions) error {
+	readings, migrated, err := m.readingsSpecs(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !migrated {
+		if readings == nil || readings.Type != "timeseries" || metaField(readings) == "meta" {
+			log.Printf("Collection 'readings' needs no migration")
+			return nil
+		}
+
+		log.Printf("Copying time-series collection 'readings' aside")
+		if err := m.database.Collection(migratingCollection).Drop(ctx); err != nil {
+			return err
+		}
+		err := m.copyReadings(ctx, "readings", migratingCollection, func(doc bson.M) { moveToMeta(doc, opts.DeviceID) })
+		if err != nil {
+			return fmt.Errorf("copying readings aside: %w", err)
+		}
+		err = m.client.Database("admin").RunCommand(ctx, bson.D{
+			{Key: "renameCollection", Value: m.database.Name() + "." + migratingCollection},
+			{Key: "to", Value: m.database.Name() + "." + migratedCollection},
+		}).Err()
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Printf("Resuming the migration of collection 'readings'")
+	}
+
+	switch {
+	case readings == nil:
+		// Dropped by an interrupted migration.
+		if err := m.createReadingsCollection(ctx, opts); err != nil {
+			return err
+		}
+	case readings.Type != "timeseries":
+		return fmt.Errorf("collection 'readings' is not a time-series collection, leaving %s to be restored by hand", migratedCollection)
+	case metaField(readings) != "meta":
+		// The old collection is dropped only if it holds no reading missing from the copy.
+		n, err := m.database.Collection("readings").CountDocuments(ctx, bson.M{})
+		if err != nil {
+			return err
+		}
+		copied, err := m.database.Collection(migratedCollection).CountDocuments(ctx, bson.M{})
+		if err != nil {
+			return err
+		}
+		if n > copied {
+			return fmt.Errorf("collection 'readings' gained %d readings during the migration; stop every simulator writing to it and migrate again", n-copied)
+		}
+		if err := m.database.Collection("readings").Drop(ctx); err != nil {
+			return err
+		}
+		if err := m.createReadingsCollection(ctx, opts); err != nil {
+			return err
+		}
+	}
+
+	if err := m.moveReadings(ctx, opts.DeviceID); err != nil {
+		return fmt.Errorf("moving readings back: %w", err)
+	}
+	if err := m.database.Collection(migratedCollection).Drop(ctx); err != nil {
+		return err
+	}
+	log.Printf("Migrated collection 'readings'")
+	return nil
+}
+
+// readingsSpecs returns the specification of the 'readings' collection, or nil if it does
+// not exist, and whether the readings of a migration are waiting in migratedCollection.
+func (m *MongoDB) readingsSpecs(ctx context.Context) (readings *mongo.CollectionSpecification, migrated bool, err error) {
+	specs, err := m.database.ListCollectionSpecifications(ctx, bson.M{"name": bson.M{"$in": bson.A{"readings", migratedCollection}}})
+	if err != nil {
+		return nil, false, err
+	}
+	for _, spec := range specs {
+		switch spec.Name {
+		case "readings":
+			readings = spec
+		case migratedCollection:
+			migrated = true
+		}
+	}
+	return readings, migrated, nil
+}
+
+// metaField returns the metaField of a time-series collection, or "" if it has none.
+func metaField(spec *mongo.CollectionSpecification) string {
+	field, _ := spec.Options.Lookup("timeseries", "metaField").StringValueOK()
+	return field
+}
+
+// migratePlainReadings moves the device and sensor IDs of the readings of a plain
+// 'readings' collection into 'meta', and drops the indexes on the old fields.
+func (m *MongoDB) migratePlainReadings(ctx context.Context, deviceID string) error {
+	collection := m.database.Collection("readings")
+	result, err := collection.UpdateMany(ctx, bson.M{"meta": bson.M{"$exists": false}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "meta", Value: bson.D{
+			{Key: "device_id", Value: bson.M{"$ifNull": bson.A{"$device_id", deviceID}}},
+			{Key: "sensor_id", Value: "$sensor_id"},
+		}}}}},
+		{{Key: "$unset", Value: bson.A{"device_id", "sensor_id"}}},
+	})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("Migrated %d readings to the 'meta' field", result.ModifiedCount)
+	}
+
+	specs, err := collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if slices.Contains(legacyIndexNames, spec.Name) {
+			if _, err := collection.Indexes().DropOne(ctx, spec.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moveToMeta moves the top-level device_id and sensor_id of a reading document written by
+// an older version into meta. A missing device_id is replaced with deviceID. The fields of
+// meta are kept in the order of readingMeta, so the readings of a sensor share a series.
+func moveToMeta(doc bson.M, deviceID string) {
+	if meta, ok := doc["meta"].(bson.M); ok {
+		doc["meta"] = bson.D{{Key: "device_id", Value: meta["device_id"]}, {Key: "sensor_id", Value: meta["sensor_id"]}}
+		return
+	}
+	if id, ok := doc["device_id"].(string); ok && id != "" {
+		deviceID = id
+	}
+	doc["meta"] = bson.D{{Key: "device_id", Value: deviceID}, {Key: "sensor_id", Value: doc["sensor_id"]}}
+	delete(doc, "device_id")
+	delete(doc, "sensor_id")
+}
+
+// copyReadings inserts every document of the collection from into the collection to,
+// after passing it to convert, in batches of migrationBatchSize.
+func (m *MongoDB) copyReadings(ctx context.Context, from, to string, convert func(doc bson.M)) error {
+	cursor, err := m.database.Collection(from).Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	batch := make([]any, 0, migrationBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := m.database.Collection(to).InsertMany(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		convert(doc)
+		batch = append(batch, doc)
+		if len(batch) == migrationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// moveReadings moves the documents of migratedCollection into 'readings' in _id order,
+// deleting each batch once inserted. Time-series collections do not enforce unique IDs, so
+// the first batch leaves out the documents already inserted by an interrupted move.
+func (m *MongoDB) moveReadings(ctx context.Context, deviceID string) error {
+	from := m.database.Collection(migratedCollection)
+	to := m.database.Collection("readings")
+
+	for first := true; ; first = false {
+		cursor, err := from.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(migrationBatchSize))
+		if err != nil {
+			return err
+		}
+		var docs []bson.M
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+
+		ids := make(bson.A, len(docs))
+		for i, doc := range docs {
+			ids[i] = doc["_id"]
+		}
+		var present []any
+		if first {
+			if present, err = to.Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+				return err
+			}
+		}
+
+		batch := make([]any, 0, len(docs))
+		for _, doc := range docs {
+			if !slices.ContainsFunc(present, func(id any) bool { return id == doc["_id"] }) {
+				moveToMeta(doc, deviceID)
+				batch = append(batch, doc)
+			}
+		}
+		if len(batch) > 0 {
+			if _, err := to.InsertMany(ctx, batch); err != nil {
+				return err
+			}
+		}
+		if _, err := from.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return err
+		}
+	}
+}
+
+// createReadingsCollection creates 'readings' as a time-series collection.
+func (m *MongoDB) createReadingsCollection(ctx context.Context, opts ReadingsOptions) error {
+	granularity := opts.Granularity
+	if granularity == "" {
+		granularity = "seconds"
+	}
+
+	create := options.CreateCollection().SetTimeSeriesOptions(options.TimeSeries().
+		SetTimeField("timestamp").
+		SetMetaField("meta").
+		SetGranularity(granularity))
+	if opts.Retention > 0 {
+		create.SetExpireAfterSeconds(int64(opts.Retention.Seconds()))
+	}
+
+	return m.database.CreateCollection(ctx, "readings", create)
+}
+
+// setTimeSeriesRetention updates expireAfterSeconds on the time-series 'readings' collection.
+func (m *MongoDB) setTimeSeriesRetention(ctx context.Context, retention time.Duration) error {
+	var expire any = "off"
+	if retention > 0 {
+		expire = int64(retention.Seconds())
+	}
+
+	return m.database.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: "readings"},
+		{Key: "expireAfterSeconds", Value: expire},
+	}).Err()
+}
+
+// setTTLIndex creates, updates or drops the TTL index on a plain 'readings' collection.
+func (m *MongoDB) setTTLIndex(ctx context.Context, retention time.Duration) error {
+	indexes := m.database.Collection("readings").Indexes()
+
+	specs, err := indexes.ListSpecifications(ctx)
+	if err != nil {
+		return err
+	}
+
+	exists := false
+	for _, spec := range specs {
+		if spec.Name == ttlIndexName {
+			exists = true
+			break
+		}
+	}
+
+	switch {
+	case retention <= 0 && exists:
+		_, err = indexes.DropOne(ctx, ttlIndexName)
+	case retention <= 0:
+	case exists:
+		err = m.database.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: "readings"},
+			{Key: "index", Value: bson.D{
+				{Key: "name", Value: ttlIndexName},
+				{Key: "expireAfterSeconds", Value: int64(retention.Seconds())},
+			}},
+		}).Err()
+	default:
+		_, err = indexes.CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "timestamp", Value: 1}},
+			Options: options.Index().SetName(ttlIndexName).SetExpireAfterSeconds(int32(retention.Seconds())),
+		})
+	}
+	return err
+}
+
+// retentionString formats a retention period for logging.
+func retentionString(retention time.Duration) string {
+	if retention <= 0 {
+		return "unlimited"
+	}
+	return retention.String()
+}
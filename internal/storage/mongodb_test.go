@@ -2,9 +2,17 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"reflect"
 	"testing"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"iot-device-simulator/internal/config"
 	"iot-device-simulator/internal/sensor"
 )
 
@@ -19,6 +27,7 @@ func TestMongoDB_SaveReading(t *testing.T) {
 	defer mongodb.Close()
 
 	reading := sensor.Reading{
+		DeviceID:  testDeviceID,
 		SensorID:  "test-sensor-reading",
 		Type:      "temperature",
 		Value:     25.5,
@@ -26,7 +35,7 @@ func TestMongoDB_SaveReading(t *testing.T) {
 		Timestamp: time.Now(),
 	}
 
-	err = mongodb.SaveReading(reading)
+	err = mongodb.SaveReading(context.Background(), reading)
 	if err != nil {
 		t.Errorf("Error saving reading: %v", err)
 	}
@@ -42,18 +51,215 @@ func TestMongoDB_SaveConfig(t *testing.T) {
 	}
 	defer mongodb.Close()
 
-	configs := map[string]any{
-		"temp-01": map[string]any{
-			"id":        "temp-01",
-			"type":      "temperature",
-			"frequency": "5s",
-			"min":       20.0,
-			"max":       30.0,
+	configs := map[string]config.SensorConfig{
+		"temp-01": {
+			ID:        "temp-01",
+			Type:      "temperature",
+			Frequency: 5 * time.Second,
+			Min:       20.0,
+			Max:       30.0,
 		},
 	}
 
-	err = mongodb.SaveConfig("test-device-config", configs)
+	err = mongodb.SaveConfig(context.Background(), "test-device-config", configs)
 	if err != nil {
 		t.Errorf("Error saving config: %v", err)
 	}
+
+	var doc struct {
+		Configs map[string]config.SensorConfig `bson:"configs"`
+	}
+	err = mongodb.database.Collection("configurations").FindOne(context.Background(), map[string]any{"device_id": "test-device-config"}).Decode(&doc)
+	if err != nil {
+		t.Fatalf("Error reading saved config: %v", err)
+	}
+	if !reflect.DeepEqual(doc.Configs["temp-01"], configs["temp-01"]) {
+		t.Errorf("Expected saved config %+v, got %+v", configs["temp-01"], doc.Configs["temp-01"])
+	}
+}
+
+// TestMongoDB_EnsureSchema tests that the readings collection is created as a time-series
+// collection with a meta metaField, and that its retention is updated. It skips the test
+// if MongoDB is not available.
+func TestMongoDB_EnsureSchema(t *testing.T) {
+	mongodb, err := NewMongoDB("mongodb://localhost:27017", "test_iot_schema")
+	if err != nil {
+		t.Skip("MongoDB not available, skipping test")
+	}
+	defer mongodb.Close()
+
+	ctx := context.Background()
+	mongodb.database.Drop(ctx)
+	defer mongodb.database.Drop(ctx)
+
+	opts := ReadingsOptions{Retention: 24 * time.Hour}
+	if err := mongodb.EnsureSchema(ctx, opts); err != nil {
+		t.Fatalf("Error ensuring schema: %v", err)
+	}
+
+	// A second call keeps the existing collection and updates its retention.
+	opts.Retention = 48 * time.Hour
+	if err := mongodb.EnsureSchema(ctx, opts); err != nil {
+		t.Fatalf("Error ensuring schema twice: %v", err)
+	}
+
+	specs, err := mongodb.database.ListCollectionSpecifications(ctx, map[string]any{"name": "readings"})
+	if err != nil || len(specs) != 1 {
+		t.Fatalf("Expected the readings collection to exist, got %v, %v", specs, err)
+	}
+	if specs[0].Type != "timeseries" {
+		t.Errorf("Expected a time-series collection, got %s", specs[0].Type)
+	}
+	if meta := specs[0].Options.Lookup("timeseries", "metaField").StringValue(); meta != "meta" {
+		t.Errorf("Expected metaField meta, got %s", meta)
+	}
+	if expire := specs[0].Options.Lookup("expireAfterSeconds").AsInt64(); expire != int64((48 * time.Hour).Seconds()) {
+		t.Errorf("Expected the retention to be updated to 48h, got %ds", expire)
+	}
+
+	reading := sensor.Reading{DeviceID: testDeviceID, SensorID: "temp-01", Type: "temperature", Value: 21, Timestamp: time.Now().UTC().Truncate(time.Millisecond)}
+	if err := mongodb.SaveReading(ctx, reading); err != nil {
+		t.Fatalf("Error saving reading to time-series collection: %v", err)
+	}
+	readings, err := mongodb.GetLatestReadings(ctx, testDeviceID, "temp-01", 1)
+	if err != nil || len(readings) != 1 || !reflect.DeepEqual(readings[0], reading) {
+		t.Errorf("Expected to read back %+v, got %+v, %v", reading, readings, err)
+	}
+}
+
+// TestMoveToMeta tests that the device and sensor IDs of an old reading document move
+// into meta, that a missing device ID is filled in, and that meta keeps its field order.
+func TestMoveToMeta(t *testing.T) {
+	tests := []struct {
+		doc  bson.M
+		want bson.M
+	}{
+		{
+			bson.M{"device_id": "device-002", "sensor_id": "temp-01", "value": 21.0},
+			bson.M{"meta": bson.D{{Key: "device_id", Value: "device-002"}, {Key: "sensor_id", Value: "temp-01"}}, "value": 21.0},
+		},
+		{
+			bson.M{"sensor_id": "temp-01", "value": 21.0},
+			bson.M{"meta": bson.D{{Key: "device_id", Value: testDeviceID}, {Key: "sensor_id", Value: "temp-01"}}, "value": 21.0},
+		},
+		{
+			bson.M{"meta": bson.M{"sensor_id": "temp-01", "device_id": "device-002"}},
+			bson.M{"meta": bson.D{{Key: "device_id", Value: "device-002"}, {Key: "sensor_id", Value: "temp-01"}}},
+		},
+	}
+	for _, tt := range tests {
+		moveToMeta(tt.doc, testDeviceID)
+		if !reflect.DeepEqual(tt.doc, tt.want) {
+			t.Errorf("Expected %v, got %v", tt.want, tt.doc)
+		}
+	}
+}
+
+// TestMongoDB_MigrateReadings tests that the readings of older versions are found after
+// EnsureSchema migrates a plain collection, or MigrateReadings a time-series collection with
+// a sensor_id metaField, which EnsureSchema refuses. It skips the test if MongoDB is not available.
+func TestMongoDB_MigrateReadings(t *testing.T) {
+	mongodb, err := NewMongoDB("mongodb://localhost:27017", "test_iot_migrate")
+	if err != nil {
+		t.Skip("MongoDB not available, skipping test")
+	}
+	defer mongodb.Close()
+
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	old := []any{
+		bson.M{"device_id": "device-002", "sensor_id": "temp-01", "value": 21.0, "timestamp": now},
+		bson.M{"sensor_id": "temp-01", "value": 22.0, "timestamp": now},
+	}
+
+	for _, timeSeries := range []bool{true, false} {
+		mongodb.database.Drop(ctx)
+		create := options.CreateCollection()
+		if timeSeries {
+			create.SetTimeSeriesOptions(options.TimeSeries().SetTimeField("timestamp").SetMetaField("sensor_id"))
+		}
+		if err := mongodb.database.CreateCollection(ctx, "readings", create); err != nil {
+			t.Fatalf("Error creating old readings collection: %v", err)
+		}
+		if _, err := mongodb.database.Collection("readings").InsertMany(ctx, old); err != nil {
+			t.Fatalf("Error inserting old readings: %v", err)
+		}
+
+		opts := ReadingsOptions{DeviceID: testDeviceID}
+		if timeSeries {
+			if err := mongodb.EnsureSchema(ctx, opts); !errors.Is(err, ErrMigrationRequired) {
+				t.Fatalf("Expected ErrMigrationRequired, got %v", err)
+			}
+			if err := mongodb.MigrateReadings(ctx, opts); err != nil {
+				t.Fatalf("Error migrating readings: %v", err)
+			}
+		}
+		if err := mongodb.EnsureSchema(ctx, opts); err != nil {
+			t.Fatalf("Error ensuring schema (time-series %v): %v", timeSeries, err)
+		}
+
+		for deviceID, value := range map[string]float64{"device-002": 21, testDeviceID: 22} {
+			readings, err := mongodb.GetLatestReadings(ctx, deviceID, "temp-01", 10)
+			if err != nil || len(readings) != 1 || readings[0].Value != value {
+				t.Errorf("Time-series %v: expected the reading %v of %s, got %+v, %v", timeSeries, value, deviceID, readings, err)
+			}
+		}
+	}
+	mongodb.database.Drop(ctx)
+}
+
+// TestMongoDB_ResumeMigration tests that an interrupted migration keeps the readings written
+// since, moves the copied readings back without duplicates, and leaves an old collection
+// that gained readings alone. It skips the test if MongoDB is not available.
+func TestMongoDB_ResumeMigration(t *testing.T) {
+	mongodb, err := NewMongoDB("mongodb://localhost:27017", "test_iot_resume_migration")
+	if err != nil {
+		t.Skip("MongoDB not available, skipping test")
+	}
+	defer mongodb.Close()
+
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	reading := func(value float64) bson.M {
+		return bson.M{"_id": primitive.NewObjectID(), "meta": bson.D{{Key: "device_id", Value: testDeviceID}, {Key: "sensor_id", Value: "temp-01"}}, "value": value, "timestamp": now}
+	}
+	copied := []any{reading(1), reading(2)}
+
+	// Interrupted while moving back: the first copied reading is already in 'readings'.
+	mongodb.database.Drop(ctx)
+	defer mongodb.database.Drop(ctx)
+	if err := mongodb.createReadingsCollection(ctx, ReadingsOptions{}); err != nil {
+		t.Fatalf("Error creating readings collection: %v", err)
+	}
+	mongodb.database.Collection("readings").InsertMany(ctx, []any{copied[0], reading(3)})
+	mongodb.database.Collection(migratedCollection).InsertMany(ctx, copied)
+
+	if err := mongodb.EnsureSchema(ctx, ReadingsOptions{}); !errors.Is(err, ErrMigrationRequired) {
+		t.Fatalf("Expected ErrMigrationRequired, got %v", err)
+	}
+	if err := mongodb.MigrateReadings(ctx, ReadingsOptions{DeviceID: testDeviceID}); err != nil {
+		t.Fatalf("Error resuming the migration: %v", err)
+	}
+	if n, _ := mongodb.database.Collection("readings").CountDocuments(ctx, bson.M{}); n != 3 {
+		t.Errorf("Expected 3 readings, got %d", n)
+	}
+
+	// Interrupted before dropping an old collection that gained a reading since the copy.
+	mongodb.database.Drop(ctx)
+	create := options.CreateCollection().SetTimeSeriesOptions(options.TimeSeries().SetTimeField("timestamp").SetMetaField("sensor_id"))
+	if err := mongodb.database.CreateCollection(ctx, "readings", create); err != nil {
+		t.Fatalf("Error creating old readings collection: %v", err)
+	}
+	mongodb.database.Collection("readings").InsertMany(ctx, []any{
+		bson.M{"sensor_id": "temp-01", "value": 1.0, "timestamp": now},
+		bson.M{"sensor_id": "temp-01", "value": 2.0, "timestamp": now},
+	})
+	mongodb.database.Collection(migratedCollection).InsertMany(ctx, copied[:1])
+
+	if err := mongodb.MigrateReadings(ctx, ReadingsOptions{DeviceID: testDeviceID}); err == nil {
+		t.Errorf("Expected the migration to stop")
+	}
+	if n, _ := mongodb.database.Collection("readings").CountDocuments(ctx, bson.M{}); n != 2 {
+		t.Errorf("Expected the 2 old readings to be kept, got %d", n)
+	}
 }
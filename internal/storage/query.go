@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"iot-device-simulator/internal/sensor"
+)
+
+// Limits on the number of readings returned by one QueryReadings call.
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// ErrInvalidCursor is returned by QueryReadings when the cursor was not produced by a previous page.
+var ErrInvalidCursor = errors.New("storage: invalid cursor")
+
+// ReadingQuery selects stored readings of one device. Readings are returned in
+// ascending (timestamp, sensor_id) order, one page at a time; readings of a sensor that
+// share a timestamp are returned in a stable order.
+type ReadingQuery struct {
+	DeviceID string
+	// SensorIDs restricts the query to these sensors; empty means every sensor of the device.
+	SensorIDs []string
+	// From (inclusive) and To (exclusive) bound the timestamps; a zero time is unbounded.
+	From time.Time
+	To   time.Time
+	// Limit is the page size, DefaultQueryLimit if zero and at most MaxQueryLimit.
+	Limit int
+	// Cursor is the NextCursor of the previous page, or empty for the first page.
+	Cursor string
+	// IncludeErrors also returns readings that only carry a communication error.
+	IncludeErrors bool
+}
+
+// ReadingPage is one page of a ReadingQuery.
+type ReadingPage struct {
+	Readings []sensor.Reading
+	// NextCursor fetches the next page; it is empty on the last page.
+	NextCursor string
+}
+
+// pageLimit returns the effective page size of the query.
+func (q ReadingQuery) pageLimit() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultQueryLimit
+	case q.Limit > MaxQueryLimit:
+		return MaxQueryLimit
+	}
+	return q.Limit
+}
+
+// position is a point in the (timestamp, sensor_id, key) order of a query. The key is
+// the backend's unique ID of a reading, which orders the readings of a sensor that share
+// a timestamp.
+type position struct {
+	timestamp time.Time
+	sensorID  string
+	key       string
+}
+
+// keyedReading is a reading with its backend key.
+type keyedReading struct {
+	sensor.Reading
+	key string
+}
+
+// position returns the position of r.
+func (r keyedReading) position() position {
+	return position{r.Timestamp, r.SensorID, r.key}
+}
+
+// compare returns -1, 0 or +1 depending on whether p comes before, at or after o. Keys
+// are compared as strings; backends with numeric keys encode them with a fixed width.
+func (p position) compare(o position) int {
+	return cmp.Or(p.timestamp.Compare(o.timestamp), strings.Compare(p.sensorID, o.sensorID), strings.Compare(p.key, o.key))
+}
+
+// encode returns the opaque cursor of p.
+func (p position) encode() string {
+	raw := strconv.FormatInt(p.timestamp.UnixNano(), 10) + ":" + p.key + ":" + p.sensorID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor parses a cursor produced by position.encode. An empty cursor
+// returns ok == false.
+func decodeCursor(cursor string) (p position, ok bool, err error) {
+	if cursor == "" {
+		return position{}, false, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return position{}, false, ErrInvalidCursor
+	}
+	ns, rest, found := strings.Cut(string(raw), ":")
+	if !found {
+		return position{}, false, ErrInvalidCursor
+	}
+	key, sensorID, found := strings.Cut(rest, ":")
+	if !found || key == "" {
+		return position{}, false, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(ns, 10, 64)
+	if err != nil {
+		return position{}, false, ErrInvalidCursor
+	}
+	return position{time.Unix(0, n).UTC(), sensorID, key}, true, nil
+}
+
+// matches reports whether a reading satisfies every filter of the query except the cursor.
+func (q ReadingQuery) matches(r sensor.Reading) bool {
+	if r.DeviceID != q.DeviceID {
+		return false
+	}
+	if len(q.SensorIDs) > 0 && !slices.Contains(q.SensorIDs, r.SensorID) {
+		return false
+	}
+	if !q.From.IsZero() && r.Timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !r.Timestamp.Before(q.To) {
+		return false
+	}
+	return q.IncludeErrors || r.Error == ""
+}
+
+// sortReadings orders readings by (timestamp, sensor_id, key), the order of every query.
+func sortReadings(readings []keyedReading) {
+	slices.SortFunc(readings, func(a, b keyedReading) int {
+		return a.position().compare(b.position())
+	})
+}
+
+// newPage turns up to limit+1 sorted readings into a page of at most limit readings,
+// with a cursor if the extra reading shows there are more.
+func newPage(readings []keyedReading, limit int) ReadingPage {
+	page := ReadingPage{Readings: make([]sensor.Reading, 0, min(len(readings), limit))}
+	for _, r := range readings[:min(len(readings), limit)] {
+		page.Readings = append(page.Readings, r.Reading)
+	}
+	if len(readings) > limit {
+		page.NextCursor = readings[limit-1].position().encode()
+	}
+	return page
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/sensor"
+)
+
+// testQueryReadings checks QueryReadings filtering and pagination against any backend.
+func testQueryReadings(t *testing.T, s Storage) {
+	ctx := context.Background()
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		readings := []sensor.Reading{
+			{DeviceID: testDeviceID, SensorID: "temp-01", Value: float64(i), Timestamp: at},
+			{DeviceID: testDeviceID, SensorID: "temp-02", Value: float64(10 + i), Timestamp: at},
+			{DeviceID: "device-002", SensorID: "temp-01", Value: float64(100 + i), Timestamp: at},
+		}
+		if i == 2 {
+			readings[0].Error = "sensor communication error"
+			readings[0].Value = 0
+		}
+		for _, r := range readings {
+			if err := s.SaveReading(ctx, r); err != nil {
+				t.Fatalf("Error saving reading: %v", err)
+			}
+		}
+	}
+
+	// Page through every reading of the device, two at a time.
+	var all []sensor.Reading
+	q := ReadingQuery{DeviceID: testDeviceID, Limit: 2, IncludeErrors: true}
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatalf("Pagination did not terminate")
+		}
+		page, err := s.QueryReadings(ctx, q)
+		if err != nil {
+			t.Fatalf("Error querying readings: %v", err)
+		}
+		if len(page.Readings) > 2 {
+			t.Fatalf("Expected at most 2 readings per page, got %d", len(page.Readings))
+		}
+		all = append(all, page.Readings...)
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	if len(all) != 10 {
+		t.Fatalf("Expected 10 readings of %s, got %d", testDeviceID, len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if cmp.Or(all[i].Timestamp.Compare(all[i-1].Timestamp), strings.Compare(all[i].SensorID, all[i-1].SensorID)) <= 0 {
+			t.Errorf("Expected readings ordered by timestamp and sensor, got %v before %v", all[i-1], all[i])
+		}
+	}
+
+	// Sensor, range and error filters.
+	page, err := s.QueryReadings(ctx, ReadingQuery{
+		DeviceID:  testDeviceID,
+		SensorIDs: []string{"temp-01"},
+		From:      start.Add(time.Minute),
+		To:        start.Add(4 * time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Error querying readings: %v", err)
+	}
+	var values []float64
+	for _, r := range page.Readings {
+		values = append(values, r.Value)
+	}
+	if len(values) != 2 || values[0] != 1 || values[1] != 3 {
+		t.Errorf("Expected temp-01 values [1 3] without the error reading, got %v", values)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("Expected no cursor on the last page, got %q", page.NextCursor)
+	}
+
+	// Readings of a sensor sharing a timestamp are not skipped between pages.
+	for _, v := range []float64{1, 2, 3} {
+		reading := sensor.Reading{DeviceID: testDeviceID, SensorID: "temp-03", Value: v, Timestamp: start}
+		if err := s.SaveReading(ctx, reading); err != nil {
+			t.Fatalf("Error saving reading: %v", err)
+		}
+	}
+	values = nil
+	q = ReadingQuery{DeviceID: testDeviceID, SensorIDs: []string{"temp-03"}, Limit: 1}
+	for pages := 0; pages <= 3; pages++ {
+		page, err := s.QueryReadings(ctx, q)
+		if err != nil {
+			t.Fatalf("Error querying readings: %v", err)
+		}
+		for _, r := range page.Readings {
+			values = append(values, r.Value)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	slices.Sort(values)
+	if !slices.Equal(values, []float64{1, 2, 3}) {
+		t.Errorf("Expected the 3 temp-03 readings sharing a timestamp, got %v", values)
+	}
+
+	if _, err := s.QueryReadings(ctx, ReadingQuery{DeviceID: testDeviceID, Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+// TestMemory_QueryReadings tests range queries on the in-memory backend.
+func TestMemory_QueryReadings(t *testing.T) {
+	testQueryReadings(t, NewMemory(0))
+}
+
+// TestSQLite_QueryReadings tests range queries on the SQLite backend.
+func TestSQLite_QueryReadings(t *testing.T) {
+	testQueryReadings(t, newTestSQLite(t))
+}
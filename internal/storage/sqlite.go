@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver, registered as "sqlite"
+
+	"iot-device-simulator/internal/alarm"
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/sensor"
+)
+
+// sqliteSchema creates the tables used by SQLite if they do not exist.
+// Timestamps are stored as Unix nanoseconds, and tags and the values of
+// multi-value readings (fields) as JSON objects.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS readings (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	device_id TEXT    NOT NULL DEFAULT '',
+	sensor_id TEXT    NOT NULL,
+	type      TEXT    NOT NULL,
+	value     REAL    NOT NULL,
+	unit      TEXT    NOT NULL,
+	timestamp INTEGER NOT NULL,
+	error     TEXT    NOT NULL DEFAULT '',
+	tags      TEXT    NOT NULL DEFAULT '',
+	fields    TEXT    NOT NULL DEFAULT '',
+	state     TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS configurations (
+	device_id TEXT    PRIMARY KEY,
+	configs   TEXT    NOT NULL,
+	timestamp INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS alarm_audit (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	device_id     TEXT    NOT NULL,
+	sensor_id     TEXT    NOT NULL,
+	alarm         TEXT    NOT NULL,
+	action        TEXT    NOT NULL,
+	user          TEXT    NOT NULL DEFAULT '',
+	comment       TEXT    NOT NULL DEFAULT '',
+	state         TEXT    NOT NULL,
+	shelved_until INTEGER,
+	timestamp     INTEGER NOT NULL
+);
+`
+
+// sqliteIndexes creates the indices used by SQLite once the tables are migrated.
+const sqliteIndexes = `
+DROP INDEX IF EXISTS idx_readings_sensor_timestamp;
+CREATE INDEX IF NOT EXISTS idx_readings_device_sensor_timestamp ON readings (device_id, sensor_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_alarm_audit_device_sensor_timestamp ON alarm_audit (device_id, sensor_id, timestamp);
+`
+
+// sqliteMigrations lists the columns added to 'readings' after its first release,
+// with the definition used to add them to an existing database.
+var sqliteMigrations = []struct{ column, definition string }{
+	{"device_id", "TEXT NOT NULL DEFAULT ''"},
+	{"tags", "TEXT NOT NULL DEFAULT ''"},
+	{"fields", "TEXT NOT NULL DEFAULT ''"},
+	{"state", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// readingColumns are the columns written and read for a reading, in scan order.
+const readingColumns = "device_id, sensor_id, type, value, unit, timestamp, error, tags, fields, state"
+
+// SQLite stores readings, configurations and the alarm audit trail in an embedded SQLite
+// database file.
+// It implements Storage.
+type SQLite struct {
+	db   *sql.DB
+	path string
+}
+
+// NewSQLite opens (creating if needed) the SQLite database at path and prepares its schema.
+func NewSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serializes writers and avoids SQLITE_BUSY between them.
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, step := range []func(context.Context, *sql.DB) error{createSQLiteSchema, migrateSQLite, createSQLiteIndexes} {
+		if err := step(ctx, db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &SQLite{db: db, path: path}, nil
+}
+
+// createSQLiteSchema creates the tables if they do not exist.
+func createSQLiteSchema(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, sqliteSchema)
+	return err
+}
+
+// createSQLiteIndexes creates the indices if they do not exist.
+func createSQLiteIndexes(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, sqliteIndexes)
+	return err
+}
+
+// migrateSQLite adds the columns of sqliteMigrations that a database created
+// by an older version lacks. Readings stored before get an empty device_id.
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM pragma_table_info('readings')`)
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range sqliteMigrations {
+		if columns[m.column] {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "ALTER TABLE readings ADD COLUMN "+m.column+" "+m.definition); err != nil {
+			return fmt.Errorf("adding column readings.%s: %w", m.column, err)
+		}
+		log.Printf("Migrated SQLite table 'readings': added column %s", m.column)
+	}
+	return nil
+}
+
+// startSpan opens a client span for an operation on the given table.
+func (s *SQLite) startSpan(ctx context.Context, operation, table string) (context.Context, trace.Span) {
+	return startSpan(ctx, "sqlite", s.path, operation, table)
+}
+
+// SaveReading inserts a single sensor reading into the 'readings' table.
+func (s *SQLite) SaveReading(ctx context.Context, reading sensor.Reading) (err error) {
+	ctx, span := s.startSpan(ctx, "SaveReading", "readings")
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	args, err := readingArgs(reading)
+	if err != nil {
+		return err
+	}
+	// A failed statement inserts nothing.
+	_, err = s.db.ExecContext(ctx, `INSERT INTO readings (`+readingColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, args...)
+	return notWritten(err)
+}
+
+// SaveReadings inserts many readings into the 'readings' table in a single transaction.
+// A failed transaction is rolled back, so its errors are marked with ErrNotWritten.
+func (s *SQLite) SaveReadings(ctx context.Context, readings []sensor.Reading) (err error) {
+	ctx, span := s.startSpan(ctx, "SaveReadings", "readings")
+	span.SetAttributes(attribute.Int("db.operation.batch.size", len(readings)))
+	defer func() { endSpan(span, err) }()
+	defer func() { err = notWritten(err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO readings (`+readingColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, reading := range readings {
+		args, err := readingArgs(reading)
+		if err != nil {
+			return err
+		}
+		if _, err = stmt.ExecContext(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveConfig stores the complete configuration of a device in the 'configurations' table,
+// replacing any previous one.
+func (s *SQLite) SaveConfig(ctx context.Context, deviceID string, configs map[string]config.SensorConfig) (err error) {
+	ctx, span := s.startSpan(ctx, "SaveConfig", "configurations")
+	defer func() { endSpan(span, err) }()
+
+	data, err := json.Marshal(configs)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO configurations (device_id, configs, timestamp) VALUES (?, ?, ?)
+		 ON CONFLICT (device_id) DO UPDATE SET configs = excluded.configs, timestamp = excluded.timestamp`,
+		deviceID, string(data), time.Now().UnixNano())
+	return err
+}
+
+// SaveAlarmAudit appends an entry to the 'alarm_audit' table.
+func (s *SQLite) SaveAlarmAudit(ctx context.Context, entry alarm.AuditEntry) (err error) {
+	ctx, span := s.startSpan(ctx, "SaveAlarmAudit", "alarm_audit")
+	defer func() { endSpan(span, err) }()
+
+	var shelvedUntil any
+	if entry.ShelvedUntil != nil {
+		shelvedUntil = entry.ShelvedUntil.UnixNano()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO alarm_audit (device_id, sensor_id, alarm, action, user, comment, state, shelved_until, timestamp)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.DeviceID, entry.SensorID, entry.Alarm, entry.Action, entry.User, entry.Comment, entry.State,
+		shelvedUntil, entry.Timestamp.UnixNano())
+	return err
+}
+
+// GetLatestReadings retrieves the last 'limit' readings for a specific sensorID of a device,
+// ordered by timestamp in descending order. A limit of zero returns all readings.
+func (s *SQLite) GetLatestReadings(ctx context.Context, deviceID, sensorID string, limit int) (readings []sensor.Reading, err error) {
+	ctx, span := s.startSpan(ctx, "GetLatestReadings", "readings")
+	span.SetAttributes(attribute.String("device.id", deviceID), attribute.String("sensor.id", sensorID))
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = -1 // SQLite: no limit
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+readingColumns+` FROM readings
+		 WHERE device_id = ? AND sensor_id = ? ORDER BY timestamp DESC LIMIT ?`,
+		deviceID, sensorID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanReadings(rows)
+}
+
+// QueryReadings returns one page of the readings matching q.
+func (s *SQLite) QueryReadings(ctx context.Context, q ReadingQuery) (page ReadingPage, err error) {
+	ctx, span := s.startSpan(ctx, "QueryReadings", "readings")
+	span.SetAttributes(attribute.String("device.id", q.DeviceID))
+	defer func() { endSpan(span, err) }()
+
+	cursor, hasCursor, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return ReadingPage{}, err
+	}
+
+	where := []string{"device_id = ?"}
+	args := []any{q.DeviceID}
+	if len(q.SensorIDs) > 0 {
+		where = append(where, "sensor_id IN (?"+strings.Repeat(", ?", len(q.SensorIDs)-1)+")")
+		for _, id := range q.SensorIDs {
+			args = append(args, id)
+		}
+	}
+	if !q.From.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, q.From.UnixNano())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "timestamp < ?")
+		args = append(args, q.To.UnixNano())
+	}
+	if !q.IncludeErrors {
+		where = append(where, "error = ''")
+	}
+	if hasCursor {
+		id, err := strconv.ParseInt(cursor.key, 16, 64)
+		if err != nil {
+			return ReadingPage{}, ErrInvalidCursor
+		}
+		ns := cursor.timestamp.UnixNano()
+		where = append(where, "(timestamp > ? OR (timestamp = ? AND sensor_id > ?) OR (timestamp = ? AND sensor_id = ? AND id > ?))")
+		args = append(args, ns, ns, cursor.sensorID, ns, cursor.sensorID, id)
+	}
+	limit := q.pageLimit()
+	args = append(args, limit+1)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+readingColumns+`, id FROM readings
+		 WHERE `+strings.Join(where, " AND ")+` ORDER BY timestamp, sensor_id, id LIMIT ?`,
+		args...)
+	if err != nil {
+		return ReadingPage{}, err
+	}
+	defer rows.Close()
+
+	var readings []keyedReading
+	for rows.Next() {
+		var id int64
+		r, err := scanReading(rows, &id)
+		if err != nil {
+			return ReadingPage{}, err
+		}
+		readings = append(readings, keyedReading{r, fmt.Sprintf("%016x", id)})
+	}
+	if err := rows.Err(); err != nil {
+		return ReadingPage{}, err
+	}
+	return newPage(readings, limit), nil
+}
+
+// AggregateReadings summarizes the readings of a sensor. SQLite lacks standard
+// deviation and percentile functions, so the statistics are computed in Go.
+func (s *SQLite) AggregateReadings(ctx context.Context, q AggregateQuery) (buckets []AggregateBucket, err error) {
+	ctx, span := s.startSpan(ctx, "AggregateReadings", "readings")
+	span.SetAttributes(attribute.String("device.id", q.DeviceID), attribute.String("sensor.id", q.SensorID))
+	defer func() { endSpan(span, err) }()
+
+	return aggregateByQuery(ctx, s, q)
+}
+
+// readingArgs returns the values of readingColumns for a reading.
+func readingArgs(r sensor.Reading) ([]any, error) {
+	tags, err := jsonColumn(r.Tags)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := jsonColumn(r.Values)
+	if err != nil {
+		return nil, err
+	}
+	return []any{r.DeviceID, r.SensorID, r.Type, r.Value, r.Unit, r.Timestamp.UnixNano(), r.Error, tags, fields, r.State}, nil
+}
+
+// jsonColumn encodes a map as a JSON column, empty if the map is.
+func jsonColumn[V any](m map[string]V) (string, error) {
+	if len(m) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+// scanReadings reads every row of a query selecting readingColumns.
+func scanReadings(rows *sql.Rows) ([]sensor.Reading, error) {
+	var readings []sensor.Reading
+	for rows.Next() {
+		r, err := scanReading(rows)
+		if err != nil {
+			return nil, err
+		}
+		readings = append(readings, r)
+	}
+	return readings, rows.Err()
+}
+
+// scanReading reads the current row of a query selecting readingColumns, followed by
+// the columns scanned into extra.
+func scanReading(rows *sql.Rows, extra ...any) (sensor.Reading, error) {
+	var (
+		r            sensor.Reading
+		ns           int64
+		tags, fields string
+	)
+	dest := append([]any{&r.DeviceID, &r.SensorID, &r.Type, &r.Value, &r.Unit, &ns, &r.Error, &tags, &fields, &r.State}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return sensor.Reading{}, err
+	}
+	r.Timestamp = time.Unix(0, ns).UTC()
+	if tags != "" {
+		if err := json.Unmarshal([]byte(tags), &r.Tags); err != nil {
+			return sensor.Reading{}, fmt.Errorf("decoding tags of reading %s: %w", r.SensorID, err)
+		}
+	}
+	if fields != "" {
+		if err := json.Unmarshal([]byte(fields), &r.Values); err != nil {
+			return sensor.Reading{}, fmt.Errorf("decoding values of reading %s: %w", r.SensorID, err)
+		}
+	}
+	return r, nil
+}
+
+// Close closes the database.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"iot-device-simulator/internal/alarm"
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/sensor"
+)
+
+// newTestSQLite opens a SQLite store in a temporary directory.
+func newTestSQLite(t *testing.T) *SQLite {
+	t.Helper()
+	s, err := NewSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Error opening SQLite: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// TestSQLite_GetLatestReadings tests that readings come back newest first and limited.
+func TestSQLite_GetLatestReadings(t *testing.T) {
+	s := newTestSQLite(t)
+	saveValues(t, s, "temp-01", 1, 2, 3)
+	saveValues(t, s, "temp-02", 10)
+
+	readings, err := s.GetLatestReadings(context.Background(), testDeviceID, "temp-01", 2)
+	if err != nil {
+		t.Fatalf("Error getting readings: %v", err)
+	}
+
+	if len(readings) != 2 {
+		t.Fatalf("Expected 2 readings, got %d", len(readings))
+	}
+	if readings[0].Value != 3 || readings[1].Value != 2 {
+		t.Errorf("Expected values [3 2], got [%.0f %.0f]", readings[0].Value, readings[1].Value)
+	}
+	if readings[0].Timestamp.Before(readings[1].Timestamp) {
+		t.Errorf("Expected readings ordered newest first")
+	}
+
+	all, _ := s.GetLatestReadings(context.Background(), testDeviceID, "temp-01", 0)
+	if len(all) != 3 {
+		t.Errorf("Expected all 3 readings with no limit, got %d", len(all))
+	}
+}
+
+// TestSQLite_Migrate tests that a database created before readings had a device_id
+// is migrated on open, and that device IDs, tags, values and states round-trip afterwards.
+func TestSQLite_Migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE readings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, sensor_id TEXT NOT NULL, type TEXT NOT NULL,
+		value REAL NOT NULL, unit TEXT NOT NULL, timestamp INTEGER NOT NULL, error TEXT NOT NULL DEFAULT '');
+		INSERT INTO readings (sensor_id, type, value, unit, timestamp) VALUES ('temp-01', 'temperature', 1, 'C', 1)`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("Error creating old schema: %v", err)
+	}
+
+	s, err := NewSQLite(path)
+	if err != nil {
+		t.Fatalf("Error opening old database: %v", err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	if old, _ := s.GetLatestReadings(ctx, "", "temp-01", 0); len(old) != 1 {
+		t.Errorf("Expected the old reading under an empty device ID, got %v", old)
+	}
+
+	reading := sensor.Reading{
+		DeviceID:  testDeviceID,
+		SensorID:  "temp-01",
+		Value:     2,
+		Values:    map[string]float64{"x": 0.5, "y": -1},
+		State:     "open",
+		Timestamp: time.Now(),
+		Tags:      map[string]string{"site": "lab"},
+	}
+	if err := s.SaveReading(ctx, reading); err != nil {
+		t.Fatalf("Error saving reading: %v", err)
+	}
+	readings, err := s.GetLatestReadings(ctx, testDeviceID, "temp-01", 0)
+	if err != nil {
+		t.Fatalf("Error getting readings: %v", err)
+	}
+	if len(readings) != 1 || readings[0].DeviceID != testDeviceID || readings[0].Tags["site"] != "lab" {
+		t.Fatalf("Expected the new reading with its device and tags, got %v", readings)
+	}
+	if !reflect.DeepEqual(readings[0].Values, reading.Values) || readings[0].State != "open" {
+		t.Errorf("Expected values %v and state open, got %v and %q", reading.Values, readings[0].Values, readings[0].State)
+	}
+}
+
+// TestSQLite_Config tests saving and replacing a device configuration.
+func TestSQLite_Config(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+
+	cfg := config.SensorConfig{ID: "temp-01", Type: "temperature", Frequency: 5 * time.Second, Min: 15, Max: 35}
+	s.SaveConfig(ctx, "device-001", map[string]config.SensorConfig{"temp-01": cfg})
+
+	cfg.Frequency = 2 * time.Second
+	if err := s.SaveConfig(ctx, "device-001", map[string]config.SensorConfig{"temp-01": cfg}); err != nil {
+		t.Fatalf("Error replacing config: %v", err)
+	}
+
+	var data string
+	if err := s.db.QueryRowContext(ctx, `SELECT configs FROM configurations WHERE device_id = ?`, "device-001").Scan(&data); err != nil {
+		t.Fatalf("Error reading saved config: %v", err)
+	}
+	var loaded map[string]config.SensorConfig
+	if err := json.Unmarshal([]byte(data), &loaded); err != nil {
+		t.Fatalf("Error decoding saved config: %v", err)
+	}
+	if !reflect.DeepEqual(loaded["temp-01"], cfg) {
+		t.Errorf("Expected %+v, got %+v", cfg, loaded["temp-01"])
+	}
+}
+
+// TestSQLite_AlarmAudit tests that audit entries are appended to the 'alarm_audit' table.
+func TestSQLite_AlarmAudit(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	now := time.Now()
+	until := now.Add(time.Hour)
+
+	entries := []alarm.AuditEntry{
+		{DeviceID: "device-001", SensorID: "temp-01", Alarm: "high", Action: alarm.ActionShelve, User: "op",
+			Comment: "sensor under maintenance", State: alarm.StateShelved, ShelvedUntil: &until, Timestamp: now},
+		{DeviceID: "device-001", SensorID: "temp-01", Alarm: "high", Action: alarm.ActionUnshelve, State: alarm.StateNormal, Timestamp: now},
+	}
+	for _, entry := range entries {
+		if err := s.SaveAlarmAudit(ctx, entry); err != nil {
+			t.Fatalf("Error saving audit entry: %v", err)
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT action, user, comment, shelved_until FROM alarm_audit ORDER BY id`)
+	if err != nil {
+		t.Fatalf("Error querying audit entries: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var action, user, comment string
+		var shelvedUntil sql.NullInt64
+		if err := rows.Scan(&action, &user, &comment, &shelvedUntil); err != nil {
+			t.Fatalf("Error scanning audit entry: %v", err)
+		}
+		got = append(got, action)
+		if action == alarm.ActionShelve && (user != "op" || comment != "sensor under maintenance" || shelvedUntil.Int64 != until.UnixNano()) {
+			t.Errorf("Unexpected shelve entry: %s, %s, %v", user, comment, shelvedUntil)
+		}
+		if action == alarm.ActionUnshelve && shelvedUntil.Valid {
+			t.Errorf("Expected no expiry on the unshelve entry, got %v", shelvedUntil)
+		}
+	}
+	if len(got) != 2 || got[0] != alarm.ActionShelve || got[1] != alarm.ActionUnshelve {
+		t.Errorf("Expected shelve then unshelve, got %v", got)
+	}
+}
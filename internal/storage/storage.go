@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"iot-device-simulator/internal/alarm"
+	"iot-device-simulator/internal/config"
+	"iot-device-simulator/internal/sensor"
+)
+
+// Backend names accepted in the storage.backend configuration field.
+const (
+	BackendMongoDB = "mongodb"
+	BackendMemory  = "memory"
+	BackendSQLite  = "sqlite"
+	BackendNone    = "none"
+)
+
+var tracer = otel.Tracer("iot-device-simulator/internal/storage")
+
+// Storage is the persistence interface used by the device.
+// It extends sensor.Storage, so every backend can also be handed to sensors.
+type Storage interface {
+	sensor.Storage
+
+	// SaveConfig stores the configuration of every sensor of a device, replacing any previous one.
+	SaveConfig(ctx context.Context, deviceID string, configs map[string]config.SensorConfig) error
+
+	// GetLatestReadings returns up to limit readings of a device's sensor, newest first.
+	GetLatestReadings(ctx context.Context, deviceID, sensorID string, limit int) ([]sensor.Reading, error)
+
+	// QueryReadings returns one page of the readings matching q, or ErrInvalidCursor.
+	QueryReadings(ctx context.Context, q ReadingQuery) (ReadingPage, error)
+
+	// AggregateReadings summarizes the readings of a sensor per time bucket, oldest first.
+	// Buckets without readings are omitted.
+	AggregateReadings(ctx context.Context, q AggregateQuery) ([]AggregateBucket, error)
+
+	// SaveAlarmAudit appends an entry to the audit trail of alarm actions.
+	SaveAlarmAudit(ctx context.Context, entry alarm.AuditEntry) error
+
+	// Close releases the resources held by the backend.
+	Close() error
+}
+
+// Open creates the backend selected in cfg. Persistent backends are wrapped in a
+// BatchWriter if batching is enabled, and in a Cached store unless the cache is
+// disabled. It returns a nil Storage and no error for the "none" backend. deviceID is
+// given to MongoDB readings of older versions that did not record their device. A MongoDB
+// 'readings' collection that must be migrated first returns ErrMigrationRequired.
+func Open(cfg config.StorageConfig, deviceID string) (Storage, error) {
+	switch cfg.Backend {
+	case "", BackendMongoDB:
+		mongodb, err := NewMongoDB(cfg.MongoDB.URI, cfg.MongoDB.Database)
+		if err != nil {
+			return nil, err
+		}
+
+		// Long enough to migrate a plain readings collection of an older version in place.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		err = mongodb.EnsureSchema(ctx, ReadingsOptions{
+			Retention:   cfg.MongoDB.Retention,
+			Granularity: cfg.MongoDB.Granularity,
+			DeviceID:    deviceID,
+		})
+		switch {
+		case errors.Is(err, ErrMigrationRequired):
+			mongodb.Close()
+			return nil, err
+		case err != nil:
+			slog.Warn(fmt.Sprintf("Could not prepare MongoDB collections, continuing without them: %v", err))
+		}
+		return withCache(cfg, withBatch(cfg, mongodb)), nil
+	case BackendMemory:
+		return NewMemory(cfg.Memory.Capacity), nil
+	case BackendNone:
+		return nil, nil
+	case BackendSQLite:
+		sqlite, err := NewSQLite(cfg.SQLite.Path)
+		if err != nil {
+			return nil, err
+		}
+		return withCache(cfg, withBatch(cfg, sqlite)), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// withBatch wraps backend in a BatchWriter if batching is enabled in cfg.
+func withBatch(cfg config.StorageConfig, backend Storage) Storage {
+	if !cfg.Batch.Enabled {
+		return backend
+	}
+	return NewBatchWriter(backend, cfg.Batch)
+}
+
+// withCache wraps backend in a Cached store if the cache is enabled in cfg.
+func withCache(cfg config.StorageConfig, backend Storage) Storage {
+	if !cfg.CacheEnabled() {
+		return backend
+	}
+	return NewCached(backend, cfg.Memory.Capacity)
+}
+
+// startSpan opens a client span for a database operation.
+func startSpan(ctx context.Context, system, namespace, operation, collection string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, system+"."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", system),
+			attribute.String("db.namespace", namespace),
+			attribute.String("db.collection.name", collection),
+			attribute.String("db.operation.name", operation),
+		))
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"testing"
+
+	"iot-device-simulator/internal/config"
+)
+
+// TestOpen tests backend selection for backends that need no running server.
+func TestOpen(t *testing.T) {
+	store, err := Open(config.StorageConfig{Backend: BackendNone}, testDeviceID)
+	if err != nil || store != nil {
+		t.Errorf("Expected nil storage and no error for the none backend, got %v, %v", store, err)
+	}
+
+	store, err = Open(config.StorageConfig{Backend: BackendMemory}, testDeviceID)
+	if _, ok := store.(*Memory); !ok || err != nil {
+		t.Errorf("Expected a *Memory for the memory backend, got %T, %v", store, err)
+	}
+
+	if _, err := Open(config.StorageConfig{Backend: "cassandra"}, testDeviceID); err == nil {
+		t.Errorf("Expected an error for an unknown backend")
+	}
+}
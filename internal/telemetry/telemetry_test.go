@@ -0,0 +1,84 @@
+// Package telemetry_test contains the unit tests for the telemetry package.
+package telemetry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+
+	"iot-device-simulator/internal/config"
+)
+
+// TestInjectExtract tests that trace context survives a round trip through NATS headers.
+func TestInjectExtract(t *testing.T) {
+	shutdown, err := Setup(context.Background(), "test-device", config.TelemetryConfig{})
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer shutdown(context.Background())
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	msg := &nats.Msg{Subject: "iot.test-device.status"}
+	Inject(ctx, msg)
+
+	if msg.Header.Get("traceparent") == "" {
+		t.Fatalf("Expected traceparent header to be set")
+	}
+
+	got := trace.SpanContextFromContext(Extract(context.Background(), msg))
+	if got.TraceID() != traceID {
+		t.Errorf("Expected trace ID %s, got %s", traceID, got.TraceID())
+	}
+	if !got.IsRemote() {
+		t.Errorf("Expected extracted span context to be remote")
+	}
+}
+
+// TestSetupFileExporter tests that the file exporter writes finished spans to disk.
+func TestSetupFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := Setup(context.Background(), "test-device", config.TelemetryConfig{
+		Exporter: "file",
+		File:     path,
+	})
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test-span")
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read trace file: %v", err)
+	}
+	if !strings.Contains(string(data), "test-span") {
+		t.Errorf("Expected trace file to contain span 'test-span'")
+	}
+}
+
+// TestSetupUnknownExporter tests that an unknown exporter name is rejected.
+func TestSetupUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), "test-device", config.TelemetryConfig{Exporter: "zipkin"}); err == nil {
+		t.Errorf("Expected an error for an unknown exporter")
+	}
+}